@@ -0,0 +1,289 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"movingwindow/persistence"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+	// notifications beyond this many waiting to be sent are dropped, so that the processor never waits for a webhook
+	maxPendingNotifications = 100
+	notificationTimeout     = 5 * time.Second
+)
+
+/* Threshold on the request count of a counter, such as 'count/60s>10000~9000':
+- Name: identifies the alert of the rule.
+- Counter: key of the keyed counter the rule applies to. Empty applies to the counter of the server.
+- Window: duration the requests are counted over. Zero, or longer than the persistence time frame, counts the whole
+  time frame.
+- Above: the rule fires once the count goes above the threshold. Otherwise, once it drops below it.
+- Threshold, Clear: hysteresis. A firing rule only resolves once the count is back to the clear threshold, so that a
+  count hovering around the threshold does not fire over and over. Clear equals the threshold if not given.
+*/
+type AlertRule struct {
+	Name       string
+	Expression string
+	Counter    string
+	Window     time.Duration
+	Above      bool
+	Threshold  int
+	Clear      int
+}
+
+func (r AlertRule) breached(count int) bool {
+	if r.Above {
+		return count > r.Threshold
+	}
+	return count < r.Threshold
+}
+
+func (r AlertRule) cleared(count int) bool {
+	if r.Above {
+		return count <= r.Clear
+	}
+	return count >= r.Clear
+}
+
+/* Alert of a rule, as notified to the webhook whenever its state changes and as listed by '/alerts' while it fires:
+- State: alertFiring or alertResolved.
+- Value: count the state changed with. While the alert fires, the count as of the latest evaluation.
+- Timestamp: time the state changed at.
+*/
+type Alert struct {
+	Rule       string    `json:"rule"`
+	Expression string    `json:"expression"`
+	State      string    `json:"state"`
+	Value      int       `json:"value"`
+	Threshold  int       `json:"threshold"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+/* Evaluates every rule as of the reference, notifying the alerts whose state changes. Must only be called from the
+Timestamp-RequestCount exchanger, whenever a unit of precision rolls over. The state is only read: counts outside of the
+time frame are left for the handling of requests to discard.
+Rules that fire below a threshold are only evaluated once their whole window has been watched: until then, the count
+misses the requests from before the start of the processor, and would fire right away.
+*/
+func (c *communication) evaluateAlerts(reference time.Time) {
+	for _, rule := range c.alertRules {
+		if !rule.Above && reference.Sub(c.alertsSince) < c.alertWindow(rule) {
+			continue
+		}
+		count := c.alertCount(rule, reference)
+		alert, firing := c.activeAlerts[rule.Name]
+		switch {
+		case !firing && rule.breached(count):
+			alert = Alert{Rule: rule.Name, Expression: rule.Expression, State: alertFiring, Value: count, Threshold: rule.Threshold, Timestamp: reference}
+			c.activeAlerts[rule.Name] = alert
+			c.notify(alert)
+		case firing && rule.cleared(count):
+			delete(c.activeAlerts, rule.Name)
+			alert.State, alert.Value, alert.Timestamp = alertResolved, count, reference
+			c.notify(alert)
+		case firing:
+			alert.Value = count
+			c.activeAlerts[rule.Name] = alert
+		}
+	}
+}
+
+/* Duration the requests of a rule are counted over: its window, bounded by the persistence time frame.
+ */
+func (c *communication) alertWindow(rule AlertRule) time.Duration {
+	if rule.Window <= 0 || rule.Window > c.persistenceTimeFrame {
+		return c.persistenceTimeFrame
+	}
+	return rule.Window
+}
+
+/* Request count of the counter of a rule within its window before the reference. In approximate mode, the count of the
+server is estimated over the whole time frame, whatever the window of the rule. See persistence::FixedWindows.
+*/
+func (c *communication) alertCount(rule AlertRule, reference time.Time) int {
+	window := c.alertWindow(rule)
+	var requestCounts []persistence.RequestCount
+	switch {
+	case rule.Counter != "":
+		requestCounts = c.state.Keys[rule.Counter].RequestCounts()
+	case c.approximate:
+		return c.state.Windows.Count(reference, c.persistenceTimeFrame)
+	default:
+		// the Persistence-Accumulated exchanger is idle between requests, so the past can be read safely
+		requestCounts = c.state.RequestCounts()
+	}
+	count, _ := aggregatesWithin(requestCounts, reference, window, c.precision)
+	return count
+}
+
+/* Hands an alert over to the notifier, if a webhook is configured. Drops it if too many are waiting to be sent.
+ */
+func (c *communication) notify(alert Alert) {
+	if c.notifications == nil {
+		return
+	}
+	select {
+	case c.notifications <- alert:
+	default:
+		c.logger.Printf("Alert '%v' could not be notified: too many notifications are waiting to be sent\n", alert.Rule)
+	}
+}
+
+/* Posts every alert to the webhook as JSON, one at a time, until the notifications channel is closed. Failures are
+logged, not retried.
+*/
+func (c *communication) notifier(webhook string) {
+	client := &http.Client{Timeout: notificationTimeout}
+	for alert := range c.notifications {
+		encoded, err := json.Marshal(alert)
+		if err != nil {
+			c.logger.Printf("Alert '%v' could not be encoded: %v\n", alert.Rule, err)
+			continue
+		}
+		response, err := client.Post(webhook, "application/json", bytes.NewReader(encoded))
+		if err != nil {
+			c.logger.Printf("Alert '%v' could not be notified: %v\n", alert.Rule, err)
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			c.logger.Printf("Alert '%v' was rejected by the webhook with status '%v'\n", alert.Rule, response.StatusCode)
+		}
+	}
+}
+
+/* Retrieves the alerts currently firing, ordered by rule. As for snapshot, this is serialized with the handling of
+requests, and applies the same backpressure as exchange.
+*/
+func (c *communication) alerts(ctx context.Context) ([]Alert, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reply := make(chan []Alert, 1)
+	select {
+	case c.exchangeAlerts <- reply:
+	case <-c.lifecycle.done:
+		return nil, errShuttingDown
+	case <-ctx.Done():
+		return nil, errUnavailable
+	}
+	return <-reply, nil
+}
+
+func (c *communication) handleAlerts(reply chan []Alert) {
+	alerts := make([]Alert, 0, len(c.activeAlerts))
+	for _, alert := range c.activeAlerts {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Rule < alerts[j].Rule })
+	reply <- alerts
+}
+
+type AlertsResponse struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+/* Serves the alerts currently firing. Requests to it are not counted.
+ */
+func (s *server) Alerts(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		alerts, err := com.alerts(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, AlertsResponse{Alerts: alerts})
+	})
+}
+
+/* Parses alert rules as given on the command line: a comma separated list of
+'<name>=<counter>[/<window>]<operator><threshold>[~<clear>]', such as 'busy=count/60s>10000~9000,idle=key:tenant:1<5~10'.
+- counter: 'count' for the counter of the server, or 'key:<key>' for a keyed counter. Keys may not hold '/', '<', '>',
+  '~' or ','.
+- window: duration the requests are counted over. Defaults to the persistence time frame.
+- operator: '>' fires once the count goes above the threshold, '<' once it drops below it.
+- clear: count the alert resolves at. Must not be beyond the threshold. Defaults to the threshold.
+*/
+func ParseAlertRules(spec string) ([]AlertRule, error) {
+	var rules []AlertRule
+	if spec == "" {
+		return rules, nil
+	}
+	names := make(map[string]bool)
+	for _, ruleSpec := range strings.Split(spec, ",") {
+		name, expression, _ := strings.Cut(ruleSpec, "=")
+		if name == "" || expression == "" {
+			return nil, fmt.Errorf("invalid alert rule '%v': expected '<name>=<counter>[/<window>]<operator><threshold>[~<clear>]'", ruleSpec)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("invalid alert rule '%v': there is more than one rule named '%v'", ruleSpec, name)
+		}
+		names[name] = true
+		rule, err := parseAlertRule(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert rule '%v': %v", ruleSpec, err)
+		}
+		rule.Name = name
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseAlertRule(expression string) (AlertRule, error) {
+	rule := AlertRule{Expression: expression}
+	split := strings.IndexAny(expression, "<>")
+	if split < 0 {
+		return AlertRule{}, fmt.Errorf("expected '>' or '<' followed by a threshold")
+	}
+	counter, thresholds := expression[:split], expression[split+1:]
+	rule.Above = expression[split] == '>'
+
+	counter, window, windowed := strings.Cut(counter, "/")
+	if windowed {
+		var err error
+		rule.Window, err = time.ParseDuration(window)
+		if err != nil || rule.Window <= 0 {
+			return AlertRule{}, fmt.Errorf("the window must be a positive duration, got '%v'", window)
+		}
+	}
+	kind, key, _ := strings.Cut(counter, ":")
+	switch {
+	case counter == "count":
+	case kind == "key" && key != "":
+		rule.Counter = key
+	default:
+		return AlertRule{}, fmt.Errorf("unknown counter '%v': expected 'count' or 'key:<key>'", counter)
+	}
+
+	threshold, clear, hysteresis := strings.Cut(thresholds, "~")
+	var err error
+	rule.Threshold, err = strconv.Atoi(threshold)
+	if err != nil || rule.Threshold < 0 {
+		return AlertRule{}, fmt.Errorf("the threshold must be a non-negative integer, got '%v'", threshold)
+	}
+	rule.Clear = rule.Threshold
+	if hysteresis {
+		rule.Clear, err = strconv.Atoi(clear)
+		if err != nil || rule.Clear < 0 {
+			return AlertRule{}, fmt.Errorf("the clear threshold must be a non-negative integer, got '%v'", clear)
+		}
+		if (rule.Above && rule.Clear > rule.Threshold) || (!rule.Above && rule.Clear < rule.Threshold) {
+			return AlertRule{}, fmt.Errorf("the clear threshold '%v' is beyond the threshold '%v'", rule.Clear, rule.Threshold)
+		}
+	}
+	return rule, nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"movingwindow/persistence"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAlertRules(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected []AlertRule
+		fails    bool
+	}{
+		{spec: "", expected: nil},
+		{spec: "busy=count/60s>10000~9000", expected: []AlertRule{{Name: "busy", Expression: "count/60s>10000~9000", Window: time.Minute, Above: true, Threshold: 10000, Clear: 9000}}},
+		{spec: "busy=count>10,idle=key:tenant:1<5~10", expected: []AlertRule{
+			{Name: "busy", Expression: "count>10", Above: true, Threshold: 10, Clear: 10},
+			{Name: "idle", Expression: "key:tenant:1<5~10", Counter: "tenant:1", Threshold: 5, Clear: 10},
+		}},
+		{spec: "busy=count", fails: true},
+		{spec: "busy=requests>10", fails: true},
+		{spec: "busy=key:>10", fails: true},
+		{spec: "busy=count/soon>10", fails: true},
+		{spec: "busy=count>ten", fails: true},
+		{spec: "busy=count>10~20", fails: true},
+		{spec: "idle=count<10~5", fails: true},
+		{spec: "=count>10", fails: true},
+		{spec: "busy=count>10,busy=count>20", fails: true},
+	}
+	for _, test := range tests {
+		rules, err := ParseAlertRules(test.spec)
+		if (err != nil) != test.fails {
+			t.Fatalf("Spec '%v': expected failure to be '%v', got error '%v'\n", test.spec, test.fails, err)
+		}
+		if !test.fails && !reflect.DeepEqual(rules, test.expected) {
+			t.Fatalf("Spec '%v': expected '%+v', got '%+v'\n", test.spec, test.expected, rules)
+		}
+	}
+}
+
+/* Alerts fire once the count crosses the threshold and only resolve once it is back to the clear threshold.
+ */
+func TestEvaluateAlerts(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	rules, _ := ParseAlertRules("busy=key:a/10s>5~2,idle=key:a<1,quiet=key:b<1")
+	com := NewCommunication(Environment{
+		PersistenceTimeFrame: time.Minute,
+		Precision:            time.Second,
+		AlertRules:           rules,
+		AlertWebhook:         "http://localhost",
+	}, log.New(ioutil.Discard, "", 0))
+	com.alertsSince = t0
+
+	// rules below a threshold wait for a full window, even though the count of 'b' is below it from the start
+	steps := []struct {
+		elapsed  time.Duration
+		hits     int
+		expected []Alert
+	}{
+		{elapsed: 0, hits: 3},
+		{elapsed: time.Second, hits: 3, expected: []Alert{{Rule: "busy", State: alertFiring, Value: 6}}},
+		{elapsed: 8 * time.Second, hits: 0},
+		// the hits of the first second left the window, but the count is still above the clear threshold
+		{elapsed: 11 * time.Second, hits: 0},
+		{elapsed: 12 * time.Second, hits: 0, expected: []Alert{{Rule: "busy", State: alertResolved, Value: 0}}},
+		{elapsed: 59 * time.Second, hits: 0},
+		{elapsed: 60 * time.Second, hits: 0, expected: []Alert{{Rule: "quiet", State: alertFiring, Value: 0}}},
+		{elapsed: 62 * time.Second, hits: 0, expected: []Alert{{Rule: "idle", State: alertFiring, Value: 0}}},
+		{elapsed: 63 * time.Second, hits: 1, expected: []Alert{{Rule: "idle", State: alertResolved, Value: 1}}},
+	}
+	com.state.Keys = make(map[string]persistence.State)
+	for i, step := range steps {
+		now := t0.Add(step.elapsed)
+		keyState, _ := com.state.Keys["a"].Hit(now, step.hits, com.persistenceTimeFrame, com.precision, 0)
+		com.state.Keys["a"] = keyState
+		com.evaluateAlerts(now)
+
+		var notified []Alert
+		for len(com.notifications) > 0 {
+			alert := <-com.notifications
+			notified = append(notified, Alert{Rule: alert.Rule, State: alert.State, Value: alert.Value})
+			if !alert.Timestamp.Equal(now) {
+				t.Fatalf("Step '%v': expected the alert at '%v', got '%v'\n", i, now, alert.Timestamp)
+			}
+		}
+		if !reflect.DeepEqual(notified, step.expected) {
+			t.Fatalf("Step '%v': expected '%+v', got '%+v'\n", i, step.expected, notified)
+		}
+	}
+}
+
+/* Alerts are posted to the webhook, and listed while they fire.
+ */
+func TestAlerts(t *testing.T) {
+	notified := make(chan Alert, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Error decoding the alert: %v\n", err)
+		}
+		notified <- alert
+	}))
+	defer webhook.Close()
+
+	rules, _ := ParseAlertRules("busy=count>2")
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            10 * time.Millisecond,
+		PersistenceTimeFrame: time.Minute,
+		AlertRules:           rules,
+		AlertWebhook:         webhook.URL,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	for i := 0; i < 3; i++ {
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	select {
+	case alert := <-notified:
+		if alert.Rule != "busy" || alert.State != alertFiring || alert.Value != 3 || alert.Expression != "count>2" || alert.Threshold != 2 {
+			t.Fatalf("Expected the rule to fire with a count of '3', got '%+v'\n", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the alert to be posted to the webhook\n")
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/alerts", nil))
+	var response AlertsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error decoding '%v': %v\n", w.Body.String(), err)
+	}
+	if len(response.Alerts) != 1 || response.Alerts[0].Rule != "busy" || response.Alerts[0].State != alertFiring {
+		t.Fatalf("Expected the firing alert to be listed, got '%+v'\n", response)
+	}
+}
+
+/* Notifications waiting to be posted when the processor stops are posted before Stop returns.
+ */
+func TestAlertsNotifiedOnStop(t *testing.T) {
+	var mu sync.Mutex
+	var notified []Alert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Error decoding the alert: %v\n", err)
+		}
+		mu.Lock()
+		notified = append(notified, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	rules, _ := ParseAlertRules("busy=count>2")
+	com := NewCommunication(Environment{
+		PersistenceTimeFrame: time.Minute,
+		Precision:            time.Hour,
+		AlertRules:           rules,
+		AlertWebhook:         webhook.URL,
+	}, log.New(ioutil.Discard, "", 0))
+	for i := 0; i < 5; i++ {
+		com.notify(Alert{Rule: "busy", State: alertFiring, Value: i})
+	}
+	com.Start(context.Background())
+	com.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 5 {
+		t.Fatalf("Expected all '5' notifications to be posted before Stop returned, got '%v'\n", len(notified))
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Scopes routes are protected with:
+- scopeHit: routes that count requests, i.e. the index and the ingestion of hits.
+- scopeRead: routes that report counts and figures.
+- scopeAdmin: routes that expose the cluster, including replication. Grants every other scope as well.
+Health checks are never protected, so that orchestrators can probe them.
+*/
+const (
+	scopeHit   = "hit"
+	scopeRead  = "read"
+	scopeAdmin = "admin"
+)
+
+/* Named credential as loaded from the credentials file, along with the scopes it grants.
+ */
+type Credential struct {
+	Name   string
+	Scopes []string
+}
+
+/* Whether the credential grants the scope. The admin scope grants all of them.
+ */
+func (c Credential) grants(scope string) bool {
+	for _, granted := range c.Scopes {
+		if granted == scope || granted == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+/* Credentials requests may authenticate with, as loaded from the credentials file. See ParseCredentials.
+- keys: static API keys, by their SHA-256 hash, so that looking them up does not depend on the key itself.
+- secrets: secrets that tokens are signed with, by the name of their credential. See SignToken.
+*/
+type Credentials struct {
+	keys    map[[sha256.Size]byte]Credential
+	secrets map[string]secret
+}
+
+type secret struct {
+	Credential
+	key []byte
+}
+
+/* Loads the credentials file at the given path. See ParseCredentials.
+ */
+func LoadCredentials(path string) (*Credentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseCredentials(file)
+}
+
+/* Parses credentials, one per line as '<kind> <name> <secret> <scopes>'. Empty lines and lines starting with '#' are
+ignored:
+- kind: 'key' for a static API key, which is the secret itself, or 'hmac' for a secret that tokens are signed with.
+- name: identifies the credential in logs and in tokens. Must be unique.
+- scopes: comma separated scopes the credential grants: 'hit', 'read' and 'admin'.
+*/
+func ParseCredentials(reader io.Reader) (*Credentials, error) {
+	credentials := &Credentials{keys: make(map[[sha256.Size]byte]Credential), secrets: make(map[string]secret)}
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line '%v': expected '<kind> <name> <secret> <scopes>'", line)
+		}
+		kind, name, key, scopes := fields[0], fields[1], fields[2], strings.Split(fields[3], ",")
+		if names[name] {
+			return nil, fmt.Errorf("line '%v': there is more than one credential named '%v'", line, name)
+		}
+		names[name] = true
+		for _, scope := range scopes {
+			if scope != scopeHit && scope != scopeRead && scope != scopeAdmin {
+				return nil, fmt.Errorf("line '%v': unknown scope '%v': expected '%v', '%v' or '%v'", line, scope, scopeHit, scopeRead, scopeAdmin)
+			}
+		}
+
+		credential := Credential{Name: name, Scopes: scopes}
+		switch kind {
+		case "key":
+			hash := sha256.Sum256([]byte(key))
+			if _, duplicate := credentials.keys[hash]; duplicate {
+				return nil, fmt.Errorf("line '%v': the key of '%v' is used by another credential", line, name)
+			}
+			credentials.keys[hash] = credential
+		case "hmac":
+			if strings.Contains(name, ".") {
+				return nil, fmt.Errorf("line '%v': the name of a secret must not hold '.', got '%v'", line, name)
+			}
+			credentials.secrets[name] = secret{Credential: credential, key: []byte(key)}
+		default:
+			return nil, fmt.Errorf("line '%v': unknown kind '%v': expected 'key' or 'hmac'", line, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+/* Signs a token for the credential of the given name, granting the given scopes until it expires. Tokens read
+'<name>.<expiry>.<scopes>.<signature>': the expiry in Unix seconds, the comma separated scopes and the HMAC-SHA256 of
+all three, base64url encoded. Tokens are only accepted for scopes the credential grants.
+*/
+func SignToken(name string, key []byte, scopes []string, expires time.Time) string {
+	payload := name + "." + strconv.FormatInt(expires.Unix(), 10) + "." + strings.Join(scopes, ",")
+	return payload + "." + signature(key, payload)
+}
+
+func signature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/* Credential a static API key or a signed token stands for. Tokens that are malformed, expired or not signed with the
+secret of their credential are not valid.
+*/
+func (c *Credentials) authenticate(token string, now time.Time) (Credential, bool) {
+	if credential, found := c.keys[sha256.Sum256([]byte(token))]; found {
+		return credential, true
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return Credential{}, false
+	}
+	name, expiry, scopes := parts[0], parts[1], strings.Split(parts[2], ",")
+	secret, found := c.secrets[name]
+	if !found {
+		return Credential{}, false
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(signature(secret.key, payload)), []byte(parts[3])) {
+		return Credential{}, false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return Credential{}, false
+	}
+
+	// a token cannot grant more than its credential
+	credential := Credential{Name: name}
+	for _, scope := range scopes {
+		if secret.grants(scope) {
+			credential.Scopes = append(credential.Scopes, scope)
+		}
+	}
+	return credential, true
+}
+
+/* Credential presented by a request: a bearer token in the 'Authorization' header or an API key in the 'X-API-Key'
+header. Empty if there is none.
+*/
+func presentedToken(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+/* Checks that the token stands for a credential granting the scope, if credentials are configured: errUnauthorized if
+it does not stand for any, errForbidden if its credential does not grant the scope. Tokens are checked anew on every
+call, so that a token expiring is noticed even by long-lived connections of the RESP and RPC listeners.
+*/
+func (s *server) authorize(token string, scope string) error {
+	if s.credentials == nil {
+		return nil
+	}
+	credential, valid := s.credentials.authenticate(token, time.Now())
+	if !valid {
+		return errUnauthorized
+	}
+	if !credential.grants(scope) {
+		return errForbidden.withMessage("Credential '%v' does not grant the '%v' scope", credential.Name, scope)
+	}
+	return nil
+}
+
+/* Only lets requests through whose credential grants the given scope, if credentials are configured. Requests without
+a valid credential are rejected with a 401 error, those whose credential does not grant the scope with a 403 error.
+Rejected requests reach neither the limiter nor the handler, so they are not counted, not even by status class, and do
+not take from the limits of authenticated clients.
+*/
+func (s *server) authorized(scope string, next http.HandlerFunc) http.HandlerFunc {
+	if s.credentials == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(presentedToken(r), scope); err != nil {
+			if err == errUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="movingwindow"`)
+			}
+			writeError(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCredentials = `
+# kind name secret scopes
+key  dashboard dashboard-key read
+key  edge      edge-key      hit
+key  operator  operator-key  admin
+hmac billing   billing-secret hit,read
+`
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		fails bool
+	}{
+		{name: "valid", file: testCredentials},
+		{name: "empty", file: ""},
+		{name: "missing scopes", file: "key edge edge-key", fails: true},
+		{name: "unknown scope", file: "key edge edge-key write", fails: true},
+		{name: "unknown kind", file: "jwt edge edge-key hit", fails: true},
+		{name: "duplicate name", file: "key edge a hit\nhmac edge b hit", fails: true},
+		{name: "duplicate key", file: "key edge a hit\nkey dashboard a read", fails: true},
+		{name: "dotted secret name", file: "hmac edge.eu a hit", fails: true},
+	}
+	for _, test := range tests {
+		_, err := ParseCredentials(strings.NewReader(test.file))
+		if (err != nil) != test.fails {
+			t.Fatalf("Test '%v': expected failure to be '%v', got error '%v'\n", test.name, test.fails, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	credentials, err := ParseCredentials(strings.NewReader(testCredentials))
+	if err != nil {
+		t.Fatalf("Error parsing credentials: %v\n", err)
+	}
+	now := time.Now()
+	secret := []byte("billing-secret")
+	valid := SignToken("billing", secret, []string{"read"}, now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		token    string
+		expected *Credential
+	}{
+		{name: "API key", token: "edge-key", expected: &Credential{Name: "edge", Scopes: []string{"hit"}}},
+		{name: "unknown API key", token: "guessed-key"},
+		{name: "empty", token: ""},
+		{name: "token", token: valid, expected: &Credential{Name: "billing", Scopes: []string{"read"}}},
+		{name: "expired token", token: SignToken("billing", secret, []string{"read"}, now.Add(-time.Second))},
+		{name: "token signed with another secret", token: SignToken("billing", []byte("guessed"), []string{"read"}, now.Add(time.Hour))},
+		{name: "token of an unknown secret", token: SignToken("payroll", secret, []string{"read"}, now.Add(time.Hour))},
+		{name: "tampered token", token: strings.Replace(valid, ".read.", ".admin.", 1)},
+		{name: "token beyond its credential", token: SignToken("billing", secret, []string{"read", "admin"}, now.Add(time.Hour)), expected: &Credential{Name: "billing", Scopes: []string{"read"}}},
+	}
+	for _, test := range tests {
+		credential, authenticated := credentials.authenticate(test.token, now)
+		if authenticated != (test.expected != nil) {
+			t.Fatalf("Test '%v': expected authenticated to be '%v', got '%v'\n", test.name, test.expected != nil, authenticated)
+		}
+		if authenticated && !reflect.DeepEqual(credential, *test.expected) {
+			t.Fatalf("Test '%v': expected '%+v', got '%+v'\n", test.name, *test.expected, credential)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	credentials, err := ParseCredentials(strings.NewReader(testCredentials))
+	if err != nil {
+		t.Fatalf("Error parsing credentials: %v\n", err)
+	}
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Credentials:          credentials,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	token := SignToken("billing", []byte("billing-secret"), []string{"hit"}, time.Now().Add(time.Hour))
+	tests := []struct {
+		path           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{path: "/", expectedStatus: http.StatusUnauthorized},
+		{path: "/", header: "X-API-Key", value: "wrong-key", expectedStatus: http.StatusUnauthorized},
+		{path: "/", header: "X-API-Key", value: "edge-key", expectedStatus: http.StatusOK},
+		{path: "/", header: "Authorization", value: "Bearer edge-key", expectedStatus: http.StatusOK},
+		{path: "/", header: "Authorization", value: "Bearer " + token, expectedStatus: http.StatusOK},
+		{path: "/", header: "X-API-Key", value: "dashboard-key", expectedStatus: http.StatusForbidden},
+		{path: "/stats", header: "X-API-Key", value: "dashboard-key", expectedStatus: http.StatusOK},
+		{path: "/stats", header: "X-API-Key", value: "edge-key", expectedStatus: http.StatusForbidden},
+		{path: "/stats", header: "Authorization", value: "Bearer " + token, expectedStatus: http.StatusForbidden},
+		{path: "/cluster", header: "X-API-Key", value: "dashboard-key", expectedStatus: http.StatusForbidden},
+		{path: "/cluster", header: "X-API-Key", value: "operator-key", expectedStatus: http.StatusOK},
+		{path: "/", header: "X-API-Key", value: "operator-key", expectedStatus: http.StatusOK},
+		{path: "/healthz", expectedStatus: http.StatusOK},
+	}
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		srv.Handler.ServeHTTP(w, r)
+		if w.Code != test.expectedStatus {
+			t.Fatalf("Test '%v': expected status '%v' for '%v', got '%v': %v\n", i, test.expectedStatus, test.path, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("Test '%v': expected a 'WWW-Authenticate' header\n", i)
+		}
+	}
+
+	srv.Stop()
+	if count := srv.Communication.state.Present.TotalRequestsWithinTimeframe; count != 4 {
+		t.Fatalf("Expected only authorized requests to be counted, got a count of '%v'\n", count)
+	}
+	// rejected requests to the index are not counted by status class either, nor ranked by their IP address
+	if _, found := srv.Communication.state.Statuses["4xx"]; found {
+		t.Fatal("Expected rejected requests not to be counted by status class\n")
+	}
+}
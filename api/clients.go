@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/* Identity of the client a request came from, such as its IP address or API key, to estimate the number of distinct
+clients within the moving window. Requests whose client cannot be told are counted, but not as a client.
+*/
+type ClientFunc func(r *http.Request) string
+
+/* Clients are told apart by the IP address they connect from, or that trusted proxies forwarded them for. This is the
+default. See TrustedProxies.
+*/
+func IPClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/* Clients are told apart by the value of the given request header, such as an API key.
+ */
+func HeaderClient(name string) ClientFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+/* Clients are told apart by the value of the given query parameter.
+ */
+func QueryClient(name string) ClientFunc {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+/* Clients are not told apart. The number of distinct clients is always zero.
+ */
+func NoClient(r *http.Request) string {
+	return ""
+}
+
+/* Parses the specification of a client function as given on the command line:
+- 'ip': clients are told apart by their IP address
+- 'header:<name>': clients are told apart by the request header, e.g. an API key
+- 'query:<name>': clients are told apart by the query parameter
+- 'certificate': clients are told apart by the subject of their certificate, over mutual TLS
+- 'none': clients are not told apart
+*/
+func ParseClient(spec string) (ClientFunc, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "" || spec == "ip":
+		return IPClient, nil
+	case spec == "none":
+		return NoClient, nil
+	case spec == "certificate":
+		return CertificateClient, nil
+	case kind == "header" && name != "":
+		return HeaderClient(name), nil
+	case kind == "query" && name != "":
+		return QueryClient(name), nil
+	}
+	return nil, fmt.Errorf("unknown client '%v': expected 'ip', 'header:<name>', 'query:<name>', 'certificate' or 'none'", spec)
+}
+
+/* Parses the source of the key of the keyed counter requests to the index are counted in as well, as given on the
+command line. Keys are told the same way as clients:
+- 'none': requests are only counted by the counter of the server. Nil is returned.
+- 'header:<name>': the value of the request header, e.g. a tenant
+- 'query:<name>': the value of the query parameter
+- 'cert-subject': the subject of the certificate of the client, over mutual TLS
+*/
+func ParseKey(spec string) (ClientFunc, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "" || spec == "none":
+		return nil, nil
+	case spec == "cert-subject":
+		return CertificateClient, nil
+	case kind == "header" && name != "":
+		return HeaderClient(name), nil
+	case kind == "query" && name != "":
+		return QueryClient(name), nil
+	}
+	return nil, fmt.Errorf("unknown key '%v': expected 'none', 'header:<name>', 'query:<name>' or 'cert-subject'", spec)
+}
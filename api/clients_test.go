@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var parseClientTests = []struct {
+	spec    string
+	valid   bool
+	header  string
+	query   string
+	address string
+	client  string
+}{
+	{spec: "", valid: true, address: "10.0.0.1:1234", client: "10.0.0.1"},
+	{spec: "ip", valid: true, address: "[::1]:1234", client: "::1"},
+	{spec: "header:X-API-Key", valid: true, header: "key", client: "key"},
+	{spec: "query:api_key", valid: true, query: "key", client: "key"},
+	{spec: "none", valid: true, address: "10.0.0.1:1234", client: ""},
+	// without a verified certificate
+	{spec: "certificate", valid: true, address: "10.0.0.1:1234", client: ""},
+	{spec: "header:", valid: false},
+	{spec: "cookie:session", valid: false},
+}
+
+func TestParseClient(t *testing.T) {
+	for i, test := range parseClientTests {
+		client, err := ParseClient(test.spec)
+		if (err == nil) != test.valid {
+			t.Fatalf("Test '%v': expected spec '%v' to be valid: %v, got error '%v'\n", i, test.spec, test.valid, err)
+		}
+		if !test.valid {
+			continue
+		}
+
+		r := httptest.NewRequest("GET", "/?api_key="+test.query, nil)
+		r.Header.Set("X-API-Key", test.header)
+		if test.address != "" {
+			r.RemoteAddr = test.address
+		}
+		if result := client(r); result != test.client {
+			t.Fatalf("Test '%v': expected client '%v', got '%v'\n", i, test.client, result)
+		}
+	}
+}
+
+var parseKeyTests = []struct {
+	spec   string
+	valid  bool
+	none   bool
+	header string
+	query  string
+	key    string
+}{
+	{spec: "", valid: true, none: true},
+	{spec: "none", valid: true, none: true},
+	{spec: "header:X-Tenant", valid: true, header: "tenant", key: "tenant"},
+	{spec: "query:tenant", valid: true, query: "tenant", key: "tenant"},
+	// without a verified certificate
+	{spec: "cert-subject", valid: true, key: ""},
+	{spec: "ip", valid: false},
+	{spec: "query:", valid: false},
+}
+
+func TestParseKey(t *testing.T) {
+	for i, test := range parseKeyTests {
+		key, err := ParseKey(test.spec)
+		if (err == nil) != test.valid {
+			t.Fatalf("Test '%v': expected spec '%v' to be valid: %v, got error '%v'\n", i, test.spec, test.valid, err)
+		}
+		if !test.valid {
+			continue
+		}
+		if (key == nil) != test.none {
+			t.Fatalf("Test '%v': expected no key to be %v for spec '%v'\n", i, test.none, test.spec)
+		}
+		if key == nil {
+			continue
+		}
+		r := httptest.NewRequest("GET", "/?tenant="+test.query, nil)
+		r.Header.Set("X-Tenant", test.header)
+		if result := key(r); result != test.key {
+			t.Fatalf("Test '%v': expected key '%v', got '%v'\n", i, test.key, result)
+		}
+	}
+}
+
+func TestIndexUniqueClients(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Millisecond,
+		PersistenceTimeFrame: time.Minute,
+		Client:               HeaderClient("X-API-Key"),
+		UniqueClients:        true,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	// the clients of units of precision that became part of the past are merged with those of the present
+	steps := []struct {
+		clients  []string
+		expected int
+	}{
+		{clients: []string{"a", "b", "a", ""}, expected: 2},
+		{clients: []string{"a", "c"}, expected: 3},
+		{clients: []string{"d"}, expected: 4},
+	}
+	for i, step := range steps {
+		var response Response
+		for _, client := range step.clients {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-API-Key", client)
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Error decoding response '%v': %v\n", w.Body.String(), err)
+			}
+		}
+		if response.UniqueClients == nil || *response.UniqueClients != step.expected {
+			t.Fatalf("Step '%v': expected '%v' unique clients, got '%+v'\n", i, step.expected, response)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestIndexUniqueClientsDisabled(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Millisecond,
+		PersistenceTimeFrame: time.Minute,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+
+	// units of precision keep no estimator, so that none is held in memory or persisted
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if strings.Contains(w.Body.String(), "uniqueClients") {
+			t.Fatalf("Expected no unique clients without them being enabled, got '%v'\n", w.Body.String())
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	srv.Stop()
+	if srv.Communication.state.Present.Clients != nil || srv.Communication.state.Past.TotalClients() != nil {
+		t.Fatal("Expected no clients to be kept\n")
+	}
+}
@@ -1,41 +1,745 @@
 package api
 
 import (
+	"context"
+	"log"
 	"movingwindow/persistence"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 /*A member of the server struct, the communication struct contains the state object that keeps track of all
 requests and all necessary channels to interact with it:
-- exchangeTimestamp: used by the index handler to send timestamps of new incoming requests to the communication processor
+- exchangeTimestamp: used by the index handler to send timestamps of new incoming requests, along with their weight, to the communication processor
 - exchangeRequestCount: used by the communication processor to notify the index handler of computed request totals
 - exchangePersistence: used internally by the communication processor
 - exchangeAccumulated: used internally by the communication processor
+- exchangeSnapshot: used to request a copy of the request counts held in memory from the communication processor
+- exchangeKey: used to count requests for, and retrieve the request counts of, counters addressed by a key
+- exchangeAggregates: used to record the size and latency of responses, once they have been written
+- exchangeStatus: used to count responses by the class of their status code, once they have been written
+- exchangeStatusCounts: used to retrieve the response counts of every status class
+- exchangeTop: used to retrieve the values requests came with most often
+- exchangeLimit: used to check requests against the rate limit of their route, see Limit
+- exchangeQuota: used to retrieve the usage of the quota of a keyed counter, see Quota
+- exchangeAlerts: used to retrieve the alerts currently firing, see AlertRule
+- lifecycle: keeps track of the processor goroutines and of the handlers waiting for them. Shared by all copies of the struct.
+Backpressure is applied on handlers waiting for the communication processor:
+- queueDepth: number of handlers currently waiting for a request count. Shared by all copies of the struct.
+- maxQueueDepth: handlers arriving while this many others are waiting are rejected right away. Zero means no limit.
+- maxWait: maximum time a handler waits for the processor to take its timestamp. Zero means no limit.
+Requests may arrive late, with a timestamp before that of the present:
+- lateness: how far behind the present a timestamp may be to still be counted where it belongs. See persistence::CheckLateness.
+Along with the exact count within the time frame, a smooth estimate of the rate of requests may be kept:
+- halfLife: time after which a request counts half as much for the rate. Zero disables it. See persistence::DecayedRate.
+For very large time frames, the exact count may be traded for constant memory:
+- approximate: the count within the time frame is estimated from two fixed windows. See countApproximately.
+Routes may be rate limited, on top of being counted:
+- limits: limit of every limited route, by the pattern the route is registered with.
+Keyed counters may have quotas over calendar windows, on top of their moving window:
+- quotas: quota of keys, by key pattern. See quotaFor.
+Counters may be watched for thresholds, whenever a unit of precision rolls over:
+- alertRules: thresholds to watch. See evaluateAlerts.
+- activeAlerts: alerts currently firing, by rule.
+- alertsSince: time the rules have been watched since, i.e. the start of the processor.
+- alertWebhook: URL every change of the state of an alert is posted to. Empty disables notifications.
+- notifications: alerts waiting to be posted to the webhook. See notifier.
 */
 type communication struct {
 	state                persistence.State
-	exchangeTimestamp    chan time.Time
+	exchangeTimestamp    chan weightedTimestamp
 	exchangeRequestCount chan persistence.Cache
 	exchangePersistence  chan persistenceData
 	exchangeAccumulated  chan int
+	exchangeSnapshot     chan chan []persistence.RequestCount
+	exchangeKey          chan keyRequest
+	exchangeAggregates   chan recordedResponse
+	exchangeStatus       chan statusHit
+	exchangeStatusCounts chan statusCountsRequest
+	exchangeTop          chan topRequest
+	exchangeLimit        chan limitRequest
+	exchangeQuota        chan quotaRequest
+	exchangeAlerts       chan chan []Alert
+	lifecycle            *processorLifecycle
+	queueDepth           *int64
+	maxQueueDepth        int64
+	maxWait              time.Duration
+	lateness             time.Duration
+	halfLife             time.Duration
+	approximate          bool
+	limits               map[string]Limit
+	quotas               map[string]Quota
+	alertRules           []AlertRule
+	activeAlerts         map[string]Alert
+	alertsSince          time.Time
+	alertWebhook         string
+	notifications        chan Alert
+	persistenceTimeFrame time.Duration
+	precision            time.Duration
+	logger               *log.Logger
 }
 
-func NewCommunication() communication {
+func NewCommunication(env Environment, logger *log.Logger) communication {
+	var notifications chan Alert
+	if env.AlertWebhook != "" {
+		notifications = make(chan Alert, maxPendingNotifications)
+	}
 	return communication{
-		exchangeTimestamp:    make(chan time.Time),
+		exchangeTimestamp:    make(chan weightedTimestamp),
 		exchangeRequestCount: make(chan persistence.Cache),
 		exchangePersistence:  make(chan persistenceData),
 		exchangeAccumulated:  make(chan int),
+		exchangeSnapshot:     make(chan chan []persistence.RequestCount),
+		exchangeKey:          make(chan keyRequest),
+		exchangeAggregates:   make(chan recordedResponse),
+		exchangeStatus:       make(chan statusHit),
+		exchangeStatusCounts: make(chan statusCountsRequest),
+		exchangeTop:          make(chan topRequest),
+		exchangeLimit:        make(chan limitRequest),
+		exchangeQuota:        make(chan quotaRequest),
+		exchangeAlerts:       make(chan chan []Alert),
+		lifecycle:            &processorLifecycle{done: make(chan struct{})},
+		queueDepth:           new(int64),
+		maxQueueDepth:        int64(env.MaxQueueDepth),
+		maxWait:              env.MaxWait,
+		lateness:             env.Lateness,
+		halfLife:             env.HalfLife,
+		approximate:          env.Approximate,
+		limits:               env.Limits,
+		quotas:               env.Quotas,
+		alertRules:           env.AlertRules,
+		activeAlerts:         make(map[string]Alert),
+		alertWebhook:         env.AlertWebhook,
+		notifications:        notifications,
+		persistenceTimeFrame: env.PersistenceTimeFrame,
+		precision:            env.Precision,
+		logger:               logger,
+	}
+}
+
+/* The processor goes through the states 'created' -> 'started' -> 'stopped'. It can be stopped without having been
+started, in which case it will never start.
+- mu: guards the state flags, so that no handler can register as in flight once Stop() has been called.
+- inFlight: handlers that were admitted before Stop() was called. Stop() waits for all of them to be served.
+- goroutines: the processor goroutines and the alert notifier. Stop() waits for them to return before the state may be read.
+- done: closed once the processor goroutines have returned, for whatever reason.
+*/
+type processorLifecycle struct {
+	mu         sync.RWMutex
+	started    bool
+	stopped    bool
+	cancel     context.CancelFunc
+	inFlight   sync.WaitGroup
+	goroutines sync.WaitGroup
+	done       chan struct{}
+}
+
+/* Registers a handler as in flight. Fails once the processor has been stopped.
+ */
+func (l *processorLifecycle) admit() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.stopped {
+		return false
+	}
+	l.inFlight.Add(1)
+	return true
+}
+
+/* The processor is running from the moment it is started until it is stopped or its goroutines return.
+ */
+func (c *communication) Running() bool {
+	c.lifecycle.mu.RLock()
+	defer c.lifecycle.mu.RUnlock()
+	if !c.lifecycle.started || c.lifecycle.stopped {
+		return false
+	}
+	select {
+	case <-c.lifecycle.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *communication) QueueDepth() int {
+	return int(atomic.LoadInt64(c.queueDepth))
+}
+
+/* Admission of a handler that is about to hand something over to the communication processor. Fails if:
+- the processor has been stopped: errShuttingDown
+- the queue of waiting handlers is full: errSaturated
+On success, the returned context expires after maxWait and release() must be called once the handler is done waiting.
+*/
+func (c *communication) enqueue(ctx context.Context) (context.Context, func(), error) {
+	if !c.lifecycle.admit() {
+		return ctx, nil, errShuttingDown
+	}
+
+	depth := atomic.AddInt64(c.queueDepth, 1)
+	release := func() {
+		atomic.AddInt64(c.queueDepth, -1)
+		c.lifecycle.inFlight.Done()
+	}
+	if c.maxQueueDepth > 0 && depth > c.maxQueueDepth {
+		release()
+		return ctx, nil, errSaturated
+	}
+
+	if c.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.maxWait)
+		releaseQueue := release
+		release = func() {
+			cancel()
+			releaseQueue()
+		}
+	}
+	return ctx, release, nil
+}
+
+/* Timestamp of a request, along with the number of units it counts for, the client it came from and the key of the
+keyed counter it is counted in as well. See WeightFunc, ClientFunc and ParseKey.
+*/
+type weightedTimestamp struct {
+	timestamp time.Time
+	weight    int
+	client    string
+	key       string
+}
+
+/* Hands the timestamp of a new request over to the communication processor and waits for the resulting request count.
+The request counts for the given weight, which must be positive, and for the given client, unless it is empty. Unless
+the key is empty, it is counted in the keyed counter of the key as well, in the same operation. See handleKey.
+Fails instead of blocking forever if:
+- the processor has been stopped: errShuttingDown
+- the queue of waiting handlers is full: errSaturated
+- the processor did not take the timestamp within maxWait, or the context was cancelled: errUnavailable
+Once the processor has taken the timestamp, the request has been counted. The handler then waits for the result, which
+is computed in memory and does not depend on the client.
+*/
+func (c *communication) exchange(ctx context.Context, timestamp time.Time, weight int, client string, key string) (persistence.Cache, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return persistence.Cache{}, err
+	}
+	defer release()
+
+	select {
+	case c.exchangeTimestamp <- weightedTimestamp{timestamp: timestamp, weight: weight, client: client, key: key}:
+	case <-c.lifecycle.done:
+		return persistence.Cache{}, errShuttingDown
+	case <-ctx.Done():
+		return persistence.Cache{}, errUnavailable
+	}
+
+	return <-c.exchangeRequestCount, nil
+}
+
+/* Hit of a counter addressed by a key:
+- key: name of the counter. Counters are created on their first hit and forgotten once all of their requests left the
+  persistence time frame.
+- timestamp: time of the hit, also used as the reference for the request count.
+- n: number of requests counted. Zero only retrieves the request count.
+*/
+type keyHit struct {
+	key       string
+	timestamp time.Time
+	n         int
+}
+
+/* Batch of hits for keyed counters, as handled by the communication processor. Hits are applied in order, as a single
+operation: no other request is served in between. The reply receives the request count within the persistence time
+frame after every hit, in the same order.
+Hits arriving late are validated for the whole batch before any of them is applied: if one of them is rejected, the
+reply receives the error and nothing is counted.
+*/
+type keyRequest struct {
+	hits  []keyHit
+	reply chan keyReply
+}
+
+type keyReply struct {
+	counts []int
+	err    error
+}
+
+/* Same as exchange, for counters addressed by a key. Applies the same backpressure, counting the batch as one handler.
+ */
+func (c *communication) exchangeKeyed(ctx context.Context, hits ...keyHit) ([]int, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	request := keyRequest{hits: hits, reply: make(chan keyReply, 1)}
+	select {
+	case c.exchangeKey <- request:
+	case <-c.lifecycle.done:
+		return nil, errShuttingDown
+	case <-ctx.Done():
+		return nil, errUnavailable
+	}
+
+	reply := <-request.reply
+	return reply.counts, reply.err
+}
+
+/* Serves a request for keyed counters. Must only be called from the Timestamp-RequestCount exchanger.
+Hits of keys with a quota are counted against it as well. See countQuota.
+Keys are only swept for expired counters, and quotas for ended calendar windows, once per unit of precision, to keep
+the cost of a request constant.
+*/
+func (c *communication) handleKey(request keyRequest, lastSweep time.Time) time.Time {
+	if c.state.Keys == nil {
+		c.state.Keys = make(map[string]persistence.State)
+	}
+
+	// the latest timestamp of every key, as it will be when the hit is applied
+	watermarks := make(map[string]time.Time)
+	for i, hit := range request.hits {
+		if hit.n <= 0 {
+			continue
+		}
+		watermark, seen := watermarks[hit.key]
+		if !seen {
+			watermark = c.state.Keys[hit.key].Present.Timestamp
+		}
+		if err := persistence.CheckLateness(watermark, hit.timestamp, c.persistenceTimeFrame, c.precision, c.lateness); err != nil {
+			request.reply <- keyReply{err: errBadRequest.withMessage("Hit '%v' for key '%v' at '%v' was rejected: %v", i, hit.key, hit.timestamp.Format(time.RFC3339Nano), err)}
+			return lastSweep
+		}
+		if hit.timestamp.After(watermark) {
+			watermarks[hit.key] = hit.timestamp
+		}
+	}
+
+	counts := make([]int, len(request.hits))
+	for i, hit := range request.hits {
+		// lateness has been checked for the whole batch already
+		keyState, _ := c.state.Keys[hit.key].Hit(hit.timestamp, hit.n, c.persistenceTimeFrame, c.precision, c.lateness)
+		keyState, counts[i] = keyState.Count(hit.timestamp, c.persistenceTimeFrame, c.precision)
+		c.countQuota(hit)
+		if keyState.Expired(hit.timestamp, c.persistenceTimeFrame, c.precision) {
+			delete(c.state.Keys, hit.key)
+		} else {
+			c.state.Keys[hit.key] = keyState
+		}
+	}
+	request.reply <- keyReply{counts: counts}
+
+	if len(request.hits) == 0 {
+		return lastSweep
+	}
+	reference := request.hits[len(request.hits)-1].timestamp
+	if reference.Truncate(c.precision) == lastSweep.Truncate(c.precision) {
+		return lastSweep
+	}
+	for key, keyState := range c.state.Keys {
+		keyState, _ = keyState.Count(reference, c.persistenceTimeFrame, c.precision)
+		if keyState.Expired(reference, c.persistenceTimeFrame, c.precision) {
+			delete(c.state.Keys, key)
+		} else {
+			c.state.Keys[key] = keyState
+		}
+	}
+	for key, usage := range c.state.Quotas {
+		quota, found := quotaFor(c.quotas, key)
+		if !found {
+			delete(c.state.Quotas, key)
+			continue
+		}
+		if used, _ := usage.At(reference, quota.Period, quota.Location); used == 0 {
+			delete(c.state.Quotas, key)
+		}
+	}
+	return reference
+}
+
+/* Counts a hit against the quota of its key, if it has one. Must only be called from the Timestamp-RequestCount
+exchanger.
+*/
+func (c *communication) countQuota(hit keyHit) {
+	quota, found := quotaFor(c.quotas, hit.key)
+	if !found || hit.n <= 0 {
+		return
+	}
+	if c.state.Quotas == nil {
+		c.state.Quotas = make(map[string]persistence.QuotaUsage)
+	}
+	c.state.Quotas[hit.key] = c.state.Quotas[hit.key].Add(hit.timestamp, hit.n, quota.Period, quota.Location)
+}
+
+/* Request for the usage of the quota of a key.
+ */
+type quotaRequest struct {
+	key   string
+	reply chan persistence.QuotaUsage
+}
+
+/* Retrieves the usage of the quota of a key. As for snapshot, this is serialized with the handling of requests, and
+applies the same backpressure as exchange.
+*/
+func (c *communication) quotaUsage(ctx context.Context, key string) (persistence.QuotaUsage, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return persistence.QuotaUsage{}, err
+	}
+	defer release()
+
+	request := quotaRequest{key: key, reply: make(chan persistence.QuotaUsage, 1)}
+	select {
+	case c.exchangeQuota <- request:
+	case <-c.lifecycle.done:
+		return persistence.QuotaUsage{}, errShuttingDown
+	case <-ctx.Done():
+		return persistence.QuotaUsage{}, errUnavailable
+	}
+	return <-request.reply, nil
+}
+
+/* Aggregates of a response, to be recorded along with the request count of the point in time the request was counted at.
+ */
+type recordedResponse struct {
+	timestamp  time.Time
+	aggregates persistence.Aggregates
+}
+
+/* Records the aggregates of a response that has been written, for a request that was counted with the given timestamp.
+Applies the same backpressure as exchange. Does not wait for the aggregates to be recorded.
+*/
+func (c *communication) record(ctx context.Context, timestamp time.Time, aggregates persistence.Aggregates) error {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	select {
+	case c.exchangeAggregates <- recordedResponse{timestamp: timestamp, aggregates: aggregates}:
+		return nil
+	case <-c.lifecycle.done:
+		return errShuttingDown
+	case <-ctx.Done():
+		return errUnavailable
+	}
+}
+
+/* Records the aggregates of a response. Must only be called from the Timestamp-RequestCount exchanger.
+The request has been counted by the time its response is recorded, so its point in time is either the present or has
+become part of the past. In the latter case, the aggregates are inserted into the past through the
+Persistence-Accumulated exchanger, as long as they are still within the persistence time frame. Responses take as long as
+they take, so the lateness tolerance does not apply. In approximate mode, there is no past to insert into: the
+aggregates of responses whose point in time has passed are dropped.
+*/
+func (c *communication) handleAggregates(response recordedResponse) {
+	present := c.state.Present
+	switch {
+	case present.Empty():
+		// nothing has been counted, so there is nothing to record the response with
+	case !response.timestamp.Before(present.Timestamp) || present.CompareTimestampWithPrecision(response.timestamp, c.precision):
+		c.state.Present.Aggregates = present.Aggregates.Merge(response.aggregates)
+	case c.approximate:
+	case persistence.CheckLateness(present.Timestamp, response.timestamp, c.persistenceTimeFrame, c.precision, c.persistenceTimeFrame) == nil:
+		c.exchangePersistence <- persistenceData{
+			RequestCount: persistence.RequestCount{Timestamp: response.timestamp, Aggregates: response.aggregates},
+			Reference:    persistence.RequestCount{Timestamp: present.Timestamp},
+			Late:         true,
+		}
+		<-c.exchangeAccumulated
+	}
+}
+
+/* Response to be counted for the class of its status code, such as "2xx", at the point in time its request was counted.
+The IP address and the path of its request are kept track of as heavy hitters, see persistence::HeavyHitters.
+*/
+type statusHit struct {
+	timestamp time.Time
+	class     string
+	ip        string
+	path      string
+}
+
+/* Counts a response that has been written by the class of its status code. Applies the same backpressure as exchange.
+Does not wait for the response to be counted.
+*/
+func (c *communication) recordStatus(ctx context.Context, hit statusHit) error {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	select {
+	case c.exchangeStatus <- hit:
+		return nil
+	case <-c.lifecycle.done:
+		return errShuttingDown
+	case <-ctx.Done():
+		return errUnavailable
+	}
+}
+
+/* Counts a response for its status class. Must only be called from the Timestamp-RequestCount exchanger.
+As for the aggregates of responses, the lateness tolerance does not apply: responses outside of the persistence time
+frame are the only ones dropped. See handleAggregates.
+*/
+func (c *communication) handleStatus(hit statusHit) {
+	if c.state.Statuses == nil {
+		c.state.Statuses = make(map[string]persistence.State)
 	}
+	var top *persistence.HeavyHitters
+	top = top.Add(topByIP, hit.ip, 1).Add(topByPath, hit.path, 1)
+	data := persistence.RequestCount{Timestamp: hit.timestamp, Count: 1, Top: top}
+	statusState, err := c.state.Statuses[hit.class].HitWith(data, c.persistenceTimeFrame, c.precision, c.persistenceTimeFrame)
+	if err != nil {
+		return
+	}
+	c.state.Statuses[hit.class] = statusState
+}
+
+/* Request for the response counts of every status class within the persistence time frame before the reference.
+ */
+type statusCountsRequest struct {
+	reference time.Time
+	reply     chan map[string]int
+}
+
+/* Retrieves the response counts of every status class within the persistence time frame before the reference. As for
+snapshot, this is serialized with the handling of requests, and applies the same backpressure as exchange.
+*/
+func (c *communication) statusCounts(ctx context.Context, reference time.Time) (map[string]int, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	request := statusCountsRequest{reference: reference, reply: make(chan map[string]int, 1)}
+	select {
+	case c.exchangeStatusCounts <- request:
+	case <-c.lifecycle.done:
+		return nil, errShuttingDown
+	case <-ctx.Done():
+		return nil, errUnavailable
+	}
+	return <-request.reply, nil
+}
+
+/* Counts the responses of every status class. Must only be called from the Timestamp-RequestCount exchanger.
+Classes without any response left within the persistence time frame are forgotten.
+*/
+func (c *communication) handleStatusCounts(request statusCountsRequest) {
+	request.reply <- c.sweepStatuses(request.reference)
+}
+
+/* Counts the responses of every status class within the persistence time frame before the reference, discarding
+those outside of it. Classes without any response left are forgotten.
+*/
+func (c *communication) sweepStatuses(reference time.Time) map[string]int {
+	counts := make(map[string]int, len(c.state.Statuses))
+	for class, statusState := range c.state.Statuses {
+		statusState, counts[class] = statusState.Count(reference, c.persistenceTimeFrame, c.precision)
+		if statusState.Expired(reference, c.persistenceTimeFrame, c.precision) {
+			delete(c.state.Statuses, class)
+			delete(counts, class)
+		} else {
+			c.state.Statuses[class] = statusState
+		}
+	}
+	return counts
+}
+
+/* Request for the k values requests came with most often within the persistence time frame before the reference:
+- by: the dimension of the values. topByKey ranks the keyed counters by their request count, others rank the values
+  of the responses to the index, see statusHit.
+- class: only ranks the responses of the given status class. Empty ranks all of them.
+*/
+type topRequest struct {
+	reference time.Time
+	by        string
+	class     string
+	k         int
+	reply     chan []persistence.TopEntry
+}
+
+/* Retrieves the values requests came with most often. As for snapshot, this is serialized with the handling of
+requests, and applies the same backpressure as exchange.
+*/
+func (c *communication) top(ctx context.Context, request topRequest) ([]persistence.TopEntry, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	request.reply = make(chan []persistence.TopEntry, 1)
+	select {
+	case c.exchangeTop <- request:
+	case <-c.lifecycle.done:
+		return nil, errShuttingDown
+	case <-ctx.Done():
+		return nil, errUnavailable
+	}
+	return <-request.reply, nil
+}
+
+/* Ranks values for a topRequest. Must only be called from the Timestamp-RequestCount exchanger.
+The request counts of keyed counters are exact. Values of responses are estimated from the heavy hitters of every unit
+of precision within the persistence time frame, which are merged on every call.
+*/
+func (c *communication) handleTop(request topRequest) {
+	if request.by == topByKey {
+		counts := make(map[string]int, len(c.state.Keys))
+		for key, keyState := range c.state.Keys {
+			keyState, counts[key] = keyState.Count(request.reference, c.persistenceTimeFrame, c.precision)
+			if keyState.Expired(request.reference, c.persistenceTimeFrame, c.precision) {
+				delete(c.state.Keys, key)
+				delete(counts, key)
+			} else {
+				c.state.Keys[key] = keyState
+			}
+		}
+		request.reply <- persistence.ExactTop(counts, request.k)
+		return
+	}
+
+	c.sweepStatuses(request.reference)
+	reference := persistence.RequestCount{Timestamp: request.reference}
+	classes := make([]string, 0, len(c.state.Statuses))
+	for class := range c.state.Statuses {
+		if request.class == "" || class == request.class {
+			classes = append(classes, class)
+		}
+	}
+	// merging summaries may drop values, so the order is kept stable for the same state
+	sort.Strings(classes)
+
+	var requestCounts []persistence.RequestCount
+	for _, class := range classes {
+		for _, requestCount := range c.state.Statuses[class].RequestCounts() {
+			if within, _ := requestCount.WithinDurationBefore(c.persistenceTimeFrame, c.precision, reference); within {
+				requestCounts = append(requestCounts, requestCount)
+			}
+		}
+	}
+	request.reply <- persistence.TopWithin(requestCounts, request.by, request.k)
+}
+
+/* Request of a client to a rate limited route, at the given time.
+ */
+type limitRequest struct {
+	route     string
+	client    string
+	timestamp time.Time
+	reply     chan limitReply
+}
+
+/* Whether the request is let through and, if it is not, how long the client should wait before trying again.
+ */
+type limitReply struct {
+	allowed    bool
+	retryAfter time.Duration
+}
+
+/* Checks a request against the limit of its route. Requests that are let through count against the limit.
+Applies the same backpressure as exchange.
+*/
+func (c *communication) limit(ctx context.Context, request limitRequest) (bool, time.Duration, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer release()
+
+	request.reply = make(chan limitReply, 1)
+	select {
+	case c.exchangeLimit <- request:
+	case <-c.lifecycle.done:
+		return false, 0, errShuttingDown
+	case <-ctx.Done():
+		return false, 0, errUnavailable
+	}
+
+	reply := <-request.reply
+	return reply.allowed, reply.retryAfter, nil
+}
+
+/* Limiters share the key space of the state: the route, followed by the client. Patterns of routes have no spaces.
+ */
+func limiterKey(route string, client string) string {
+	return route + " " + client
+}
+
+/* Checks a request against the limit of its route. Must only be called from the Timestamp-RequestCount exchanger.
+As for keyed counters, limiters are only swept once per unit of precision. Idle limiters, which would let a full burst
+through, are forgotten, and so are those of routes that are no longer limited, e.g. after a restart.
+*/
+func (c *communication) handleLimit(request limitRequest, lastSweep time.Time) time.Time {
+	if c.state.Limiters == nil {
+		c.state.Limiters = make(map[string]persistence.Limiter)
+	}
+	limit := c.limits[request.route]
+	key := limiterKey(request.route, request.client)
+
+	var reply limitReply
+	limiter := c.state.Limiters[key]
+	if limit.Algorithm == limitGCRA {
+		limiter, reply.allowed, reply.retryAfter = limiter.TakeCell(request.timestamp, limit.Interval, limit.Burst)
+	} else {
+		limiter, reply.allowed, reply.retryAfter = limiter.TakeToken(request.timestamp, limit.Interval, limit.Burst)
+	}
+	c.state.Limiters[key] = limiter
+	request.reply <- reply
+
+	if request.timestamp.Truncate(c.precision) == lastSweep.Truncate(c.precision) {
+		return lastSweep
+	}
+	for key, limiter := range c.state.Limiters {
+		route, _, _ := strings.Cut(key, " ")
+		limit, limited := c.limits[route]
+		if !limited || limiter.Idle(request.timestamp, limit.Interval, limit.Burst) {
+			delete(c.state.Limiters, key)
+		}
+	}
+	return request.timestamp
+}
+
+/* Retrieves a copy of the request counts of the past and the present from the communication processor. As any other
+access to the state, this is serialized with the handling of requests. Applies the same backpressure as exchange, so
+that reads cannot pile up in front of the processor either.
+*/
+func (c *communication) snapshot(ctx context.Context) ([]persistence.RequestCount, error) {
+	ctx, release, err := c.enqueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reply := make(chan []persistence.RequestCount, 1)
+	select {
+	case c.exchangeSnapshot <- reply:
+	case <-c.lifecycle.done:
+		return nil, errShuttingDown
+	case <-ctx.Done():
+		return nil, errUnavailable
+	}
+	return <-reply, nil
 }
 
 /* The communication processor uses PersistenceData internally as a means to exchange information between its goroutines.
 - RequestCount: accumulated request count for the last unit of time
 - Reference: object containing the timestamp that will be used for calculation of request counts within the persistence timeframe.
+- Late: the request count belongs to a request that arrived late, and is inserted into the past rather than appended.
 */
 type persistenceData struct {
 	RequestCount persistence.RequestCount
 	Reference    persistence.RequestCount
+	Late         bool
 }
 
 func NewPersistenceData(cache persistence.Cache, timestamp time.Time) persistenceData {
@@ -74,57 +778,231 @@ the Timestamp-RequestCount exchanger. For every new request that comes in, the f
   <-  IndexHandler produces the response and sends it to the client
 
 - Client receives the response
+
+Requests handled concurrently may reach the processor slightly out of order. A timestamp that is earlier than the cache
+by more than the precision arrived late: within the lateness tolerance, it is handed to the Persistence-Accumulated
+exchanger to be inserted into the past where it belongs, and the total of the cache is increased. Beyond the tolerance,
+the request is counted as part of the cache.
+
+Whenever a unit of precision rolls over, the Timestamp-RequestCount exchanger evaluates the alert rules, if any, as of
+the start of the new unit, and hands the alerts whose state changed over to the notifier, which posts them to the webhook without holding up the processor.
+
+The processor runs until the provided context is cancelled or Stop() is called. The Timestamp-RequestCount exchanger
+only checks for either between requests, so a timestamp that has been taken is always answered. On its way out, it
+closes the exchangePersistence channel, which in turn makes the Persistence-Accumulated exchanger return.
+Calling Start() more than once, or after Stop(), has no effect.
 */
-func (s *server) startCommunicationProcessor() {
-	s.Logger.Print("Starting communication processor...")
+func (c *communication) Start(ctx context.Context) {
+	c.lifecycle.mu.Lock()
+	defer c.lifecycle.mu.Unlock()
+	if c.lifecycle.started || c.lifecycle.stopped {
+		return
+	}
+	c.lifecycle.started = true
+	ctx, c.lifecycle.cancel = context.WithCancel(ctx)
+	c.alertsSince = time.Now()
 
-	s.Logger.Print("Starting Persistence-Accumulated exchanger...")
+	c.logger.Print("Starting communication processor...")
+	c.lifecycle.goroutines.Add(2)
+
+	c.logger.Print("Starting Persistence-Accumulated exchanger...")
 	go func() {
-		for {
-			persistenceData, ok := <-s.Communication.exchangePersistence
-			if ok {
-				s.Communication.state.Past = s.Communication.state.Past.AppendToTail(persistenceData.RequestCount)
-				s.Communication.state.Past = s.Communication.state.Past.UpdateTotals(persistenceData.Reference, s.persistenceTimeFrame, s.precision)
-				s.Communication.exchangeAccumulated <- s.Communication.state.Past.TotalAccumulatedRequestCount()
+		defer c.lifecycle.goroutines.Done()
+		for persistenceData := range c.exchangePersistence {
+			if persistenceData.Late {
+				c.state.Past = c.state.Past.Insert(persistenceData.RequestCount, c.precision)
 			} else {
-				break
+				c.state.Past = c.state.Past.AppendToTail(persistenceData.RequestCount)
 			}
+			c.state.Past = c.state.Past.UpdateTotals(persistenceData.Reference, c.persistenceTimeFrame, c.precision)
+			c.exchangeAccumulated <- c.state.Past.TotalAccumulatedRequestCount()
 		}
 	}()
 
-	s.Logger.Print("Starting Timestamp-RequestCount exchanger...")
+	if c.notifications != nil {
+		c.logger.Print("Starting alert notifier...")
+		// the notifications left once the exchangers return are still posted before the processor is done
+		c.lifecycle.goroutines.Add(1)
+		go func() {
+			defer c.lifecycle.goroutines.Done()
+			c.notifier(c.alertWebhook)
+		}()
+	}
+
+	c.logger.Print("Starting Timestamp-RequestCount exchanger...")
 	go func() {
+		defer c.lifecycle.goroutines.Done()
+		defer close(c.exchangePersistence)
+		if c.notifications != nil {
+			defer close(c.notifications)
+		}
+		var lastSweep, lastLimiterSweep time.Time
+		// alerts are evaluated at the boundaries of the units of precision, so that they see the same buckets as responses
+		var evaluation <-chan time.Time
+		var evaluationTimer *time.Timer
+		var nextEvaluation time.Time
+		if len(c.alertRules) > 0 {
+			nextEvaluation = time.Now().Truncate(c.precision).Add(c.precision)
+			evaluationTimer = time.NewTimer(time.Until(nextEvaluation))
+			defer evaluationTimer.Stop()
+			evaluation = evaluationTimer.C
+		}
 		for {
-			requestTimestamp, ok := <-s.Communication.exchangeTimestamp
-			if ok {
-				if s.Communication.state.Present.Empty() {
-					s.Communication.state.Present.Timestamp = requestTimestamp
-				}
+			var request weightedTimestamp
+			select {
+			case request = <-c.exchangeTimestamp:
+			case reply := <-c.exchangeSnapshot:
+				// the Persistence-Accumulated exchanger is idle between requests, so the past can be read safely
+				reply <- c.state.RequestCounts()
+				continue
+			case keyed := <-c.exchangeKey:
+				// keyed counters are only ever accessed by this goroutine
+				lastSweep = c.handleKey(keyed, lastSweep)
+				continue
+			case response := <-c.exchangeAggregates:
+				c.handleAggregates(response)
+				continue
+			case status := <-c.exchangeStatus:
+				c.handleStatus(status)
+				continue
+			case statusRequest := <-c.exchangeStatusCounts:
+				c.handleStatusCounts(statusRequest)
+				continue
+			case topRequest := <-c.exchangeTop:
+				c.handleTop(topRequest)
+				continue
+			case limitRequest := <-c.exchangeLimit:
+				lastLimiterSweep = c.handleLimit(limitRequest, lastLimiterSweep)
+				continue
+			case quotaRequest := <-c.exchangeQuota:
+				quotaRequest.reply <- c.state.Quotas[quotaRequest.key]
+				continue
+			case <-evaluation:
+				c.evaluateAlerts(nextEvaluation)
+				// boundaries missed while the processor was busy are skipped
+				nextEvaluation = time.Now().Truncate(c.precision).Add(c.precision)
+				evaluationTimer.Reset(time.Until(nextEvaluation))
+				continue
+			case reply := <-c.exchangeAlerts:
+				c.handleAlerts(reply)
+				continue
+			case <-ctx.Done():
+				return
+			}
+
+			if request.key != "" {
+				// a hit too late for the keyed counter is not counted in it, yet the request is counted by the server
+				keyed := keyRequest{hits: []keyHit{{key: request.key, timestamp: request.timestamp, n: request.weight}}, reply: make(chan keyReply, 1)}
+				lastSweep = c.handleKey(keyed, lastSweep)
+			}
 
-				if s.Communication.state.Present.CompareTimestampWithPrecision(requestTimestamp, s.precision) {
-					s.Communication.state.Present.Increment()
-				} else {
-					persistenceUpdate := NewPersistenceData(s.Communication.state.Present, requestTimestamp)
+			if c.approximate {
+				c.countApproximately(request)
+				c.updateRate(request)
+				c.exchangeRequestCount <- c.state.Present
+				continue
+			}
 
-					s.Communication.exchangePersistence <- persistenceUpdate
-					totalAccumulated := <-s.Communication.exchangeAccumulated
+			requestTimestamp := request.timestamp
+			if c.state.Present.Empty() {
+				c.state.Present.Timestamp = requestTimestamp
+			}
+
+			late := requestTimestamp.Before(c.state.Present.Timestamp)
+			if late && persistence.CheckLateness(c.state.Present.Timestamp, requestTimestamp, c.persistenceTimeFrame, c.precision, c.lateness) != nil {
+				// the request has been served by now regardless, so it is counted as part of the present
+				late = false
+				requestTimestamp = c.state.Present.Timestamp
+			}
 
-					s.Communication.state.Present = persistence.NewCache(requestTimestamp, totalAccumulated)
+			if c.state.Present.CompareTimestampWithPrecision(requestTimestamp, c.precision) {
+				c.state.Present.Add(request.weight)
+				c.state.Present.Clients = c.state.Present.Clients.Add(request.client)
+			} else if late {
+				lateUpdate := persistenceData{
+					RequestCount: persistence.RequestCount{Timestamp: requestTimestamp, Count: request.weight, Clients: persistence.NewHyperLogLog(request.client)},
+					Reference:    persistence.RequestCount{Timestamp: c.state.Present.Timestamp},
+					Late:         true,
 				}
 
-				s.Communication.exchangeRequestCount <- s.Communication.state.Present
+				c.exchangePersistence <- lateUpdate
+				<-c.exchangeAccumulated
+
+				c.state.Present.TotalRequestsWithinTimeframe += request.weight
+				c.state.Present.PastClientsWithinTimeframe = c.state.Present.PastClientsWithinTimeframe.Add(request.client)
 			} else {
-				break
+				persistenceUpdate := NewPersistenceData(c.state.Present, requestTimestamp)
+
+				c.exchangePersistence <- persistenceUpdate
+				totalAccumulated := <-c.exchangeAccumulated
+
+				c.state.Present = persistence.NewWeightedCache(requestTimestamp, totalAccumulated, request.weight)
+				// the Persistence-Accumulated exchanger is idle again, so the past can be read safely
+				c.state.Present.PastClientsWithinTimeframe = c.state.Past.TotalClients()
+				c.state.Present.Clients = c.state.Present.Clients.Add(request.client)
 			}
+
+			c.updateRate(request)
+			c.exchangeRequestCount <- c.state.Present
 		}
 	}()
-	s.Logger.Print("Communication processor up and running")
+
+	go func() {
+		c.lifecycle.goroutines.Wait()
+		close(c.lifecycle.done)
+	}()
+	c.logger.Print("Communication processor up and running")
 }
 
-/* Cleanup for shutdown of the server.
- */
-func (s *server) CloseChannels() {
-	close(s.Communication.exchangeRequestCount)
-	close(s.Communication.exchangePersistence)
-	close(s.Communication.exchangeAccumulated)
+/* Counts a request in approximate mode. Must only be called from the Timestamp-RequestCount exchanger.
+Only the present is kept per unit of precision: once a later request comes in, it is dropped rather than handed to the
+Persistence-Accumulated exchanger, so that the past stays empty and memory is constant. Requests that arrived late are
+counted as part of the present. The count within the time frame is estimated by persistence::FixedWindows.
+*/
+func (c *communication) countApproximately(request weightedTimestamp) {
+	c.state.Windows = c.state.Windows.Add(request.timestamp, request.weight, c.persistenceTimeFrame)
+	if c.state.Present.Empty() || request.timestamp.Truncate(c.precision).After(c.state.Present.Timestamp.Truncate(c.precision)) {
+		c.state.Present = persistence.NewWeightedCache(request.timestamp, 0, request.weight)
+	} else {
+		c.state.Present.Add(request.weight)
+	}
+	c.state.Present.Clients = c.state.Present.Clients.Add(request.client)
+	c.state.Present.TotalRequestsWithinTimeframe = c.state.Windows.Count(c.state.Present.Timestamp, c.persistenceTimeFrame)
+}
+
+/* Updates the decayed rate with a request and reports it along with the present, if enabled. Must only be called from
+the Timestamp-RequestCount exchanger.
+*/
+func (c *communication) updateRate(request weightedTimestamp) {
+	if c.halfLife > 0 {
+		c.state.Rate = c.state.Rate.Add(request.timestamp, request.weight, c.halfLife)
+		c.state.Present.RatePerSecond = c.state.Rate.PerSecond(request.timestamp, c.halfLife)
+	}
+}
+
+/* Stops the processor in a deterministic order:
+- new handlers are turned away with errShuttingDown
+- handlers admitted before are served, as long as they do not give up waiting on their own
+- the processor goroutines are stopped and waited for
+Once Stop() returns, the state is no longer modified and can be safely read. Calling Stop() more than once is harmless.
+*/
+func (c *communication) Stop() {
+	c.lifecycle.mu.Lock()
+	alreadyStopped := c.lifecycle.stopped
+	c.lifecycle.stopped = true
+	started := c.lifecycle.started
+	if !started && !alreadyStopped {
+		// nothing will ever take the timestamps of admitted handlers, so let them go right away
+		close(c.lifecycle.done)
+	}
+	c.lifecycle.mu.Unlock()
+
+	c.logger.Print("Stopping communication processor. Draining in-flight requests...")
+	c.lifecycle.inFlight.Wait()
+
+	if started {
+		c.lifecycle.cancel()
+		<-c.lifecycle.done
+	}
+	c.logger.Print("Communication processor stopped")
 }
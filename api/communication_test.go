@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCommunication(maxQueueDepth int, maxWait time.Duration) communication {
+	return NewCommunication(Environment{
+		PersistenceTimeFrame: time.Hour,
+		Precision:            time.Hour,
+		MaxQueueDepth:        maxQueueDepth,
+		MaxWait:              maxWait,
+	}, log.New(ioutil.Discard, "", 0))
+}
+
+/* The communication processor is not started in this test, so that no timestamp is ever taken from the channel.
+ */
+func TestExchangeBackpressure(t *testing.T) {
+	com := newTestCommunication(0, 50*time.Millisecond)
+	if _, err := com.exchange(context.Background(), time.Now(), 1, "", ""); err != errUnavailable {
+		t.Fatalf("Expected '%v' after waiting for longer than maxWait, got '%v'\n", errUnavailable, err)
+	}
+
+	com = newTestCommunication(0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := com.exchange(ctx, time.Now(), 1, "", ""); err != errUnavailable {
+		t.Fatalf("Expected '%v' for a cancelled request, got '%v'\n", errUnavailable, err)
+	}
+
+	com = newTestCommunication(1, time.Second)
+	waiting := make(chan error)
+	go func() {
+		_, err := com.exchange(context.Background(), time.Now(), 1, "", "")
+		waiting <- err
+	}()
+	for com.QueueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := com.exchange(context.Background(), time.Now(), 1, "", ""); err != errSaturated {
+		t.Fatalf("Expected '%v' with a full queue, got '%v'\n", errSaturated, err)
+	}
+	com.Stop()
+	if err := <-waiting; err != errShuttingDown {
+		t.Fatalf("Expected waiting request to fail with '%v' on shutdown, got '%v'\n", errShuttingDown, err)
+	}
+	if com.QueueDepth() != 0 {
+		t.Fatalf("Expected queue to be empty after all requests returned, got '%v'\n", com.QueueDepth())
+	}
+}
+
+/* Reads of the state wait for the processor as much as requests do, so they are held to the same backpressure. The
+processor is not started in this test either.
+*/
+func TestReadBackpressure(t *testing.T) {
+	reads := map[string]func(com communication) error{
+		"snapshot": func(com communication) error {
+			_, err := com.snapshot(context.Background())
+			return err
+		},
+		"statusCounts": func(com communication) error {
+			_, err := com.statusCounts(context.Background(), time.Now())
+			return err
+		},
+		"top": func(com communication) error {
+			_, err := com.top(context.Background(), topRequest{reference: time.Now(), by: topByKey, k: 1})
+			return err
+		},
+		"quotaUsage": func(com communication) error {
+			_, err := com.quotaUsage(context.Background(), "key")
+			return err
+		},
+		"alerts": func(com communication) error {
+			_, err := com.alerts(context.Background())
+			return err
+		},
+	}
+	for name, read := range reads {
+		if err := read(newTestCommunication(0, 20*time.Millisecond)); err != errUnavailable {
+			t.Fatalf("Read '%v': expected '%v' after waiting for longer than maxWait, got '%v'\n", name, errUnavailable, err)
+		}
+
+		com := newTestCommunication(1, time.Second)
+		waiting := make(chan error)
+		go func() {
+			_, err := com.exchange(context.Background(), time.Now(), 1, "", "")
+			waiting <- err
+		}()
+		for com.QueueDepth() != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		if err := read(com); err != errSaturated {
+			t.Fatalf("Read '%v': expected '%v' with a full queue, got '%v'\n", name, errSaturated, err)
+		}
+		com.Stop()
+		<-waiting
+		if err := read(com); err != errShuttingDown {
+			t.Fatalf("Read '%v': expected '%v' once stopped, got '%v'\n", name, errShuttingDown, err)
+		}
+	}
+}
+
+/* Many requests are sent while the processor is being stopped. Every request must either be counted and answered, or
+be rejected with errShuttingDown. Once Stop() returns, the state must hold exactly the answered requests and must be
+readable without a data race. Run with '-race' for the latter to be checked.
+*/
+func TestProcessorStopDrainsInFlightRequests(t *testing.T) {
+	const numRequests = 500
+	com := newTestCommunication(0, 0)
+	com.Start(context.Background())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	answered, rejected := 0, 0
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := com.exchange(context.Background(), time.Now(), 1, "", "")
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				answered++
+			case errShuttingDown:
+				rejected++
+			default:
+				t.Errorf("Unexpected error '%v'\n", err)
+			}
+		}()
+		if i == numRequests/2 {
+			go com.Stop()
+		}
+	}
+	wg.Wait()
+	com.Stop()
+
+	if answered+rejected != numRequests {
+		t.Fatalf("Expected '%v' requests to be either answered or rejected, got '%v' and '%v'\n", numRequests, answered, rejected)
+	}
+	if com.state.Present.TotalRequestsWithinTimeframe != answered {
+		t.Fatalf("Expected state to hold the '%v' answered requests, but it holds '%v'\n", answered, com.state.Present.TotalRequestsWithinTimeframe)
+	}
+
+	if _, err := com.exchange(context.Background(), time.Now(), 1, "", ""); err != errShuttingDown {
+		t.Fatalf("Expected '%v' after the processor was stopped, got '%v'\n", errShuttingDown, err)
+	}
+	select {
+	case <-com.lifecycle.done:
+	default:
+		t.Fatal("Expected processor goroutines to have returned after Stop()")
+	}
+}
+
+func TestProcessorStartAfterStop(t *testing.T) {
+	com := newTestCommunication(0, 0)
+	com.Stop()
+	com.Start(context.Background())
+	if com.lifecycle.started {
+		t.Fatal("Expected processor not to start after Stop()")
+	}
+	if _, err := com.exchange(context.Background(), time.Now(), 1, "", ""); err != errShuttingDown {
+		t.Fatalf("Expected '%v' for a stopped processor, got '%v'\n", errShuttingDown, err)
+	}
+}
+
+func TestExchangeKeyed(t *testing.T) {
+	com := newTestCommunication(0, 0)
+	com.Start(context.Background())
+	t0 := time.Date(2006, 01, 02, 15, 04, 05, 0, time.UTC)
+
+	steps := []struct {
+		hits     []keyHit
+		expected []int
+	}{
+		{hits: []keyHit{{key: "a", timestamp: t0, n: 1}}, expected: []int{1}},
+		{hits: []keyHit{{key: "a", timestamp: t0, n: 1}, {key: "b", timestamp: t0}}, expected: []int{2, 0}},
+		{hits: []keyHit{{key: "b", timestamp: t0.Add(time.Hour), n: 1}, {key: "a", timestamp: t0.Add(time.Hour), n: 3}}, expected: []int{1, 5}},
+		{hits: []keyHit{{key: "a", timestamp: t0.Add(2 * time.Hour)}}, expected: []int{3}},
+		{hits: []keyHit{{key: "a", timestamp: t0.Add(5 * time.Hour)}}, expected: []int{0}},
+	}
+	for i, step := range steps {
+		counts, err := com.exchangeKeyed(context.Background(), step.hits...)
+		if err != nil || !reflect.DeepEqual(counts, step.expected) {
+			t.Fatalf("Expected counts '%v' at step '%v', got '%v' (error: %v)\n", step.expected, i, counts, err)
+		}
+	}
+
+	com.Stop()
+	if len(com.state.Keys) != 0 {
+		t.Fatalf("Expected keys without requests within the time frame to be forgotten, got '%v'\n", com.state.Keys)
+	}
+	if !com.state.Present.Empty() {
+		t.Fatalf("Expected keyed requests not to be counted for the server, got '%+v'\n", com.state.Present)
+	}
+}
+
+func TestExchangeLate(t *testing.T) {
+	com := NewCommunication(Environment{
+		PersistenceTimeFrame: 10 * time.Second,
+		Precision:            time.Second,
+		Lateness:             2 * time.Second,
+	}, log.New(ioutil.Discard, "", 0))
+	com.Start(context.Background())
+	defer com.Stop()
+	t0 := time.Date(2006, 01, 02, 15, 04, 05, 0, time.UTC)
+
+	steps := []struct {
+		timestamp time.Time
+		expected  int
+	}{
+		{timestamp: t0, expected: 1},
+		{timestamp: t0.Add(3 * time.Second), expected: 2},
+		{timestamp: t0.Add(2 * time.Second), expected: 3}, // late: inserted into the past
+		{timestamp: t0, expected: 4},                      // beyond the tolerance: counted as part of the present
+		{timestamp: t0.Add(12 * time.Second), expected: 4},
+		{timestamp: t0.Add(13 * time.Second), expected: 4},
+		{timestamp: t0.Add(14 * time.Second), expected: 3},
+	}
+	for i, step := range steps {
+		cache, err := com.exchange(context.Background(), step.timestamp, 1, "", "")
+		if err != nil || cache.TotalRequestsWithinTimeframe != step.expected {
+			t.Fatalf("Expected count '%v' at step '%v', got '%v' (error: %v)\n", step.expected, i, cache.TotalRequestsWithinTimeframe, err)
+		}
+	}
+
+	// batches with a hit beyond the tolerance are rejected as a whole
+	if _, err := com.exchangeKeyed(context.Background(), keyHit{key: "a", timestamp: t0.Add(5 * time.Second), n: 1}); err != nil {
+		t.Fatalf("Could not hit key: %v\n", err)
+	}
+	_, err := com.exchangeKeyed(context.Background(),
+		keyHit{key: "b", timestamp: t0.Add(5 * time.Second), n: 1},
+		keyHit{key: "a", timestamp: t0.Add(4 * time.Second), n: 1},
+		keyHit{key: "a", timestamp: t0, n: 1})
+	var rejected apiError
+	if !errors.As(err, &rejected) || rejected.code != errBadRequest.code {
+		t.Fatalf("Expected a '%v' error for a hit beyond the lateness tolerance, got '%v'\n", errBadRequest.code, err)
+	}
+	counts, err := com.exchangeKeyed(context.Background(), keyHit{key: "a", timestamp: t0.Add(5 * time.Second)}, keyHit{key: "b", timestamp: t0.Add(5 * time.Second)})
+	if err != nil || !reflect.DeepEqual(counts, []int{1, 0}) {
+		t.Fatalf("Expected nothing of the rejected batch to be counted, got '%v' (error: %v)\n", counts, err)
+	}
+}
+
+func TestExchangeApproximate(t *testing.T) {
+	com := NewCommunication(Environment{
+		PersistenceTimeFrame: 10 * time.Second,
+		Precision:            time.Second,
+		Approximate:          true,
+	}, log.New(ioutil.Discard, "", 0))
+	com.Start(context.Background())
+	t0 := time.Date(2006, 01, 02, 15, 04, 00, 0, time.UTC)
+
+	steps := []struct {
+		timestamp time.Time
+		weight    int
+		expected  int
+	}{
+		{timestamp: t0, weight: 4, expected: 4},
+		{timestamp: t0.Add(5 * time.Second), weight: 2, expected: 6},
+		{timestamp: t0.Add(3 * time.Second), weight: 1, expected: 7},  // late: counted in its window
+		{timestamp: t0.Add(15 * time.Second), weight: 1, expected: 5}, // half of the previous window overlaps
+		{timestamp: t0.Add(25 * time.Second), weight: 1, expected: 2},
+		{timestamp: t0.Add(40 * time.Second), weight: 1, expected: 1},
+	}
+	for i, step := range steps {
+		cache, err := com.exchange(context.Background(), step.timestamp, step.weight, "", "")
+		if err != nil || cache.TotalRequestsWithinTimeframe != step.expected {
+			t.Fatalf("Expected count '%v' at step '%v', got '%v' (error: %v)\n", step.expected, i, cache.TotalRequestsWithinTimeframe, err)
+		}
+	}
+
+	com.Stop()
+	if requestCounts := com.state.Past.RequestCounts(); len(requestCounts) != 0 {
+		t.Fatalf("Expected no past to be kept in approximate mode, got '%v'\n", requestCounts)
+	}
+}
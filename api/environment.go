@@ -2,6 +2,8 @@ package api
 
 import (
 	"flag"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -9,12 +11,80 @@ import (
 - ListenAddress: port on which the server will be listening
 - PersistenceFile: destination file on disk for serialization of state upon incoming interrupt signals
 - PersistenceTimeFrame: duration of the moving window for which total incoming requests will be calculated
+- MaxQueueDepth: maximum number of requests waiting to be counted before new ones are rejected. Zero means no limit.
+- MaxWait: maximum time a request waits to be counted before it is rejected. Zero means no limit.
+- Weight: number of units a request counts for. Defaults to one per request. See WeightFunc.
+- Client: identity of the client a request came from, to count distinct clients. Defaults to its IP address. See ClientFunc.
+- Key: key of the keyed counter requests to the index are counted in as well, such as the subject of their client
+  certificate. Nil counts them in none. See ParseKey.
+- LatencyPercentiles: keep a sketch of the latencies of the responses of every unit of precision, to serve percentiles at
+  /latency. Off by default, as sketches take memory in every unit of precision and in the state file.
+- UniqueClients: estimate the distinct clients within the time frame. Off by default, as every unit of precision keeps
+  an estimator of its clients, in memory and in the state file.
+- Lateness: how far behind the latest request of a counter a late request may be to still be counted in its place.
+- HalfLife: half-life of the decayed rate of requests reported along with the count. Zero disables it.
+- Approximate: estimate the request count in constant memory rather than keeping every unit of precision of the time frame.
+- Limits: rate limits of routes, by the pattern they are registered with. See Limit.
+- Quotas: quotas of keyed counters over calendar windows, by key pattern. See Quota.
+- AlertRules: thresholds on the request counts of counters to alert on. See AlertRule.
+- AlertWebhook: URL alerts are posted to whenever they fire or resolve. Empty disables notifications.
+- Credentials: API keys and token secrets requests must authenticate with, by scope. Nil disables authentication.
+- PeerToken: credential presented to peers when pulling their request counts, if they require authentication.
+- TLSCert, TLSKey: certificate and private key to serve HTTPS with. Empty serves plain HTTP.
+- ClientCA: certificate authorities client certificates must be signed by, for mutual TLS. Empty disables it.
+- ClientCertOptional: clients without a certificate are let through over mutual TLS.
+- PeerCA: certificate authorities the certificates of peers are verified against over TLS. Empty falls back to ClientCA.
+- TrustedProxies: networks of the proxies whose forwarding headers tell the address of the client. See TrustedProxies.
+- EagerInit: restore state and start the communication processor before accepting traffic, instead of on the first request.
+- NodeID: identifier of this instance within a cluster. Must be unique across all replicas.
+- Peers: base URLs of the replicas whose request counts are added to those of this instance.
+- ReplicationInterval: how often request counts are pulled from peers.
+- GossipAddress: UDP address on which to take part in the cluster membership protocol. Empty disables it.
+- Join: UDP addresses of members of the cluster to join through.
+- GossipKey: secret the messages of the membership protocol are signed with. Empty leaves them unsigned.
+- GossipInterval: protocol period of the membership protocol.
+- AdvertiseURL: base URL under which other members can reach the HTTP server of this instance.
+- RESPAddress: TCP address on which to serve Redis clients. Empty disables it.
+- RPCAddress: TCP address on which to serve the binary protocol. Empty disables it.
 */
 type Environment struct {
 	ListenAddress        string
 	PersistenceFile      string
 	PersistenceTimeFrame time.Duration
 	Precision            time.Duration
+	MaxQueueDepth        int
+	MaxWait              time.Duration
+	Weight               WeightFunc
+	Client               ClientFunc
+	Key                  ClientFunc
+	UniqueClients        bool
+	LatencyPercentiles   bool
+	Lateness             time.Duration
+	HalfLife             time.Duration
+	Approximate          bool
+	Limits               map[string]Limit
+	Quotas               map[string]Quota
+	AlertRules           []AlertRule
+	AlertWebhook         string
+	Credentials          *Credentials
+	PeerToken            string
+	TLSCert              string
+	TLSKey               string
+	ClientCA             string
+	ClientCertOptional   bool
+	PeerCA               string
+	TrustedProxies       TrustedProxies
+	EagerInit            bool
+	NodeID               string
+	Peers                []string
+	ReplicationInterval  time.Duration
+	GossipAddress        string
+	Join                 []string
+	GossipKey            string
+	GossipInterval       time.Duration
+	AdvertiseURL         string
+	RESPAddress          string
+	RPCAddress           string
 }
 
 /* Parsing of command line flags to set environment values.
@@ -29,6 +99,54 @@ func ParseEnvironment() Environment {
 	var precision string
 	flag.StringVar(&precision, "precision", "100ms", "Timestamps that differ by this ammount will be considered to be equal and their counts cached faster")
 	flag.StringVar(&env.PersistenceFile, "persistence-file", "persistence.bin", "File to which state will be persisted upon server termination")
+	flag.IntVar(&env.MaxQueueDepth, "max-queue-depth", 10000, "Maximum number of requests waiting to be counted. Further requests are rejected with a 503. Zero means no limit")
+	var maxWait string
+	flag.StringVar(&maxWait, "max-wait", "5s", "Maximum time a request waits to be counted before it is rejected with a 503. Zero means no limit")
+	var weight string
+	flag.StringVar(&weight, "weight", "unit", "Units a request counts for: 'unit', 'header:<name>', 'query:<name>' or 'request-bytes'")
+	var client string
+	flag.StringVar(&client, "client", "ip", "Identity of the client a request came from, to count distinct clients: 'ip', 'header:<name>', 'query:<name>', 'certificate' or 'none'")
+	var key string
+	flag.StringVar(&key, "key", "none", "Keyed counter requests to the index are counted in as well, which gets the quota of its key: 'none', 'header:<name>', 'query:<name>' or 'cert-subject'")
+	flag.BoolVar(&env.LatencyPercentiles, "latency-percentiles", false, "Keep a sketch of the latencies of the responses of every unit of precision, to serve percentiles at '/latency'")
+	flag.BoolVar(&env.UniqueClients, "unique-clients", false, "Estimate the distinct clients within the time frame, as told by '--client'. Every unit of precision keeps a 1KB estimator of its clients")
+	var lateness string
+	flag.StringVar(&lateness, "lateness", "1s", "How far behind the latest request of a counter a late request may be to still be counted in its place")
+	var halfLife string
+	flag.StringVar(&halfLife, "half-life", "0s", "Half-life of the decayed rate of requests per second reported along with the count. Zero disables it")
+	flag.BoolVar(&env.Approximate, "approximate", false, "Estimate the request count from two fixed windows in constant memory, instead of counting every unit of precision of the time frame")
+	var limits string
+	flag.StringVar(&limits, "limits", "", "Comma separated rate limits of routes per client, as '<route>=<algorithm>:<requests>/<period>:<burst>' with 'token-bucket' or 'gcra' as the algorithm, e.g. '/=token-bucket:10/s:20'")
+	var quotas string
+	flag.StringVar(&quotas, "quotas", "", "Comma separated quotas of keyed counters per calendar day or month, as '<key pattern>=<period>:<limit>[:<time zone>]' with 'daily' or 'monthly' as the period, e.g. 'tenant:*=daily:10000'")
+	var alertRules string
+	flag.StringVar(&alertRules, "alerts", "", "Comma separated alert rules, as '<name>=<counter>[/<window>]<operator><threshold>[~<clear>]' with 'count' or 'key:<key>' as the counter and '>' or '<' as the operator, e.g. 'busy=count/60s>10000~9000'")
+	flag.StringVar(&env.AlertWebhook, "alert-webhook", "", "URL alerts are posted to as JSON whenever they fire or resolve. Empty disables notifications")
+	var authFile string
+	flag.StringVar(&authFile, "auth-file", "", "File holding the API keys and token secrets requests must authenticate with, one '<kind> <name> <secret> <scopes>' per line. Empty disables authentication")
+	flag.StringVar(&env.PeerToken, "peer-token", "", "API key or token with the 'admin' scope presented to peers when pulling their request counts")
+	flag.StringVar(&env.TLSCert, "tls-cert", "", "PEM encoded certificate chain to serve HTTPS with, along with '--tls-key'. Reloaded on SIGHUP. Empty serves plain HTTP")
+	flag.StringVar(&env.TLSKey, "tls-key", "", "PEM encoded private key of the certificate to serve HTTPS with")
+	flag.StringVar(&env.ClientCA, "client-ca", "", "PEM encoded certificate authorities client certificates must be signed by, for mutual TLS. Empty disables it")
+	flag.BoolVar(&env.ClientCertOptional, "client-cert-optional", false, "Let clients without a certificate through over mutual TLS. Those with one still need it to be valid")
+	flag.StringVar(&env.PeerCA, "peer-ca", "", "PEM encoded certificate authorities the certificates of peers are verified against when replicating over HTTPS. Empty falls back to '--client-ca', or else to those of the system")
+	var trustedProxies string
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Comma separated addresses or CIDR networks of the proxies whose 'Forwarded', 'X-Forwarded-For' and 'X-Real-IP' headers tell the address of the client, e.g. '10.0.0.0/8'. Empty ignores these headers")
+	flag.BoolVar(&env.EagerInit, "eager-init", false, "Restore state and start counting before accepting traffic, instead of on the first request")
+	flag.StringVar(&env.NodeID, "node-id", "", "Unique identifier of this instance within a cluster. Defaults to hostname and listen address")
+	var peers string
+	flag.StringVar(&peers, "peers", "", "Comma separated base URLs of the replicas to exchange request counts with, e.g. 'http://10.0.0.2:5000'")
+	var replicationInterval string
+	flag.StringVar(&replicationInterval, "replication-interval", "1s", "How often request counts are pulled from peers")
+	flag.StringVar(&env.GossipAddress, "gossip-address", "", "UDP address on which to discover other instances of the cluster, e.g. ':7946'. Empty disables discovery")
+	var join string
+	flag.StringVar(&join, "join", "", "Comma separated UDP addresses of instances of the cluster to join through")
+	flag.StringVar(&env.GossipKey, "gossip-key", "", "Secret shared by the instances of the cluster to sign membership messages with. Messages signed with any other are ignored. Empty leaves them unsigned")
+	var gossipInterval string
+	flag.StringVar(&gossipInterval, "gossip-interval", "1s", "Protocol period of the cluster membership: how often a member is checked for failures")
+	flag.StringVar(&env.AdvertiseURL, "advertise-url", "", "Base URL under which other instances reach this one. Defaults to the hostname and listen address, over HTTPS with '--tls-cert'")
+	flag.StringVar(&env.RESPAddress, "resp-address", "", "TCP address on which to serve Redis clients with keyed counters, e.g. ':6379'. Empty disables it")
+	flag.StringVar(&env.RPCAddress, "rpc-address", "", "TCP address on which to serve the binary protocol for keyed counters, e.g. ':5001'. Empty disables it")
 	flag.Parse()
 
 	var err error
@@ -42,5 +160,94 @@ func ParseEnvironment() Environment {
 		panic(err) //OK: need env variable to be parsable.
 	}
 
+	env.MaxWait, err = time.ParseDuration(maxWait)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Weight, err = ParseWeight(weight)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Client, err = ParseClient(client)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Key, err = ParseKey(key)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Lateness, err = time.ParseDuration(lateness)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.HalfLife, err = time.ParseDuration(halfLife)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Limits, err = ParseLimits(limits)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.Quotas, err = ParseQuotas(quotas)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.AlertRules, err = ParseAlertRules(alertRules)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	if authFile != "" {
+		env.Credentials, err = LoadCredentials(authFile)
+		if err != nil {
+			panic(err) //OK: need env variable to be parsable.
+		}
+	}
+
+	env.TrustedProxies, err = ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.ReplicationInterval, err = time.ParseDuration(replicationInterval)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	env.GossipInterval, err = time.ParseDuration(gossipInterval)
+	if err != nil {
+		panic(err) //OK: need env variable to be parsable.
+	}
+
+	if peers != "" {
+		env.Peers = strings.Split(peers, ",")
+	}
+	if join != "" {
+		env.Join = strings.Split(join, ",")
+	}
+	hostname, _ := os.Hostname()
+	if env.NodeID == "" {
+		env.NodeID = hostname + env.ListenAddress
+	}
+	if env.AdvertiseURL == "" {
+		scheme := "http://"
+		if env.TLSCert != "" {
+			scheme = "https://"
+		}
+		if strings.HasPrefix(env.ListenAddress, ":") {
+			env.AdvertiseURL = scheme + hostname + env.ListenAddress
+		} else {
+			env.AdvertiseURL = scheme + env.ListenAddress
+		}
+	}
+
 	return env
 }
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+/* Errors known to the API. Each of them maps to an HTTP status and a stable, machine readable code that clients can
+rely on, independently of the human readable message.
+*/
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.message
+}
+
+/* Same error, with a message detailing the cause.
+ */
+func (e apiError) withMessage(format string, args ...interface{}) apiError {
+	e.message = fmt.Sprintf(format, args...)
+	return e
+}
+
+var (
+	errBadRequest       = apiError{status: http.StatusBadRequest, code: "bad_request", message: "The request is malformed or has invalid arguments"}
+	errUnauthorized     = apiError{status: http.StatusUnauthorized, code: "unauthorized", message: "The request lacks a valid API key or token"}
+	errForbidden        = apiError{status: http.StatusForbidden, code: "forbidden", message: "The credential of the request does not grant access to this resource"}
+	errNotFound         = apiError{status: http.StatusNotFound, code: "not_found", message: "The requested resource does not exist"}
+	errMethodNotAllowed = apiError{status: http.StatusMethodNotAllowed, code: "method_not_allowed", message: "The request method is not supported by this resource"}
+	errTooManyRequests  = apiError{status: http.StatusTooManyRequests, code: "too_many_requests", message: "Request limit exceeded"}
+	errInternal         = apiError{status: http.StatusInternalServerError, code: "internal_error", message: "The request could not be processed"}
+	errUnavailable      = apiError{status: http.StatusServiceUnavailable, code: "unavailable", message: "The service is temporarily unavailable"}
+	errSaturated        = apiError{status: http.StatusServiceUnavailable, code: "saturated", message: "Too many requests are waiting to be processed"}
+	errShuttingDown     = apiError{status: http.StatusServiceUnavailable, code: "shutting_down", message: "The server is shutting down"}
+)
+
+/* Common envelope for all error responses. Request and trace IDs of the failed request are included so that clients
+can refer to them when reporting issues.
+Exported for tests to consume
+*/
+type ResponseError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+	TraceID   string `json:"traceId,omitempty"`
+	status    int
+}
+
+/* Maps the provided error to the envelope. Errors outside of the API taxonomy are reported as generic internal errors:
+their message may tell about the internals of the server, such as file paths, so it is not passed on to the client.
+*/
+func NewResponseError(r *http.Request, err error) ResponseError {
+	var known apiError
+	if !errors.As(err, &known) {
+		known = errInternal
+	}
+	return ResponseError{
+		Code:      known.code,
+		Message:   known.message,
+		RequestID: requestIDFromContext(r.Context()),
+		TraceID:   traceContextFromContext(r.Context()).traceID,
+		status:    known.status,
+	}
+}
+
+func (r ResponseError) Status() int {
+	return r.status
+}
+
+/* The envelope only holds strings, so encoding is not expected to fail. Should it do so anyway, a hand-written
+envelope is returned instead of taking the server down.
+*/
+func (r ResponseError) ToJSON() string {
+	encodedError, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf(`{"code":%q,"message":%q,"requestId":%q}`, errInternal.code, errInternal.message, r.RequestID)
+	}
+	return string(encodedError)
+}
+
+/* Logger of the server handling the request. Falls back to the standard logger outside of a server, e.g. in tests.
+ */
+func errorLogger(r *http.Request) *log.Logger {
+	if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.ErrorLog != nil {
+		return srv.ErrorLog
+	}
+	return log.Default()
+}
+
+/* Writes the envelope of the error. Errors outside of the API taxonomy are logged along with the request ID, since
+the client is only told that the request could not be processed.
+*/
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	responseError := NewResponseError(r, err)
+	if !errors.As(err, new(apiError)) {
+		errorLogger(r).Printf("Request '%v' failed: %v\n", responseError.RequestID, err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(responseError.Status())
+	fmt.Fprint(w, responseError.ToJSON())
+}
+
+/* Encodes the value and writes it with the given status. Encoding errors result in an internal error response.
+ */
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(encoded))
+}
+
+/* Restricts a handler to the given methods. Other methods are answered with a 405 error carrying the 'Allow' header.
+ */
+func allowMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				next(w, r)
+				return
+			}
+		}
+		for _, method := range methods {
+			w.Header().Add("Allow", method)
+		}
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer() *server {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	return srv
+}
+
+type errorResponseTest struct {
+	method         string
+	path           string
+	shutdown       bool
+	expectedStatus int
+	expectedCode   string
+}
+
+var errorResponseTestList = []errorResponseTest{
+	{method: "GET", path: "/unknown", expectedStatus: http.StatusNotFound, expectedCode: "not_found"},
+	{method: "DELETE", path: "/stats", expectedStatus: http.StatusMethodNotAllowed, expectedCode: "method_not_allowed"},
+	{method: "GET", path: "/", shutdown: true, expectedStatus: http.StatusServiceUnavailable, expectedCode: "shutting_down"},
+}
+
+func TestErrorResponses(t *testing.T) {
+	for i, test := range errorResponseTestList {
+		srv := newTestServer()
+		if test.shutdown {
+			srv.Communication.Stop()
+		}
+
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set(requestIDHeader, "test-request")
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+
+		if w.Code != test.expectedStatus {
+			t.Fatalf("Expected status '%v' but got '%v' for test '%v'\n", test.expectedStatus, w.Code, i)
+		}
+		var envelope ResponseError
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("Could not decode error envelope '%v' for test '%v': %v\n", w.Body.String(), i, err)
+		}
+		if envelope.Code != test.expectedCode || envelope.Message == "" || envelope.RequestID != "test-request" {
+			t.Fatalf("Unexpected error envelope '%+v' for test '%v'\n", envelope, i)
+		}
+	}
+}
+
+func TestIndexAnyMethod(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Stop()
+	for i, method := range []string{"GET", "POST", "DELETE"} {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		var response Response
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Expected '%v' to be counted, got status '%v' and body '%v'\n", method, w.Code, w.Body.String())
+		}
+		if response.RequestCount != i+1 {
+			t.Fatalf("Expected request count '%v' after '%v', got '%+v'\n", i+1, method, response)
+		}
+	}
+}
+
+func TestNewResponseErrorUnknownError(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	responseError := NewResponseError(r, errors.New("open /var/lib/state.bin: permission denied"))
+	if responseError.Status() != http.StatusInternalServerError || responseError.Code != errInternal.code || responseError.Message != errInternal.message {
+		t.Fatalf("Expected unknown errors to be reported as generic internal errors, got '%+v'\n", responseError)
+	}
+}
+
+func TestWriteErrorLogsUnknownError(t *testing.T) {
+	var logged bytes.Buffer
+	srv := &http.Server{ErrorLog: log.New(&logged, "", 0)}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, srv))
+	w := httptest.NewRecorder()
+	writeError(w, r, errors.New("open /var/lib/state.bin: permission denied"))
+
+	if strings.Contains(w.Body.String(), "state.bin") {
+		t.Fatalf("Expected the cause not to be passed on to the client, got '%v'\n", w.Body.String())
+	}
+	if !strings.Contains(logged.String(), "state.bin") {
+		t.Fatalf("Expected the cause to be logged, got '%v'\n", logged.String())
+	}
+}
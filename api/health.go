@@ -0,0 +1,78 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+/* Liveness: as long as the process is able to answer, it is alive.
+ */
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+func (s *server) Healthz() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, HealthResponse{Status: "ok"})
+	})
+}
+
+/* Readiness: the server is ready to count requests once
+- Initialized: the server has been initialized. Until then, in lazy mode, it is ready as long as the persistence file is
+  writable, since the first counted request initializes it.
+- StateRestored: the persisted state has been read - or found to be missing - from disk. A file that could not be read
+  counts as missing once moved aside. See server::readStateFromDisk.
+- ProcessorRunning: the communication processor has been started and not stopped
+- PersistenceWritable: the state could be written to the persistence file on shutdown. Why it could not is only logged,
+  as probes are not authenticated.
+*/
+type ReadinessResponse struct {
+	Ready               bool   `json:"ready"`
+	Initialized         bool   `json:"initialized"`
+	StateRestored       bool   `json:"stateRestored"`
+	ProcessorRunning    bool   `json:"processorRunning"`
+	PersistenceWritable bool   `json:"persistenceWritable"`
+	PersistenceError    string `json:"persistenceError,omitempty"`
+}
+
+const persistenceNotWritable = "persistence file is not writable"
+
+/* Checks that a file can be created in the directory of the persistence file, which is what happens on shutdown.
+The probe file is removed right away.
+*/
+func checkWritable(path string) error {
+	probe, err := ioutil.TempFile(filepath.Dir(path), ".readyz-")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+/* Answers with a 200 if the server is ready, and with a 503 otherwise. The body details which of the checks failed.
+Probes only report the state of the server: they neither initialize it nor are they counted.
+*/
+func (s *server) Readyz() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readiness := ReadinessResponse{
+			Initialized:         s.Initialized(),
+			StateRestored:       s.StateRestored(),
+			ProcessorRunning:    s.Communication.Running(),
+			PersistenceWritable: true,
+		}
+		if err := checkWritable(s.persistenceFile); err != nil {
+			readiness.PersistenceWritable = false
+			readiness.PersistenceError = persistenceNotWritable
+			s.ErrorLog.Printf("Persistence file '%v' is not writable: %v\n", s.persistenceFile, err)
+		}
+		readiness.Ready = readiness.PersistenceWritable && (!readiness.Initialized || readiness.StateRestored && readiness.ProcessorRunning)
+
+		status := http.StatusOK
+		if !readiness.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, r, status, readiness)
+	})
+}
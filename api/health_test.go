@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	srv := newTestServer()
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected liveness probe to succeed, got '%v'\n", w.Code)
+	}
+	if srv.Initialized() {
+		t.Fatal("Expected liveness probe not to initialize the server")
+	}
+}
+
+type readyzTest struct {
+	persistenceDir string // relative to a temporary directory; missing directories make persistence unwritable
+	lazy           bool
+	corrupt        bool
+	stop           bool
+	expected       ReadinessResponse
+}
+
+var readyzTestList = []readyzTest{
+	{ // ready
+		persistenceDir: ".",
+		expected:       ReadinessResponse{Ready: true, Initialized: true, StateRestored: true, ProcessorRunning: true, PersistenceWritable: true},
+	},
+	{ // processor stopped
+		persistenceDir: ".",
+		stop:           true,
+		expected:       ReadinessResponse{Ready: false, Initialized: true, StateRestored: true, ProcessorRunning: false, PersistenceWritable: true},
+	},
+	{ // persistence not writable
+		persistenceDir: "missing",
+		expected:       ReadinessResponse{Ready: false, Initialized: true, StateRestored: true, ProcessorRunning: true, PersistenceWritable: false, PersistenceError: persistenceNotWritable},
+	},
+	{ // persisted state could not be read: it is moved aside
+		persistenceDir: ".",
+		corrupt:        true,
+		expected:       ReadinessResponse{Ready: true, Initialized: true, StateRestored: true, ProcessorRunning: true, PersistenceWritable: true},
+	},
+	{ // lazy and not initialized yet: the first counted request initializes it
+		persistenceDir: ".",
+		lazy:           true,
+		expected:       ReadinessResponse{Ready: true, Initialized: false, StateRestored: false, ProcessorRunning: false, PersistenceWritable: true},
+	},
+	{ // lazy, but persistence not writable
+		persistenceDir: "missing",
+		lazy:           true,
+		expected:       ReadinessResponse{Ready: false, Initialized: false, StateRestored: false, ProcessorRunning: false, PersistenceWritable: false, PersistenceError: persistenceNotWritable},
+	},
+}
+
+func TestReadyz(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "readyz")
+	if err != nil {
+		t.Fatalf("Error creating test data directory during test setup: '%v'\n", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	for i, test := range readyzTestList {
+		srv := newTestServer()
+		srv.ErrorLog.SetOutput(ioutil.Discard)
+		srv.persistenceFile = filepath.Join(testDir, test.persistenceDir, "persistence.bin")
+		if test.corrupt {
+			if err := ioutil.WriteFile(srv.persistenceFile, []byte("not a state"), 0644); err != nil {
+				t.Fatalf("Error writing the persistence file for test '%v': %v\n", i, err)
+			}
+		}
+		if !test.lazy {
+			srv.Initialize()
+		}
+		if test.stop {
+			srv.Communication.Stop()
+		}
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+		var readiness ReadinessResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &readiness); err != nil {
+			t.Fatalf("Could not decode readiness '%v' for test '%v': %v\n", w.Body.String(), i, err)
+		}
+		if readiness != test.expected {
+			t.Fatalf("Expected readiness '%+v' but got '%+v' for test '%v'\n", test.expected, readiness, i)
+		}
+		expectedStatus := http.StatusOK
+		if !test.expected.Ready {
+			expectedStatus = http.StatusServiceUnavailable
+		}
+		if w.Code != expectedStatus {
+			t.Fatalf("Expected status '%v' but got '%v' for test '%v'\n", expectedStatus, w.Code, i)
+		}
+		if test.corrupt {
+			if moved, err := ioutil.ReadFile(srv.persistenceFile + ".corrupt"); err != nil || string(moved) != "not a state" {
+				t.Fatalf("Expected the unreadable file to be moved aside for test '%v', got '%v': %v\n", i, string(moved), err)
+			}
+			if _, err := os.Stat(srv.persistenceFile); !os.IsNotExist(err) {
+				t.Fatalf("Expected the unreadable file to be gone for test '%v': %v\n", i, err)
+			}
+			os.Remove(srv.persistenceFile + ".corrupt")
+		}
+		if test.lazy && srv.Initialized() {
+			t.Fatalf("Expected readiness probes not to initialize the server for test '%v'\n", i)
+		}
+		os.Remove(srv.persistenceFile)
+		srv.Communication.Stop()
+		if srv.Communication.state.Present.TotalRequestsWithinTimeframe != 0 {
+			t.Fatalf("Expected readiness probes not to be counted for test '%v'\n", i)
+		}
+	}
+}
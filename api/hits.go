@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// larger bodies are rejected before being decoded
+	maxHitsBodyBytes = 10 << 20
+	// larger batches are rejected, so that a single request cannot hold the communication processor for long
+	maxHitsBatchSize = 10000
+)
+
+/* Entry of a batch of hits: 'count' requests for the keyed counter 'key' at 'timestamp'. Keyed counters are shared with
+the RESP and RPC listeners.
+*/
+type HitEntry struct {
+	Key       string    `json:"key"`
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+}
+
+/* Request count within the persistence time frame of every key of the batch, once the batch has been applied.
+ */
+type HitsResponse struct {
+	Accepted int            `json:"accepted"`
+	Counts   map[string]int `json:"counts"`
+}
+
+/* Ingests a batch of hits aggregated by the client, e.g. by an edge proxy reporting 'key X got 250 hits at time T'
+instead of making 250 calls. The body is either a JSON array of entries or newline delimited JSON (NDJSON) entries.
+The batch is validated as a whole before anything is counted:
+- keys must not be empty, and counts must be positive
+- timestamps must be within the persistence time frame: neither older than it, nor later than the current time by more
+  than one unit of precision, which leaves room for clocks that are slightly ahead
+Entries are then applied in the order of their timestamps, as a single operation of the communication processor. Requests
+to it are not counted.
+*/
+func (s *server) Hits(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		entries, err := decodeHitEntries(http.MaxBytesReader(w, r.Body, maxHitsBodyBytes))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		hits := make([]keyHit, 0, 2*len(entries))
+		for i, entry := range entries {
+			if err := s.validateHitEntry(entry, now); err != nil {
+				writeError(w, r, errBadRequest.withMessage("Entry '%v': %v", i, err))
+				return
+			}
+			hits = append(hits, keyHit{key: entry.Key, timestamp: entry.Timestamp.Truncate(s.precision), n: entry.Count})
+		}
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].timestamp.Before(hits[j].timestamp) })
+
+		// the resulting counts are taken with the current time as the reference, once all entries have been counted
+		reference := now.Truncate(s.precision)
+		keys := make(map[string]int)
+		for _, hit := range hits[:len(entries)] {
+			if _, seen := keys[hit.key]; !seen {
+				keys[hit.key] = len(hits)
+				hits = append(hits, keyHit{key: hit.key, timestamp: reference})
+			}
+		}
+
+		counts, err := com.exchangeKeyed(r.Context(), hits...)
+		if err != nil {
+			s.Logger.Printf("Batch of '%v' hits could not be counted: %v. Queue depth: '%v'\n", len(entries), err, com.QueueDepth())
+			writeError(w, r, err)
+			return
+		}
+
+		response := HitsResponse{Accepted: len(entries), Counts: make(map[string]int, len(keys))}
+		for key, i := range keys {
+			response.Counts[key] = counts[i]
+		}
+		writeJSON(w, r, http.StatusOK, response)
+	})
+}
+
+/* Entries are read one by one, so that NDJSON bodies need not be held in memory as a whole before being decoded.
+ */
+func decodeHitEntries(body io.Reader) ([]HitEntry, error) {
+	reader := bufio.NewReader(body)
+	decoder := json.NewDecoder(reader)
+	entries := make([]HitEntry, 0)
+
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, errBadRequest.withMessage("The body must hold a JSON array or NDJSON entries: %v", err)
+	}
+	if first == '[' {
+		// opening bracket of the array
+		if _, err := decoder.Token(); err != nil {
+			return nil, decodeError(err)
+		}
+	}
+
+	for decoder.More() {
+		if len(entries) == maxHitsBatchSize {
+			return nil, errBadRequest.withMessage("Batches are limited to '%v' entries", maxHitsBatchSize)
+		}
+		var entry HitEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, decodeError(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if first == '[' {
+		// closing bracket of the array
+		if _, err := decoder.Token(); err != nil {
+			return nil, decodeError(err)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, errBadRequest.withMessage("The batch is empty")
+	}
+	return entries, nil
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+func decodeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errBadRequest.withMessage("The body exceeds '%v' bytes", tooLarge.Limit)
+	}
+	return errBadRequest.withMessage("Invalid entry: %v", err)
+}
+
+func (s *server) validateHitEntry(entry HitEntry, now time.Time) error {
+	switch {
+	case entry.Key == "":
+		return errors.New("the key must not be empty")
+	case entry.Count <= 0:
+		return fmt.Errorf("the count must be positive, got '%v'", entry.Count)
+	case entry.Timestamp.IsZero():
+		return errors.New("the timestamp is missing")
+	case entry.Timestamp.After(now.Add(s.precision)):
+		return fmt.Errorf("the timestamp '%v' is in the future", entry.Timestamp.Format(time.RFC3339Nano))
+	case now.Sub(entry.Timestamp) > s.persistenceTimeFrame:
+		return fmt.Errorf("the timestamp '%v' is outside of the time frame of '%v'", entry.Timestamp.Format(time.RFC3339Nano), s.persistenceTimeFrame)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type hitsTest struct {
+	body           string // '%[1]v' is replaced by the current time, '%[2]v' by the time two minutes ago, '%[3]v' by two minutes ahead
+	expectedStatus int
+	expectedCounts map[string]int
+}
+
+var hitsTestList = []hitsTest{
+	{ // JSON array
+		body:           `[{"key":"a","timestamp":"%[1]v","count":250},{"key":"b","timestamp":"%[1]v","count":1},{"key":"a","timestamp":"%[1]v","count":5}]`,
+		expectedStatus: http.StatusOK,
+		expectedCounts: map[string]int{"a": 255, "b": 1},
+	},
+	{ // NDJSON
+		body:           "{\"key\":\"a\",\"timestamp\":\"%[1]v\",\"count\":2}\n{\"key\":\"a\",\"timestamp\":\"%[1]v\",\"count\":3}\n",
+		expectedStatus: http.StatusOK,
+		expectedCounts: map[string]int{"a": 5},
+	},
+	{ // outside of the time frame: nothing is counted
+		body:           `[{"key":"a","timestamp":"%[1]v","count":1},{"key":"a","timestamp":"%[2]v","count":1}]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // in the future
+		body:           `[{"key":"a","timestamp":"%[3]v","count":1}]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // empty key
+		body:           `[{"key":"","timestamp":"%[1]v","count":1}]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // count not positive
+		body:           `[{"key":"a","timestamp":"%[1]v","count":0}]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // missing timestamp
+		body:           `[{"key":"a","count":1}]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // empty batch
+		body:           `[]`,
+		expectedStatus: http.StatusBadRequest,
+	},
+	{ // malformed
+		body:           `[{"key":"a",`,
+		expectedStatus: http.StatusBadRequest,
+	},
+}
+
+func TestHits(t *testing.T) {
+	for i, test := range hitsTestList {
+		srv := newTestServer()
+		now := time.Now()
+		body := fmt.Sprintf(test.body, now.Format(time.RFC3339Nano), now.Add(-2*time.Minute).Format(time.RFC3339Nano), now.Add(2*time.Minute).Format(time.RFC3339Nano))
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/hits", strings.NewReader(body)))
+		if w.Code != test.expectedStatus {
+			t.Fatalf("Expected status '%v' but got '%v' for test '%v': %v\n", test.expectedStatus, w.Code, i, w.Body.String())
+		}
+
+		if test.expectedStatus == http.StatusOK {
+			var response HitsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Could not decode response for test '%v': %v\n", i, err)
+			}
+			if !reflect.DeepEqual(response.Counts, test.expectedCounts) {
+				t.Fatalf("Expected counts '%v' but got '%v' for test '%v'\n", test.expectedCounts, response.Counts, i)
+			}
+		} else {
+			var response ResponseError
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != errBadRequest.code {
+				t.Fatalf("Expected a '%v' error for test '%v', got '%v'\n", errBadRequest.code, i, w.Body.String())
+			}
+			// batches are rejected as a whole
+			if count, err := srv.countKey("a", 0); err != nil || count != 0 {
+				t.Fatalf("Expected nothing to be counted for test '%v', got '%v' (error: %v)\n", i, count, err)
+			}
+		}
+		srv.Stop()
+	}
+}
@@ -1,11 +1,7 @@
 package api
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"sync"
 	"time"
 )
 
@@ -15,8 +11,10 @@ not make it to the client. Information hiding is the main purpose of this struct
 Exported for tests to consume
 */
 type Response struct {
-	timestamp    time.Time
-	RequestCount int `json:"requestCount"`
+	timestamp     time.Time
+	RequestCount  int      `json:"requestCount"`
+	UniqueClients *int     `json:"uniqueClients,omitempty"`
+	Rate          *float64 `json:"rate,omitempty"`
 }
 
 /* Allows for construction with specification of the timestamp member while avoid exporting of the timestamp
@@ -32,55 +30,78 @@ func (r Response) Timestamp() time.Time {
 	return r.timestamp
 }
 
-type ResponseError struct {
-	errorMsg string
-}
-
-func (r ResponseError) ToJSON() string {
-	encodedError, err := json.Marshal(r)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return string(encodedError)
-}
-
 /* Handler hangs on the server so that it can access to all communication and persistence variables.
 Variables are passed to the handler function in a closure fashion. Updating the communication values
 on the server will therefore have no effect in it's functionality.
-See communication::StartCommunicationProcessor for documentation on the workflow.
-Delaying init until the handler is actually called via sync.Once saves on http server boot up time.
+See communication::Start for documentation on the workflow.
+Unless the server was initialized eagerly, the first call of the handler initializes it. See server::Initialize.
+The request counts replicated from peers within the persistence time frame, as of the latest round of replication, are
+added to the local ones. See Replicator::Total.
+Every request counts for its weight, see WeightFunc. Requests whose weight cannot be determined are not counted.
+If enabled, the distinct clients within the persistence time frame are estimated from those of this instance only, see
+ClientFunc. Otherwise, requests are not told apart, so that units of precision keep no estimator of their clients.
+If a key is configured, requests are counted in the keyed counter of their key as well, see ParseKey.
+So is the decayed rate of requests per second, if enabled. See persistence::DecayedRate.
 */
 func (s *server) Index(com communication) http.HandlerFunc {
-	var init sync.Once
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		init.Do(s.initialize)
+		s.Initialize()
 
 		if r.URL.Path != "/" {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			writeError(w, r, errNotFound)
 			return
 		}
 
-		requestTimestamp := time.Now().Truncate(s.precision)
+		weight, err := s.weight(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		requestTimestamp := requestTimeFromContext(r.Context()).Truncate(s.precision)
 		s.Logger.Printf("RequestTimestamp: '%v'\n", requestTimestamp.Format(time.RFC3339))
 
-		com.exchangeTimestamp <- requestTimestamp
-		totalRequestsSoFar := <-com.exchangeRequestCount
+		client := ""
+		if s.uniqueClients {
+			client = s.client(r)
+		}
+		key := ""
+		if s.key != nil {
+			key = s.key(r)
+		}
+		totalRequestsSoFar, err := com.exchange(r.Context(), requestTimestamp, weight, client, key)
+		if err != nil {
+			s.Logger.Printf("Request could not be counted: %v. Queue depth: '%v'\n", err, com.QueueDepth())
+			writeError(w, r, err)
+			return
+		}
 		s.Logger.Printf("Response '%v'\n", totalRequestsSoFar)
 
 		response := Response{
 			timestamp:    totalRequestsSoFar.Timestamp,
-			RequestCount: totalRequestsSoFar.TotalRequestsWithinTimeframe,
+			RequestCount: totalRequestsSoFar.TotalRequestsWithinTimeframe + s.replicator.Total(),
 		}
-		encodedCache, err := json.Marshal(response)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, ResponseError{errorMsg: err.Error()}.ToJSON())
-			return
+		if s.uniqueClients {
+			uniqueClients := totalRequestsSoFar.TotalClients()
+			response.UniqueClients = &uniqueClients
+		}
+		if com.halfLife > 0 {
+			response.Rate = &totalRequestsSoFar.RatePerSecond
 		}
+		writeJSON(w, r, http.StatusOK, response)
+	})
+}
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, string(encodedCache))
+/* Number of handlers waiting for the communication processor. A steadily high value means that requests come in faster
+than they can be counted.
+*/
+type QueueResponse struct {
+	QueueDepth    int `json:"queueDepth"`
+	MaxQueueDepth int `json:"maxQueueDepth"`
+}
+
+func (s *server) Queue(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, QueueResponse{QueueDepth: com.QueueDepth(), MaxQueueDepth: int(com.maxQueueDepth)})
 	})
 }
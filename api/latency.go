@@ -0,0 +1,51 @@
+package api
+
+import (
+	"movingwindow/persistence"
+	"net/http"
+	"time"
+)
+
+/* Percentiles of the time taken to serve the requests counted by this instance within the persistence time frame, as
+of the time of the request, in milliseconds. Percentiles are estimated within the accuracy of persistence::Sketch,
+the maximum is exact.
+*/
+type LatencyResponse struct {
+	Responses int     `json:"responses"`
+	P50Ms     float64 `json:"p50Ms"`
+	P95Ms     float64 `json:"p95Ms"`
+	P99Ms     float64 `json:"p99Ms"`
+	MaxMs     float64 `json:"maxMs"`
+}
+
+func NewLatencyResponse(requestCounts []persistence.RequestCount, reference time.Time, timeFrame time.Duration, precision time.Duration) LatencyResponse {
+	_, aggregates := aggregatesWithin(requestCounts, reference, timeFrame, precision)
+	return LatencyResponse{
+		Responses: aggregates.Requests,
+		P50Ms:     milliseconds(aggregates.Latencies.Quantile(0.5)),
+		P95Ms:     milliseconds(aggregates.Latencies.Quantile(0.95)),
+		P99Ms:     milliseconds(aggregates.Latencies.Quantile(0.99)),
+		MaxMs:     milliseconds(aggregates.MaxLatency),
+	}
+}
+
+/* Serves the latency percentiles of the requests within the persistence time frame. The sketches of the units of
+precision within it are merged on every call. Requests to it are not counted.
+Sketches are only kept if enabled. Otherwise, there are no percentiles to serve, and the route is not found.
+*/
+func (s *server) Latency(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.latencyPercentiles {
+			writeError(w, r, errNotFound.withMessage("Latency percentiles are not enabled"))
+			return
+		}
+		s.Initialize()
+
+		requestCounts, err := com.snapshot(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, NewLatencyResponse(requestCounts, time.Now(), s.persistenceTimeFrame, s.precision))
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"movingwindow/persistence"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewLatencyResponse(t *testing.T) {
+	reference := time.Date(2000, 1, 1, 0, 0, 10, 0, time.UTC)
+	var inWindow, outOfWindow persistence.Aggregates
+	for i := 1; i <= 100; i++ {
+		inWindow = inWindow.Merge(persistence.NewAggregates(0, time.Duration(i)*time.Millisecond))
+		outOfWindow = outOfWindow.Merge(persistence.NewAggregates(0, time.Minute))
+	}
+	requestCounts := []persistence.RequestCount{
+		{Timestamp: reference.Add(-20 * time.Second), Count: 100, Aggregates: outOfWindow},
+		{Timestamp: reference.Add(-5 * time.Second), Count: 50, Aggregates: inWindow},
+		{Timestamp: reference, Count: 1},
+	}
+
+	result := NewLatencyResponse(requestCounts, reference, 10*time.Second, time.Second)
+	if result.Responses != 100 || result.MaxMs != 100 {
+		t.Fatalf("Expected 100 responses of at most 100ms, got '%+v'\n", result)
+	}
+	for _, percentile := range []struct{ expected, result float64 }{{50, result.P50Ms}, {95, result.P95Ms}, {99, result.P99Ms}} {
+		if math.Abs(percentile.result-percentile.expected)/percentile.expected > persistence.SketchAccuracy {
+			t.Fatalf("Expected percentile '%v' to be within %v of '%vms', got '%+v'\n", percentile.expected, persistence.SketchAccuracy, percentile.expected, result)
+		}
+	}
+
+	if empty := NewLatencyResponse(nil, reference, 10*time.Second, time.Second); empty != (LatencyResponse{}) {
+		t.Fatalf("Expected no latencies without request counts, got '%+v'\n", empty)
+	}
+}
+
+/* Latencies are only sketched, and percentiles served, if enabled.
+ */
+func TestLatency(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		srv := NewServer(Environment{
+			ListenAddress:        ":5000",
+			PersistenceFile:      "NOT_SET",
+			Precision:            time.Second,
+			PersistenceTimeFrame: time.Minute,
+			LatencyPercentiles:   enabled,
+		})
+		srv.Logger.SetOutput(ioutil.Discard)
+		srv.Routes()
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/latency", nil))
+		srv.Stop()
+		if !enabled {
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("Expected percentiles not to be found without sketches, got '%v'\n", w.Code)
+			}
+			if srv.Communication.state.Present.Latencies != nil || srv.Communication.state.Present.Requests != 1 {
+				t.Fatalf("Expected the response to be recorded without a sketch, got '%+v'\n", srv.Communication.state.Present.Aggregates)
+			}
+			continue
+		}
+		var latency LatencyResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &latency); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Error decoding the percentiles '%v' with status '%v': %v\n", w.Body.String(), w.Code, err)
+		}
+		if latency.Responses != 1 || srv.Communication.state.Present.Latencies == nil {
+			t.Fatalf("Expected the latency of '1' response to be sketched, got '%+v'\n", latency)
+		}
+	}
+}
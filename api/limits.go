@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	limitTokenBucket = "token-bucket"
+	limitGCRA        = "gcra"
+)
+
+/* Rate limit of a route, applied to every client on its own. See ClientFunc.
+- Algorithm: limitTokenBucket or limitGCRA. Both let the same requests through, see persistence::Limiter.
+- Interval: time between two requests on average, e.g. 100ms for 10 requests per second.
+- Burst: number of requests that may come at once, on top of the average.
+Unlike the moving window, which counts requests, limits turn requests away once they are exceeded.
+*/
+type Limit struct {
+	Algorithm string
+	Interval  time.Duration
+	Burst     int
+}
+
+/* Limits the requests to the route of the given pattern, if a limit is configured for it. Requests beyond the limit
+are rejected with a 429 error carrying the 'Retry-After' header, before reaching the handler. Every request counts for
+one, whatever its weight.
+Requests are limited per client, as told by the client function. Requests whose client cannot be told share a limit.
+*/
+func (s *server) limited(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	if _, limited := s.Communication.limits[pattern]; !limited {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		request := limitRequest{route: pattern, client: s.client(r), timestamp: requestTimeFromContext(r.Context())}
+		allowed, retryAfter, err := s.Communication.limit(r.Context(), request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, r, errTooManyRequests.withMessage("Request limit of '%v' exceeded. Retry in %v", pattern, retryAfter.Round(time.Millisecond)))
+			return
+		}
+		next(w, r)
+	}
+}
+
+/* Parses the limits of routes as given on the command line: a comma separated list of
+'<route>=<algorithm>:<requests>/<period>:<burst>', such as '/=token-bucket:10/s:20,/hits=gcra:100/1m:10'.
+- route: the pattern the route is registered with, such as '/' or '/hits'.
+- algorithm: 'token-bucket' or 'gcra'.
+- requests/period: average rate. The period is a duration, its amount may be left out: 's' stands for '1s'.
+- burst: number of requests that may come at once. Must be positive.
+*/
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	if spec == "" {
+		return limits, nil
+	}
+	for _, routeSpec := range strings.Split(spec, ",") {
+		route, limitSpec, _ := strings.Cut(routeSpec, "=")
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid limit '%v': expected '<route>=<algorithm>:<requests>/<period>:<burst>' with a route starting with '/'", routeSpec)
+		}
+		if _, duplicate := limits[route]; duplicate {
+			return nil, fmt.Errorf("invalid limit '%v': route '%v' is limited more than once", routeSpec, route)
+		}
+		limit, err := parseLimit(limitSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit '%v': %v", routeSpec, err)
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+func parseLimit(spec string) (Limit, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return Limit{}, fmt.Errorf("expected '<algorithm>:<requests>/<period>:<burst>'")
+	}
+	algorithm, rate, burstSpec := parts[0], parts[1], parts[2]
+	if algorithm != limitTokenBucket && algorithm != limitGCRA {
+		return Limit{}, fmt.Errorf("unknown algorithm '%v': expected '%v' or '%v'", algorithm, limitTokenBucket, limitGCRA)
+	}
+
+	requestsSpec, periodSpec, _ := strings.Cut(rate, "/")
+	requests, err := strconv.Atoi(requestsSpec)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("the number of requests must be a positive integer, got '%v'", requestsSpec)
+	}
+	period, err := time.ParseDuration(periodSpec)
+	if err != nil {
+		period, err = time.ParseDuration("1" + periodSpec)
+	}
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("the period must be a positive duration, got '%v'", periodSpec)
+	}
+	burst, err := strconv.Atoi(burstSpec)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("the burst must be a positive integer, got '%v'", burstSpec)
+	}
+
+	interval := period / time.Duration(requests)
+	if interval <= 0 {
+		return Limit{}, fmt.Errorf("the rate of '%v' is too high", rate)
+	}
+	return Limit{Algorithm: algorithm, Interval: interval, Burst: burst}, nil
+}
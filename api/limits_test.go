@@ -0,0 +1,95 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected map[string]Limit
+		fails    bool
+	}{
+		{spec: "", expected: map[string]Limit{}},
+		{spec: "/=token-bucket:10/s:20", expected: map[string]Limit{"/": {Algorithm: limitTokenBucket, Interval: 100 * time.Millisecond, Burst: 20}}},
+		{spec: "/=gcra:100/1m:10,/hits=token-bucket:1/2s:1", expected: map[string]Limit{
+			"/":     {Algorithm: limitGCRA, Interval: 600 * time.Millisecond, Burst: 10},
+			"/hits": {Algorithm: limitTokenBucket, Interval: 2 * time.Second, Burst: 1},
+		}},
+		{spec: "/=leaky:10/s:20", fails: true},
+		{spec: "/=gcra:10/s", fails: true},
+		{spec: "/=gcra:0/s:1", fails: true},
+		{spec: "/=gcra:10/fortnight:1", fails: true},
+		{spec: "/=gcra:10/s:0", fails: true},
+		{spec: "hits=gcra:10/s:1", fails: true},
+		{spec: "/=gcra:10/s:1,/=gcra:1/s:1", fails: true},
+		{spec: "/=gcra:10/1ns:1", fails: true},
+	}
+	for _, test := range tests {
+		limits, err := ParseLimits(test.spec)
+		if (err != nil) != test.fails {
+			t.Fatalf("Spec '%v': expected failure to be '%v', got error '%v'\n", test.spec, test.fails, err)
+		}
+		if !test.fails && !reflect.DeepEqual(limits, test.expected) {
+			t.Fatalf("Spec '%v': expected '%+v', got '%+v'\n", test.spec, test.expected, limits)
+		}
+	}
+}
+
+func TestLimited(t *testing.T) {
+	for _, algorithm := range []string{limitTokenBucket, limitGCRA} {
+		srv := NewServer(Environment{
+			ListenAddress:        ":5000",
+			PersistenceFile:      "NOT_SET",
+			Precision:            time.Second,
+			PersistenceTimeFrame: time.Minute,
+			Limits:               map[string]Limit{"/": {Algorithm: algorithm, Interval: time.Hour, Burst: 2}},
+		})
+		srv.Logger.SetOutput(ioutil.Discard)
+		srv.Routes()
+
+		requests := []struct {
+			address        string
+			expectedStatus int
+		}{
+			{address: "10.0.0.1:1000", expectedStatus: http.StatusOK},
+			{address: "10.0.0.1:1000", expectedStatus: http.StatusOK},
+			{address: "10.0.0.1:1000", expectedStatus: http.StatusTooManyRequests},
+			{address: "10.0.0.2:1000", expectedStatus: http.StatusOK},
+		}
+		for i, request := range requests {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = request.address
+			srv.Handler.ServeHTTP(w, r)
+			if w.Code != request.expectedStatus {
+				t.Fatalf("Algorithm '%v', request '%v': expected status '%v', got '%v': %v\n", algorithm, i, request.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Fatalf("Algorithm '%v', request '%v': expected a 'Retry-After' header\n", algorithm, i)
+			}
+		}
+
+		// routes without a limit are not limited
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Algorithm '%v': expected '/stats' not to be limited, got '%v'\n", algorithm, w.Code)
+			}
+		}
+
+		srv.Stop()
+		if count := srv.Communication.state.Present.TotalRequestsWithinTimeframe; count != 3 {
+			t.Fatalf("Algorithm '%v': expected rejected requests not to be counted, got a count of '%v'\n", algorithm, count)
+		}
+		if limiters := len(srv.Communication.state.Limiters); limiters != 2 {
+			t.Fatalf("Algorithm '%v': expected a limiter per client, got '%v'\n", algorithm, limiters)
+		}
+	}
+}
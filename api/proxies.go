@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/* Networks of the proxies in front of the server, such as load balancers, whose forwarding headers are trusted to tell
+the address of the client. Headers of any other peer are ignored, since clients can set them to anything.
+*/
+type TrustedProxies []*net.IPNet
+
+/* Parses a comma separated list of networks in CIDR notation, such as '10.0.0.0/8,fd00::/8'. Single addresses stand
+for themselves.
+*/
+func ParseTrustedProxies(spec string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	if spec == "" {
+		return proxies, nil
+	}
+	for _, network := range strings.Split(spec, ",") {
+		network = strings.TrimSpace(network)
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%v': expected an address or a network in CIDR notation", network)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, parsed, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%v': %v", network, err)
+		}
+		proxies = append(proxies, parsed)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Address of the client a request came from: its remote address, unless it came from a trusted proxy. Requests from
+trusted proxies are attributed to the address they were
+forwarded for, as told by the first of these headers the request holds:
+- Forwarded (RFC 7239): the 'for' parameter of every hop, e.g. 'for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"'.
+- X-Forwarded-For: the addresses of every hop, e.g. '192.0.2.60, 10.0.0.2'.
+- X-Real-IP: the address of the client alone.
+Hops are appended by every proxy, so they are walked from the last one, nearest to the server, for as long as they are
+trusted proxies: the first hop that is not one is the client, since anything before it may have been set by the client
+itself. If every hop is a trusted proxy, the first one is the client. A hop whose address is unknown or obfuscated
+stops the walk at the hop after it. Forwarded addresses are returned without a port.
+*/
+func (p TrustedProxies) clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if remoteIP := net.ParseIP(remote); remoteIP == nil || !p.trusts(remoteIP) {
+		return r.RemoteAddr
+	}
+
+	var hops []string
+	switch {
+	case len(r.Header.Values("Forwarded")) > 0:
+		hops = forwardedFor(strings.Join(r.Header.Values("Forwarded"), ","))
+	case len(r.Header.Values("X-Forwarded-For")) > 0:
+		hops = strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	case r.Header.Get("X-Real-IP") != "":
+		hops = []string{r.Header.Get("X-Real-IP")}
+	}
+
+	client := r.RemoteAddr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !p.trusts(ip) {
+			break
+		}
+	}
+	return client
+}
+
+/* Values of the 'for' parameter of every element of a Forwarded header, in order. Elements without one are left out.
+ */
+func forwardedFor(header string) []string {
+	var hops []string
+	for _, element := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(name, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+/* Address of a hop, which may come with a port and, for IPv6, within brackets: '192.0.2.60:4711' or
+'[2001:db8::1]:4711'. Nil for unknown or obfuscated hops, such as 'unknown' or '_hidden'.
+*/
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+/* Attributes requests from trusted proxies to the address of their client, so that logging and the counting of clients
+see the client rather than the proxy. The remote address of forwarded requests is replaced by the address of their
+client, without a port. Without trusted proxies, forwarding headers are ignored.
+*/
+func realIP(proxies TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client := proxies.clientIP(r); client != r.RemoteAddr {
+				r = r.WithContext(r.Context())
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
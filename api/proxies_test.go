@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var parseTrustedProxiesTests = []struct {
+	spec     string
+	valid    bool
+	networks []string
+}{
+	{spec: "", valid: true},
+	{spec: "10.0.0.0/8", valid: true, networks: []string{"10.0.0.0/8"}},
+	{spec: "10.0.0.0/8, fd00::/8", valid: true, networks: []string{"10.0.0.0/8", "fd00::/8"}},
+	{spec: "192.0.2.1,2001:db8::1", valid: true, networks: []string{"192.0.2.1/32", "2001:db8::1/128"}},
+	{spec: "10.0.0.0/33", valid: false},
+	{spec: "proxy.local", valid: false},
+	{spec: "10.0.0.0/8,", valid: false},
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for i, test := range parseTrustedProxiesTests {
+		proxies, err := ParseTrustedProxies(test.spec)
+		if (err == nil) != test.valid {
+			t.Fatalf("Test '%v': expected spec '%v' to be valid: %v, got error '%v'\n", i, test.spec, test.valid, err)
+		}
+		if !test.valid {
+			continue
+		}
+		if len(proxies) != len(test.networks) {
+			t.Fatalf("Test '%v': expected networks '%v', got '%v'\n", i, test.networks, proxies)
+		}
+		for j, network := range proxies {
+			if network.String() != test.networks[j] {
+				t.Fatalf("Test '%v': expected networks '%v', got '%v'\n", i, test.networks, proxies)
+			}
+		}
+	}
+}
+
+var clientIPTests = []struct {
+	remote   string
+	headers  map[string]string
+	expected string
+}{
+	// without forwarding headers, the remote address stands
+	{remote: "10.0.0.1:1234", expected: "10.0.0.1:1234"},
+	// the headers of untrusted peers are ignored
+	{remote: "192.0.2.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, expected: "192.0.2.1:1234"},
+	{remote: "192.0.2.1:1234", headers: map[string]string{"X-Real-IP": "198.51.100.1"}, expected: "192.0.2.1:1234"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, expected: "198.51.100.1"},
+	// hops before the first untrusted one may have been made up by the client
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"}, expected: "198.51.100.1"},
+	// if every hop is trusted, the first one is the client
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, expected: "10.0.0.3"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Real-IP": "198.51.100.1"}, expected: "198.51.100.1"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for=10.0.0.2`}, expected: "198.51.100.1"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": `For="[2001:db8::1]:4711";by=10.0.0.2`}, expected: "2001:db8::1"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": `for=198.51.100.1:4711`}, expected: "198.51.100.1"},
+	// Forwarded takes precedence over X-Forwarded-For, which takes precedence over X-Real-IP
+	{remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2", "X-Real-IP": "198.51.100.3"}, expected: "198.51.100.1"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.2", "X-Real-IP": "198.51.100.3"}, expected: "198.51.100.2"},
+	// an unknown or obfuscated hop stops the walk at the hop after it
+	{remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": "for=198.51.100.1, for=_hidden, for=10.0.0.2"}, expected: "10.0.0.2"},
+	{remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "unknown"}, expected: "10.0.0.1:1234"},
+	{remote: "[fd00::1]:1234", headers: map[string]string{"X-Forwarded-For": "2001:db8::1"}, expected: "2001:db8::1"},
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8,fd00::/8")
+	if err != nil {
+		t.Fatalf("Error parsing trusted proxies: %v\n", err)
+	}
+	for i, test := range clientIPTests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		if client := proxies.clientIP(r); client != test.expected {
+			t.Fatalf("Test '%v': expected client '%v', got '%v'\n", i, test.expected, client)
+		}
+	}
+}
+
+func TestIndexForwardedClients(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Error parsing trusted proxies: %v\n", err)
+	}
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Millisecond,
+		PersistenceTimeFrame: time.Minute,
+		TrustedProxies:       proxies,
+		UniqueClients:        true,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	// requests through the same proxy are told apart by the client they were forwarded for
+	requests := []struct {
+		remote    string
+		forwarded string
+	}{
+		{remote: "10.0.0.1:1234", forwarded: "198.51.100.1"},
+		{remote: "10.0.0.1:1234", forwarded: "198.51.100.2"},
+		{remote: "10.0.0.2:1234", forwarded: "198.51.100.1"},
+		// an untrusted peer is counted as itself
+		{remote: "192.0.2.1:1234", forwarded: "198.51.100.3"},
+	}
+	var response Response
+	for _, request := range requests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = request.remote
+		r.Header.Set("X-Forwarded-For", request.forwarded)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Error decoding response '%v': %v\n", w.Body.String(), err)
+		}
+	}
+	if response.UniqueClients == nil || *response.UniqueClients != 3 {
+		t.Fatalf("Expected '3' unique clients, got '%+v'\n", response)
+	}
+}
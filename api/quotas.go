@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"movingwindow/persistence"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const quotaPath = "/quota/"
+
+/* Quota of keyed counters, over a calendar window rather than the moving window:
+- Period: persistence.CalendarDay or persistence.CalendarMonth.
+- Limit: requests allowed within a calendar window.
+- Location: time zone the calendar windows start at midnight of. Defaults to UTC.
+Quotas are reported, not enforced: requests beyond the limit are still counted.
+*/
+type Quota struct {
+	Period   string
+	Limit    int
+	Location *time.Location
+}
+
+/* Quota of the given key, if any. Quotas are configured by key pattern: either the key itself or a prefix followed by
+'*', such as 'tenant:*'. A key matching several patterns gets the quota of the key itself, or else of the longest prefix.
+*/
+func quotaFor(quotas map[string]Quota, key string) (Quota, bool) {
+	if quota, found := quotas[key]; found {
+		return quota, true
+	}
+	var match string
+	var quota Quota
+	found := false
+	for pattern, patternQuota := range quotas {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix == pattern || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !found || len(prefix) > len(match) {
+			match, quota, found = prefix, patternQuota, true
+		}
+	}
+	return quota, found
+}
+
+/* Usage of the quota of a key as of the time of the request:
+- Used, Remaining: requests counted within the current calendar window, and left until the limit is reached.
+- Exceeded: whether more requests than the limit have been counted.
+- ResetsAt: end of the current calendar window, in the time zone of the quota.
+*/
+type QuotaResponse struct {
+	Key       string    `json:"key"`
+	Period    string    `json:"period"`
+	TimeZone  string    `json:"timeZone"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Exceeded  bool      `json:"exceeded"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+func NewQuotaResponse(key string, quota Quota, usage persistence.QuotaUsage, reference time.Time) QuotaResponse {
+	used, resetsAt := usage.At(reference, quota.Period, quota.Location)
+	response := QuotaResponse{
+		Key:      key,
+		Period:   quota.Period,
+		TimeZone: quota.Location.String(),
+		Limit:    quota.Limit,
+		Used:     used,
+		Exceeded: used > quota.Limit,
+		ResetsAt: resetsAt,
+	}
+	if used < quota.Limit {
+		response.Remaining = quota.Limit - used
+	}
+	return response
+}
+
+/* Serves the usage of the quota of the key given by the path, as in '/quota/tenant:1'. Keys without a quota are not
+found. Requests to it are not counted.
+*/
+func (s *server) Quota(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		key := strings.TrimPrefix(r.URL.Path, quotaPath)
+		quota, found := quotaFor(com.quotas, key)
+		if key == "" || !found {
+			writeError(w, r, errNotFound.withMessage("There is no quota for key '%v'", key))
+			return
+		}
+
+		usage, err := com.quotaUsage(r.Context(), key)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, NewQuotaResponse(key, quota, usage, time.Now()))
+	})
+}
+
+/* Parses the quotas of keys as given on the command line: a comma separated list of
+'<key pattern>=<period>:<limit>[:<time zone>]', such as 'tenant:*=daily:10000,export:*=monthly:1000000:Europe/Berlin'.
+- key pattern: a key, or a prefix followed by '*'. '*' alone applies to all keys.
+- period: 'daily' or 'monthly'.
+- limit: requests allowed per calendar window. Must be positive.
+- time zone: IANA name of the time zone the calendar windows follow. Defaults to UTC.
+*/
+func ParseQuotas(spec string) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	if spec == "" {
+		return quotas, nil
+	}
+	for _, keySpec := range strings.Split(spec, ",") {
+		pattern, quotaSpec, _ := strings.Cut(keySpec, "=")
+		if pattern == "" {
+			return nil, fmt.Errorf("invalid quota '%v': expected '<key pattern>=<period>:<limit>[:<time zone>]'", keySpec)
+		}
+		if _, duplicate := quotas[pattern]; duplicate {
+			return nil, fmt.Errorf("invalid quota '%v': key pattern '%v' has more than one quota", keySpec, pattern)
+		}
+		quota, err := parseQuota(quotaSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota '%v': %v", keySpec, err)
+		}
+		quotas[pattern] = quota
+	}
+	return quotas, nil
+}
+
+func parseQuota(spec string) (Quota, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return Quota{}, fmt.Errorf("expected '<period>:<limit>[:<time zone>]'")
+	}
+	period := parts[0]
+	if period != persistence.CalendarDay && period != persistence.CalendarMonth {
+		return Quota{}, fmt.Errorf("unknown period '%v': expected '%v' or '%v'", period, persistence.CalendarDay, persistence.CalendarMonth)
+	}
+	limit, err := strconv.Atoi(parts[1])
+	if err != nil || limit <= 0 {
+		return Quota{}, fmt.Errorf("the limit must be a positive integer, got '%v'", parts[1])
+	}
+	location := time.UTC
+	if len(parts) == 3 {
+		location, err = time.LoadLocation(parts[2])
+		if err != nil {
+			return Quota{}, fmt.Errorf("unknown time zone '%v': %v", parts[2], err)
+		}
+	}
+	return Quota{Period: period, Limit: limit, Location: location}, nil
+}
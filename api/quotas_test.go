@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected map[string]Quota
+		fails    bool
+	}{
+		{spec: "", expected: map[string]Quota{}},
+		{spec: "tenant:*=daily:10000", expected: map[string]Quota{"tenant:*": {Period: "daily", Limit: 10000, Location: time.UTC}}},
+		{spec: "*=monthly:5:UTC,export=daily:1", expected: map[string]Quota{
+			"*":      {Period: "monthly", Limit: 5, Location: time.UTC},
+			"export": {Period: "daily", Limit: 1, Location: time.UTC},
+		}},
+		{spec: "tenant:*=weekly:10", fails: true},
+		{spec: "tenant:*=daily:0", fails: true},
+		{spec: "tenant:*=daily", fails: true},
+		{spec: "tenant:*=daily:10:Nowhere/Atlantis", fails: true},
+		{spec: "=daily:10", fails: true},
+		{spec: "a=daily:10,a=monthly:10", fails: true},
+	}
+	for _, test := range tests {
+		quotas, err := ParseQuotas(test.spec)
+		if (err != nil) != test.fails {
+			t.Fatalf("Spec '%v': expected failure to be '%v', got error '%v'\n", test.spec, test.fails, err)
+		}
+		if !test.fails && !reflect.DeepEqual(quotas, test.expected) {
+			t.Fatalf("Spec '%v': expected '%+v', got '%+v'\n", test.spec, test.expected, quotas)
+		}
+	}
+}
+
+func TestQuotaFor(t *testing.T) {
+	quotas := map[string]Quota{
+		"*":            {Limit: 1},
+		"tenant:*":     {Limit: 2},
+		"tenant:acme*": {Limit: 3},
+		"tenant:acme":  {Limit: 4},
+	}
+	tests := []struct {
+		key      string
+		expected int
+	}{
+		{key: "export", expected: 1},
+		{key: "tenant:1", expected: 2},
+		{key: "tenant:acme-eu", expected: 3},
+		{key: "tenant:acme", expected: 4},
+	}
+	for _, test := range tests {
+		if quota, found := quotaFor(quotas, test.key); !found || quota.Limit != test.expected {
+			t.Fatalf("Key '%v': expected the quota with limit '%v', got '%+v'\n", test.key, test.expected, quota)
+		}
+	}
+	if _, found := quotaFor(map[string]Quota{"tenant:*": {}}, "export"); found {
+		t.Fatal("Expected no quota for a key without a matching pattern\n")
+	}
+}
+
+func TestQuota(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Quotas:               map[string]Quota{"tenant:*": {Period: "daily", Limit: 10, Location: time.UTC}},
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	srv.Initialize()
+	defer srv.Stop()
+
+	now := time.Now()
+	if _, err := srv.Communication.exchangeKeyed(context.Background(), keyHit{key: "tenant:1", timestamp: now, n: 7}, keyHit{key: "tenant:1", timestamp: now, n: 5}, keyHit{key: "export", timestamp: now, n: 1}); err != nil {
+		t.Fatalf("Error hitting keys: %v\n", err)
+	}
+
+	tests := []struct {
+		key            string
+		expectedStatus int
+		expected       QuotaResponse
+	}{
+		{key: "tenant:1", expectedStatus: http.StatusOK, expected: QuotaResponse{Key: "tenant:1", Period: "daily", TimeZone: "UTC", Limit: 10, Used: 12, Exceeded: true}},
+		{key: "tenant:2", expectedStatus: http.StatusOK, expected: QuotaResponse{Key: "tenant:2", Period: "daily", TimeZone: "UTC", Limit: 10, Remaining: 10}},
+		{key: "export", expectedStatus: http.StatusNotFound},
+		{key: "", expectedStatus: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/quota/"+test.key, nil))
+		if w.Code != test.expectedStatus {
+			t.Fatalf("Key '%v': expected status '%v', got '%v': %v\n", test.key, test.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var response QuotaResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Key '%v': error decoding '%v': %v\n", test.key, w.Body.String(), err)
+		}
+		// the reset time depends on the day the test runs
+		expectedReset := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if !response.ResetsAt.Equal(expectedReset) {
+			t.Fatalf("Key '%v': expected a reset at '%v', got '%v'\n", test.key, expectedReset, response.ResetsAt)
+		}
+		response.ResetsAt = time.Time{}
+		if !reflect.DeepEqual(response, test.expected) {
+			t.Fatalf("Key '%v': expected '%+v', got '%+v'\n", test.key, test.expected, response)
+		}
+	}
+}
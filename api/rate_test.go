@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIndexRate(t *testing.T) {
+	for _, halfLife := range []time.Duration{0, time.Minute} {
+		srv := NewServer(Environment{
+			ListenAddress:        ":5000",
+			PersistenceFile:      "NOT_SET",
+			Precision:            time.Second,
+			PersistenceTimeFrame: time.Minute,
+			HalfLife:             halfLife,
+		})
+		srv.Logger.SetOutput(ioutil.Discard)
+		srv.Routes()
+
+		var response map[string]interface{}
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Error decoding response '%v': %v\n", w.Body.String(), err)
+			}
+		}
+		srv.Stop()
+
+		rate, reported := response["rate"].(float64)
+		if halfLife == 0 && reported {
+			t.Fatalf("Expected no rate to be reported without a half-life, got '%v'\n", response)
+		}
+		// three requests at the same time count for 3 * ln(2) / 60s
+		if halfLife > 0 && (!reported || rate < 0.034 || rate > 0.035) {
+			t.Fatalf("Expected a rate of about 0.0347 requests per second, got '%v'\n", response)
+		}
+	}
+}
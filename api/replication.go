@@ -0,0 +1,100 @@
+package api
+
+import (
+	"movingwindow/cluster"
+	"movingwindow/persistence"
+	"net/http"
+	"sort"
+	"time"
+)
+
+/* Serves the view of the cluster of this replica for its peers to pull: the buckets of this instance, taken from the
+communication processor, along with those replicated from other instances. Requests to it are not counted.
+*/
+func (s *server) Replication(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		requestCounts, err := com.snapshot(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		own := make([]cluster.Bucket, 0, len(requestCounts))
+		for _, requestCount := range requestCounts {
+			own = append(own, cluster.Bucket{Timestamp: requestCount.Timestamp, Count: requestCount.Count})
+		}
+		state := cluster.ReplicationState{Node: s.replicator.NodeID, Nodes: s.replicator.Counter().State()}
+		state.Nodes[s.replicator.NodeID] = own
+
+		writeJSON(w, r, http.StatusOK, state)
+	})
+}
+
+/* Contribution of a member to the request count of the cluster within the persistence time frame. Members that are
+not part of the membership - static peers, or departed members whose request counts have not aged out yet - are
+reported with an empty state.
+*/
+type ClusterMember struct {
+	ID           string              `json:"id"`
+	State        cluster.MemberState `json:"state,omitempty"`
+	Address      string              `json:"address,omitempty"`
+	URL          string              `json:"url,omitempty"`
+	Incarnation  uint64              `json:"incarnation"`
+	RequestCount int                 `json:"requestCount"`
+}
+
+type ClusterResponse struct {
+	Node         string          `json:"node"`
+	RequestCount int             `json:"requestCount"`
+	Members      []ClusterMember `json:"members"`
+}
+
+/* Reports the members of the cluster known to this instance along with their contribution to the request count.
+Requests to it are not counted.
+*/
+func (s *server) Cluster(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		requestCounts, err := com.snapshot(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		reference := persistence.RequestCount{Timestamp: time.Now().Truncate(s.precision)}
+		totals := s.replicator.Counter().Totals(reference.Timestamp, s.persistenceTimeFrame, s.precision)
+		totals[s.replicator.NodeID] = 0
+		for _, requestCount := range requestCounts {
+			if within, _ := requestCount.WithinDurationBefore(s.persistenceTimeFrame, s.precision, reference); within {
+				totals[s.replicator.NodeID] += requestCount.Count
+			}
+		}
+
+		response := ClusterResponse{Node: s.replicator.NodeID, Members: make([]ClusterMember, 0, len(totals))}
+		if membership := s.replicator.Membership(); membership != nil {
+			for _, member := range membership.Members() {
+				response.Members = append(response.Members, ClusterMember{
+					ID:           member.ID,
+					State:        member.State,
+					Address:      member.Address,
+					URL:          member.URL,
+					Incarnation:  member.Incarnation,
+					RequestCount: totals[member.ID],
+				})
+				delete(totals, member.ID)
+			}
+		}
+		for id, total := range totals {
+			response.Members = append(response.Members, ClusterMember{ID: id, RequestCount: total})
+		}
+		sort.Slice(response.Members, func(i, j int) bool { return response.Members[i].ID < response.Members[j].ID })
+
+		for _, member := range response.Members {
+			response.RequestCount += member.RequestCount
+		}
+		writeJSON(w, r, http.StatusOK, response)
+	})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"movingwindow/resp"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Lets Redis clients use the moving window counters as a sliding-window store. Only a subset of the protocol is
+understood:
+- INCR key / MW.HIT key: counts a request for the key and replies with the request count within the persistence time
+  frame, as an integer.
+- GET key: replies with the request count within the persistence time frame as a bulk string, as Redis does for
+  counters. Keys without requests within the time frame are reported as '0' rather than as null.
+- MW.COUNT key: same as GET, as an integer.
+- PING [message], COMMAND, QUIT: so that clients can connect, introspect and disconnect.
+- AUTH [name] token: authenticates the connection with an API key or a signed token, if credentials are configured.
+  The name, if given, must be the one of the credential. Until then, keyed commands are rejected: INCR and MW.HIT need
+  the 'hit' scope, GET and MW.COUNT the 'read' scope. See server::authorize.
+Keyed counters are kept by the communication processor along with the counter of the server, and persisted with it.
+They are local to this instance: they are neither replicated to, nor merged from, peers.
+Accepts connections on the address until the server is stopped, in which case nil is returned. See server::Stop.
+*/
+func (s *server) ListenAndServeRESP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.ServeRESP(listener)
+}
+
+func (s *server) ServeRESP(listener net.Listener) error {
+	s.Logger.Printf("Serving RESP at '%v'\n", listener.Addr())
+	return s.resp.serve(listener, s.serveRESPConnection)
+}
+
+func (s *server) serveRESPConnection(conn net.Conn) {
+	reader := resp.NewReader(conn)
+	writer := resp.NewWriter(conn)
+	// token the connection authenticated with, if any
+	var token string
+	for {
+		command, err := reader.ReadCommand()
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				writer.WriteError("ERR " + err.Error())
+				writer.Flush()
+			} else if err != io.EOF && !isTimeout(err) {
+				s.Logger.Printf("Could not read RESP command from '%v': %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		quit := s.handleRESPCommand(writer, command, &token)
+		if err := writer.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+/* Writes the reply to the command. Returns true if the connection is to be closed. The token the connection
+authenticated with is kept up to date by AUTH.
+*/
+func (s *server) handleRESPCommand(writer *resp.Writer, command []string, token *string) bool {
+	name := strings.ToUpper(command[0])
+	arguments := command[1:]
+	switch name {
+	case "PING":
+		if len(arguments) > 1 {
+			writer.WriteError(wrongArity(name))
+		} else if len(arguments) == 1 {
+			writer.WriteBulkString(arguments[0])
+		} else {
+			writer.WriteSimpleString("PONG")
+		}
+	case "COMMAND":
+		writer.WriteArray(0)
+	case "QUIT":
+		writer.WriteSimpleString("OK")
+		return true
+	case "AUTH":
+		if len(arguments) != 1 && len(arguments) != 2 {
+			writer.WriteError(wrongArity(name))
+			return false
+		}
+		if s.credentials == nil {
+			writer.WriteError("ERR AUTH called without any credentials configured")
+			return false
+		}
+		presented := arguments[len(arguments)-1]
+		credential, valid := s.credentials.authenticate(presented, time.Now())
+		if !valid || (len(arguments) == 2 && arguments[0] != credential.Name) {
+			writer.WriteError(s.respError(errUnauthorized))
+			return false
+		}
+		*token = presented
+		writer.WriteSimpleString("OK")
+	case "INCR", "MW.HIT", "GET", "MW.COUNT":
+		if len(arguments) != 1 {
+			writer.WriteError(wrongArity(name))
+			return false
+		}
+		n, scope := 0, scopeRead
+		if name == "INCR" || name == "MW.HIT" {
+			n, scope = 1, scopeHit
+		}
+		if err := s.authorize(*token, scope); err != nil {
+			writer.WriteError(s.respError(err))
+			return false
+		}
+		count, err := s.countKey(arguments[0], n)
+		if err != nil {
+			writer.WriteError(s.respError(err))
+		} else if name == "GET" {
+			writer.WriteBulkString(strconv.Itoa(count))
+		} else {
+			writer.WriteInteger(int64(count))
+		}
+	default:
+		writer.WriteError("ERR unknown command '" + command[0] + "'")
+	}
+	return false
+}
+
+/* Unless the server was initialized eagerly, the first keyed request initializes it, as for the index handler.
+ */
+func (s *server) countKey(key string, n int) (int, error) {
+	counts, err := s.countKeys(keyHit{key: key, n: n})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+/* All hits are stamped with the current time. See communication::exchangeKeyed.
+ */
+func (s *server) countKeys(hits ...keyHit) ([]int, error) {
+	s.Initialize()
+	timestamp := time.Now().Truncate(s.precision)
+	for i := range hits {
+		hits[i].timestamp = timestamp
+	}
+	counts, err := s.Communication.exchangeKeyed(context.Background(), hits...)
+	if err != nil {
+		s.Logger.Printf("Request for '%v' keys could not be served: %v. Queue depth: '%v'\n", len(hits), err, s.Communication.QueueDepth())
+	}
+	return counts, err
+}
+
+func wrongArity(name string) string {
+	return "ERR wrong number of arguments for '" + strings.ToLower(name) + "' command"
+}
+
+/* Errors of the API taxonomy are reported with their code in upper case, e.g. 'SATURATED Too many requests...', so
+that clients can tell them apart the same way as over HTTP. As for writeError, other errors are logged, and the client
+is only told that the command could not be processed.
+*/
+func (s *server) respError(err error) string {
+	var known apiError
+	if !errors.As(err, &known) {
+		s.ErrorLog.Printf("RESP command failed: %v\n", err)
+		known = errInternal
+	}
+	return strings.ToUpper(known.code) + " " + known.message
+}
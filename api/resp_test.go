@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type respTest struct {
+	command  string
+	expected string
+}
+
+var respTestList = []respTest{
+	{command: "PING\r\n", expected: "+PONG\r\n"},
+	{command: "*2\r\n$4\r\nPING\r\n$2\r\nhi\r\n", expected: "$2\r\nhi\r\n"},
+	{command: "*2\r\n$4\r\nINCR\r\n$1\r\na\r\n", expected: ":1\r\n"},
+	{command: "*2\r\n$6\r\nMW.HIT\r\n$1\r\na\r\n", expected: ":2\r\n"},
+	{command: "incr a\r\n", expected: ":3\r\n"},
+	{command: "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", expected: "$1\r\n3\r\n"},
+	{command: "*2\r\n$8\r\nMW.COUNT\r\n$1\r\na\r\n", expected: ":3\r\n"},
+	{command: "MW.COUNT unknown\r\n", expected: ":0\r\n"},
+	{command: "GET\r\n", expected: "-ERR wrong number of arguments for 'get' command\r\n"},
+	{command: "SET a 1\r\n", expected: "-ERR unknown command 'SET'\r\n"},
+	{command: "COMMAND DOCS\r\n", expected: "*0\r\n"},
+	{command: "AUTH key\r\n", expected: "-ERR AUTH called without any credentials configured\r\n"},
+	{command: "QUIT\r\n", expected: "+OK\r\n"},
+}
+
+func TestRESP(t *testing.T) {
+	srv := newTestServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on loopback: %v\n", err)
+	}
+	served := make(chan error)
+	go func() { served <- srv.ServeRESP(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the RESP listener: %v\n", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i, test := range respTestList {
+		if _, err := conn.Write([]byte(test.command)); err != nil {
+			t.Fatalf("Could not send command '%q' for test '%v': %v\n", test.command, i, err)
+		}
+		reply := make([]byte, len(test.expected))
+		if _, err := io.ReadFull(reader, reply); err != nil || string(reply) != test.expected {
+			t.Fatalf("Expected reply '%q' to command '%q' for test '%v', got '%q' (error: %v)\n", test.expected, test.command, i, reply, err)
+		}
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatalf("Expected the connection to be closed after QUIT\n")
+	}
+
+	// keyed requests are rejected once the processor is stopped, and the listener goes away
+	conn, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the RESP listener: %v\n", err)
+	}
+	defer conn.Close()
+	srv.Stop()
+	if err := <-served; err != nil {
+		t.Fatalf("Expected the listener to return without an error once stopped, got '%v'\n", err)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Fatalf("Expected the listener to be closed once the server is stopped\n")
+	}
+	if _, err := srv.countKey("a", 1); err != errShuttingDown {
+		t.Fatalf("Expected '%v' once the server is stopped, got '%v'\n", errShuttingDown, err)
+	}
+	if count := srv.Communication.state.Keys["a"].Present.Count; count != 3 {
+		t.Fatalf("Expected keyed counts to be kept in the state for persistence, got '%v'\n", count)
+	}
+}
+
+var respAuthTestList = []respTest{
+	{command: "PING\r\n", expected: "+PONG\r\n"},
+	{command: "INCR a\r\n", expected: "-UNAUTHORIZED The request lacks a valid API key or token\r\n"},
+	{command: "AUTH wrong-key\r\n", expected: "-UNAUTHORIZED The request lacks a valid API key or token\r\n"},
+	{command: "AUTH dashboard edge-key\r\n", expected: "-UNAUTHORIZED The request lacks a valid API key or token\r\n"},
+	{command: "AUTH edge edge-key\r\n", expected: "+OK\r\n"},
+	{command: "INCR a\r\n", expected: ":1\r\n"},
+	{command: "MW.COUNT a\r\n", expected: "-FORBIDDEN Credential 'edge' does not grant the 'read' scope\r\n"},
+	{command: "AUTH dashboard-key\r\n", expected: "+OK\r\n"},
+	{command: "GET a\r\n", expected: "$1\r\n1\r\n"},
+	{command: "INCR a\r\n", expected: "-FORBIDDEN Credential 'dashboard' does not grant the 'hit' scope\r\n"},
+}
+
+/* With credentials configured, connections must authenticate before counting, with a credential granting the scope.
+ */
+func TestRESPAuth(t *testing.T) {
+	credentials, err := ParseCredentials(strings.NewReader(testCredentials))
+	if err != nil {
+		t.Fatalf("Error parsing credentials: %v\n", err)
+	}
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Credentials:          credentials,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on loopback: %v\n", err)
+	}
+	go srv.ServeRESP(listener)
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the RESP listener: %v\n", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i, test := range respAuthTestList {
+		if _, err := conn.Write([]byte(test.command)); err != nil {
+			t.Fatalf("Could not send command '%q' for test '%v': %v\n", test.command, i, err)
+		}
+		reply := make([]byte, len(test.expected))
+		if _, err := io.ReadFull(reader, reply); err != nil || string(reply) != test.expected {
+			t.Fatalf("Expected reply '%q' to command '%q' for test '%v', got '%q' (error: %v)\n", test.expected, test.command, i, reply, err)
+		}
+	}
+}
+
+func TestRESPErrorLogsUnknownError(t *testing.T) {
+	var logged bytes.Buffer
+	srv := NewServer(Environment{ListenAddress: "127.0.0.1:0", PersistenceFile: "NOT_SET", Precision: time.Second, PersistenceTimeFrame: time.Minute})
+	srv.ErrorLog = log.New(&logged, "", 0)
+
+	if reply := srv.respError(errors.New("open /var/lib/state.bin: permission denied")); reply != "INTERNAL_ERROR "+errInternal.message {
+		t.Fatalf("Expected the generic internal error, got '%v'\n", reply)
+	}
+	if !strings.Contains(logged.String(), "state.bin") {
+		t.Fatalf("Expected the cause to be logged, got '%v'\n", logged.String())
+	}
+}
@@ -1,7 +1,32 @@
 package api
 
-/* All requests shall have the same handling.
- */
+import (
+	"movingwindow/cluster"
+	"net/http"
+)
+
+/* All requests shall have the same handling, except for those to the status endpoints and to the ingestion of batches
+of hits for keyed counters. These are not counted.
+Requests to the index are counted whatever their method. Other routes only accept the methods they serve, any other
+yields a 405 error. The responses to all requests to the index are counted by the class of their status code, errors
+included.
+Any route may be rate limited, see limited. Requests to the index beyond its limit are neither counted nor recorded,
+but their responses are counted by status class.
+If credentials are configured, every route but the health checks requires a scope, see authorized. Requests are
+authenticated before anything else, so that rejected requests to the index are not counted by status class either.
+*/
 func (s *server) Routes() {
-	s.router.HandleFunc("/", s.Index(s.Communication))
+	s.router.HandleFunc("/", s.authorized(scopeHit, s.classifying(s.Communication)(s.limited("/", s.recording(s.Communication)(s.Index(s.Communication))))))
+	s.router.HandleFunc("/hits", s.authorized(scopeHit, s.limited("/hits", allowMethods(s.Hits(s.Communication), http.MethodPost))))
+	s.router.HandleFunc("/stats", s.authorized(scopeRead, s.limited("/stats", allowMethods(s.Stats(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/latency", s.authorized(scopeRead, s.limited("/latency", allowMethods(s.Latency(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/statuses", s.authorized(scopeRead, s.limited("/statuses", allowMethods(s.Statuses(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/top", s.authorized(scopeRead, s.limited("/top", allowMethods(s.Top(s.Communication), http.MethodGet))))
+	s.router.HandleFunc(quotaPath, s.authorized(scopeRead, s.limited(quotaPath, allowMethods(s.Quota(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/alerts", s.authorized(scopeRead, s.limited("/alerts", allowMethods(s.Alerts(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/queue", s.authorized(scopeRead, s.limited("/queue", allowMethods(s.Queue(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/healthz", s.limited("/healthz", allowMethods(s.Healthz(), http.MethodGet, http.MethodHead)))
+	s.router.HandleFunc("/readyz", s.limited("/readyz", allowMethods(s.Readyz(), http.MethodGet, http.MethodHead)))
+	s.router.HandleFunc(cluster.ReplicationPath, s.authorized(scopeAdmin, s.limited(cluster.ReplicationPath, allowMethods(s.Replication(s.Communication), http.MethodGet))))
+	s.router.HandleFunc("/cluster", s.authorized(scopeAdmin, s.limited("/cluster", allowMethods(s.Cluster(s.Communication), http.MethodGet))))
 }
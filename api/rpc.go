@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"movingwindow/rpc"
+	"net"
+	"time"
+)
+
+/* Serves the binary protocol of the rpc package: Hit(key, n), Count(key), HitMany(hits) and Auth(token). Requests share the
+communication processor, and the keyed counters, with the RESP listener. See communication::exchangeKeyed.
+A HitMany request is applied as a single operation. Errors are reported with the codes of the API error taxonomy,
+plus 'bad_request' for requests with invalid arguments, e.g. an empty key.
+If credentials are configured, connections must authenticate with Auth first: Hit and HitMany need the 'hit' scope,
+Count the 'read' scope. See server::authorize.
+Accepts connections on the address until the server is stopped, in which case nil is returned. See server::Stop.
+*/
+func (s *server) ListenAndServeRPC(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.ServeRPC(listener)
+}
+
+func (s *server) ServeRPC(listener net.Listener) error {
+	s.Logger.Printf("Serving RPC at '%v'\n", listener.Addr())
+	return s.rpc.serve(listener, s.serveRPCConnection)
+}
+
+/* Requests are served one after the other. Responses are buffered while further requests are already waiting to be
+read, so that pipelining clients get their responses in as few writes as possible.
+*/
+func (s *server) serveRPCConnection(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	// token the connection authenticated with, if any
+	var token string
+	for {
+		request, err := rpc.ReadRequest(reader)
+		if err != nil {
+			if errors.Is(err, rpc.ErrProtocol) || (err != io.EOF && !isTimeout(err)) {
+				s.Logger.Printf("Could not read RPC request from '%v': %v\n", conn.RemoteAddr(), err)
+			}
+			writer.Flush()
+			return
+		}
+
+		if err := rpc.WriteResponse(writer, s.handleRPCRequest(request, &token)); err != nil {
+			return
+		}
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/* The token the connection authenticated with is kept up to date by Auth. Without credentials configured, Auth is
+accepted whatever the token, so that clients do not depend on the configuration of the server.
+*/
+func (s *server) handleRPCRequest(request rpc.Request, token *string) rpc.Response {
+	if request.Method == rpc.MethodAuth {
+		if s.credentials != nil {
+			if _, valid := s.credentials.authenticate(request.Token, time.Now()); !valid {
+				return s.rpcError(request.ID, errUnauthorized)
+			}
+		}
+		*token = request.Token
+		return rpc.Response{ID: request.ID}
+	}
+	scope := scopeHit
+	if request.Method == rpc.MethodCount {
+		scope = scopeRead
+	}
+	if err := s.authorize(*token, scope); err != nil {
+		return s.rpcError(request.ID, err)
+	}
+
+	hits := make([]keyHit, len(request.Hits))
+	for i, hit := range request.Hits {
+		if hit.Key == "" {
+			return s.rpcError(request.ID, errBadRequest)
+		}
+		hits[i] = keyHit{key: hit.Key, n: int(hit.N)}
+	}
+	if request.Method == rpc.MethodCount {
+		hits[0].n = 0
+	}
+
+	counts, err := s.countKeys(hits...)
+	if err != nil {
+		return s.rpcError(request.ID, err)
+	}
+	response := rpc.Response{ID: request.ID, Counts: make([]uint64, len(counts))}
+	for i, count := range counts {
+		response.Counts[i] = uint64(count)
+	}
+	return response
+}
+
+/* As for writeError, errors outside of the API taxonomy are logged, and the client is only told that the request could
+not be processed.
+*/
+func (s *server) rpcError(id uint32, err error) rpc.Response {
+	var known apiError
+	if !errors.As(err, &known) {
+		s.ErrorLog.Printf("RPC request '%v' failed: %v\n", id, err)
+		known = errInternal
+	}
+	return rpc.Response{ID: id, Code: known.code, Message: known.message}
+}
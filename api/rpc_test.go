@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"movingwindow/rpc"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRPC(t *testing.T) {
+	srv := newTestServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on loopback: %v\n", err)
+	}
+	served := make(chan error)
+	go func() { served <- srv.ServeRPC(listener) }()
+
+	client, err := rpc.Dial(listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Could not connect to the RPC listener: %v\n", err)
+	}
+	defer client.Close()
+
+	if count, err := client.Hit("a", 3); err != nil || count != 3 {
+		t.Fatalf("Expected count '3' after hitting a key, got '%v' (error: %v)\n", count, err)
+	}
+	counts, err := client.HitMany([]rpc.Hit{{Key: "a", N: 1}, {Key: "b", N: 2}, {Key: "a", N: 0}})
+	if expected := []uint64{4, 2, 4}; err != nil || !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("Expected counts '%v' after hitting several keys, got '%v' (error: %v)\n", expected, counts, err)
+	}
+	if count, err := client.Count("b"); err != nil || count != 2 {
+		t.Fatalf("Expected count '2' for a key, got '%v' (error: %v)\n", count, err)
+	}
+
+	var rpcErr rpc.Error
+	if _, err := client.HitMany([]rpc.Hit{{Key: "a", N: 1}, {Key: "", N: 1}}); !errors.As(err, &rpcErr) || rpcErr.Code != errBadRequest.code {
+		t.Fatalf("Expected a '%v' error for an empty key, got '%v'\n", errBadRequest.code, err)
+	}
+	// a batch is rejected as a whole
+	if count, err := client.Count("a"); err != nil || count != 4 {
+		t.Fatalf("Expected count '4' after a rejected batch, got '%v' (error: %v)\n", count, err)
+	}
+	// the counters are shared with the RESP listener
+	if count, err := srv.countKey("a", 1); err != nil || count != 5 {
+		t.Fatalf("Expected count '5' through RESP, got '%v' (error: %v)\n", count, err)
+	}
+
+	srv.Stop()
+	if err := <-served; err != nil {
+		t.Fatalf("Expected the listener to return without an error once stopped, got '%v'\n", err)
+	}
+	if _, err := client.Count("a"); err == nil {
+		t.Fatalf("Expected the connection to be closed once the server is stopped\n")
+	}
+}
+
+/* With credentials configured, connections must authenticate before counting, with a credential granting the scope.
+ */
+func TestRPCAuth(t *testing.T) {
+	credentials, err := ParseCredentials(strings.NewReader(testCredentials))
+	if err != nil {
+		t.Fatalf("Error parsing credentials: %v\n", err)
+	}
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Credentials:          credentials,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on loopback: %v\n", err)
+	}
+	go srv.ServeRPC(listener)
+	defer srv.Stop()
+
+	client, err := rpc.Dial(listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Could not connect to the RPC listener: %v\n", err)
+	}
+	defer client.Close()
+
+	var rpcErr rpc.Error
+	if _, err := client.Hit("a", 1); !errors.As(err, &rpcErr) || rpcErr.Code != errUnauthorized.code {
+		t.Fatalf("Expected a '%v' error before authenticating, got '%v'\n", errUnauthorized.code, err)
+	}
+	if err := client.Auth("wrong-key"); !errors.As(err, &rpcErr) || rpcErr.Code != errUnauthorized.code {
+		t.Fatalf("Expected a '%v' error for a wrong key, got '%v'\n", errUnauthorized.code, err)
+	}
+	if err := client.Auth("edge-key"); err != nil {
+		t.Fatalf("Expected to authenticate with a valid key, got '%v'\n", err)
+	}
+	if count, err := client.Hit("a", 2); err != nil || count != 2 {
+		t.Fatalf("Expected count '2' once authenticated, got '%v' (error: %v)\n", count, err)
+	}
+	if _, err := client.Count("a"); !errors.As(err, &rpcErr) || rpcErr.Code != errForbidden.code {
+		t.Fatalf("Expected a '%v' error for a scope the credential does not grant, got '%v'\n", errForbidden.code, err)
+	}
+	if err := client.Auth("dashboard-key"); err != nil {
+		t.Fatalf("Expected to authenticate with a valid key, got '%v'\n", err)
+	}
+	if count, err := client.Count("a"); err != nil || count != 2 {
+		t.Fatalf("Expected count '2' with the read scope, got '%v' (error: %v)\n", count, err)
+	}
+}
+
+func TestRPCErrorLogsUnknownError(t *testing.T) {
+	var logged bytes.Buffer
+	srv := NewServer(Environment{ListenAddress: "127.0.0.1:0", PersistenceFile: "NOT_SET", Precision: time.Second, PersistenceTimeFrame: time.Minute})
+	srv.ErrorLog = log.New(&logged, "", 0)
+
+	response := srv.rpcError(7, errors.New("open /var/lib/state.bin: permission denied"))
+	if response.ID != 7 || response.Code != errInternal.code || response.Message != errInternal.message {
+		t.Fatalf("Expected the generic internal error, got '%+v'\n", response)
+	}
+	if !strings.Contains(logged.String(), "state.bin") {
+		t.Fatalf("Expected the cause to be logged, got '%v'\n", logged.String())
+	}
+}
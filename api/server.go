@@ -2,24 +2,24 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"movingwindow/cluster"
 	"movingwindow/persistence"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type key int
 
 const (
-	requestIDKey key = 0
+	requestIDKey    key = 0
+	traceContextKey key = 1
+	requestTimeKey  key = 2
 )
 
-func nextRequestID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
-}
-
 /* Wrapper for all information required in the handler.
  */
 type server struct {
@@ -29,6 +29,20 @@ type server struct {
 	persistenceTimeFrame time.Duration
 	precision            time.Duration
 	persistenceFile      string
+	weight               WeightFunc
+	client               ClientFunc
+	key                  ClientFunc
+	uniqueClients        bool
+	latencyPercentiles   bool
+	credentials          *Credentials
+	tls                  *tlsFiles
+	replicator           *cluster.Replicator
+	resp                 streamServer
+	rpc                  streamServer
+	initialization       sync.Once
+	joining              sync.Once
+	initialized          int32
+	stateRestored        int32
 	http.Server
 }
 
@@ -36,7 +50,23 @@ func NewServer(env Environment) *server {
 	router := http.NewServeMux()
 	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
 	errorLogger := log.New(os.Stderr, "http: ", log.LstdFlags)
-	communication := NewCommunication()
+	communication := NewCommunication(env, logger)
+	var membership *cluster.Membership
+	if env.GossipAddress != "" {
+		var key []byte
+		if env.GossipKey != "" {
+			key = []byte(env.GossipKey)
+		}
+		membership = cluster.NewMembership(env.NodeID, env.GossipAddress, env.AdvertiseURL, env.Join, env.GossipInterval, key, logger)
+	}
+	weight := env.Weight
+	if weight == nil {
+		weight = UnitWeight
+	}
+	client := env.Client
+	if client == nil {
+		client = IPClient
+	}
 	server := &server{
 		router:               router,
 		Logger:               logger,
@@ -44,9 +74,16 @@ func NewServer(env Environment) *server {
 		persistenceTimeFrame: env.PersistenceTimeFrame,
 		precision:            env.Precision,
 		persistenceFile:      env.PersistenceFile,
+		weight:               weight,
+		client:               client,
+		key:                  env.Key,
+		uniqueClients:        env.UniqueClients,
+		latencyPercentiles:   env.LatencyPercentiles,
+		credentials:          env.Credentials,
+		replicator:           cluster.NewReplicator(env.NodeID, env.Peers, membership, env.ReplicationInterval, env.PersistenceTimeFrame, env.Precision, env.PeerToken, logger),
 		Server: http.Server{
 			Addr:         env.ListenAddress,
-			Handler:      tracing(nextRequestID)(logging(logger)(router)),
+			Handler:      tracing(nextRequestID)(realIP(env.TrustedProxies)(logging(logger)(router))),
 			ErrorLog:     errorLogger,
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 10 * time.Second,
@@ -61,17 +98,27 @@ func NewServer(env Environment) *server {
 Keep in mind: by the time the server has been restarted, the persisted values read and a new request is to be handled, the
 persisted request counts might no longer be in the persistence time frame. In that case, they will be discarded for the
 next request count computation.
+Returns whether the state was restored, which a missing file counts as. So does a file that cannot be read once it has
+been moved aside, next to it with the '.corrupt' extension: it would be overwritten on shutdown otherwise. Should it not
+be possible to move it, the state is not restored, and the server is not ready until the file is removed.
 */
-func (s *server) readStateFromDisk() {
+func (s *server) readStateFromDisk() bool {
 	if _, err := os.Open(s.persistenceFile); err != nil {
 		s.Logger.Printf("No state file could be found under '%v': %v. Will work on a clean slate.\n", s.persistenceFile, err)
 	} else {
 		s.Logger.Printf("Reading last state from file '%v'...\n", s.persistenceFile)
-		s.Communication.state, err = persistence.ReadFromFile(s.persistenceFile)
+		state, err := persistence.ReadFromFile(s.persistenceFile)
 		if err != nil {
-			s.Logger.Printf("Could not read state from file '%v': %v\n", s.persistenceFile, err)
-			return
+			// the clean slate stays in place rather than an empty state
+			corruptFile := s.persistenceFile + ".corrupt"
+			if renameErr := os.Rename(s.persistenceFile, corruptFile); renameErr != nil {
+				s.Logger.Printf("Could not read state from file '%v': %v, nor move it aside: %v. Will work on a clean slate.\n", s.persistenceFile, err, renameErr)
+				return false
+			}
+			s.Logger.Printf("Could not read state from file '%v': %v. Moved it to '%v'. Will work on a clean slate.\n", s.persistenceFile, err, corruptFile)
+			return true
 		}
+		s.Communication.state = state
 		s.Logger.Printf("State restored. Current request count: '%v'\n", s.Communication.state.Present.TotalRequestsWithinTimeframe)
 
 		s.Logger.Println("Removing file...")
@@ -79,8 +126,25 @@ func (s *server) readStateFromDisk() {
 			s.Logger.Printf("Could not remove state file '%v': %v\n", s.persistenceFile, err)
 		}
 	}
+	return true
 }
 
+/* Stops all background work: the RESP and RPC listeners first, so that no more keyed requests come in, then
+replication, so that no more remote counts are merged, then the cluster membership, announcing the departure of this
+instance, and finally the communication processor. See communication::Stop.
+*/
+func (s *server) Stop() {
+	s.resp.close()
+	s.rpc.close()
+	s.replicator.Stop()
+	if membership := s.replicator.Membership(); membership != nil {
+		membership.Stop()
+	}
+	s.Communication.Stop()
+}
+
+/* The state is only consistent once the communication processor has been stopped. See communication::Stop.
+ */
 func (s *server) PersistState() error {
 	s.Logger.Printf("Persisting state '%+v' to file '%v'.", s.Communication.state, s.persistenceFile)
 	if err := s.Communication.state.WriteToFile(s.persistenceFile); err != nil {
@@ -89,40 +153,77 @@ func (s *server) PersistState() error {
 	return nil
 }
 
+/* Restores the state from disk and starts the communication processor. Only the first call has an effect.
+Unless the server is started in eager mode, this happens when the first request comes in, which saves on boot up time.
+*/
+func (s *server) Initialize() {
+	s.initialization.Do(s.initialize)
+}
+
+func (s *server) Initialized() bool {
+	return atomic.LoadInt32(&s.initialized) == 1
+}
+
+/* Whether the persisted state was restored on initialization, or found to be missing. See readStateFromDisk.
+ */
+func (s *server) StateRestored() bool {
+	return atomic.LoadInt32(&s.stateRestored) == 1
+}
+
+/* Joins the cluster through the membership protocol, if enabled. Only the first call has an effect.
+Unlike the rest of the initialization, this happens at startup even in lazy mode, so that other instances discover this
+one right away. The first of them to pull its request counts initializes it.
+*/
+func (s *server) JoinCluster() {
+	s.joining.Do(func() {
+		if membership := s.replicator.Membership(); membership != nil {
+			if err := membership.Start(); err != nil {
+				s.Logger.Printf("Could not join the cluster: %v. Will only replicate with static peers.\n", err)
+			} else if !membership.Authenticated() {
+				s.Logger.Println("Membership messages are not signed: anyone reaching the gossip address may join. Members are not presented the peer token.")
+			}
+		}
+	})
+}
+
 func (s *server) initialize() {
 	s.Logger.Print("Initialising server with following parameters:")
 	s.Logger.Printf("Persistence File: '%v'\n", s.persistenceFile)
 	s.Logger.Printf("Persistence Timeframe: '%v'\n", s.persistenceTimeFrame)
 	s.Logger.Printf("Precision: '%v'\n", s.precision)
-	s.readStateFromDisk()
-	s.startCommunicationProcessor()
+	s.Logger.Printf("Max Queue Depth: '%v'\n", s.Communication.maxQueueDepth)
+	s.Logger.Printf("Max Wait: '%v'\n", s.Communication.maxWait)
+	s.Logger.Printf("Lateness: '%v'\n", s.Communication.lateness)
+	s.Logger.Printf("Half-life: '%v'\n", s.Communication.halfLife)
+	s.Logger.Printf("Approximate: '%v'\n", s.Communication.approximate)
+	s.Logger.Printf("Limits: '%v'\n", s.Communication.limits)
+	s.Logger.Printf("Quotas: '%v'\n", s.Communication.quotas)
+	s.Logger.Printf("Alert Rules: '%v'\n", len(s.Communication.alertRules))
+	s.Logger.Printf("Alert Webhook: '%v'\n", s.Communication.alertWebhook)
+	s.Logger.Printf("Authentication: '%v'\n", s.credentials != nil)
+	s.Logger.Printf("Node ID: '%v'\n", s.replicator.NodeID)
+	s.Logger.Printf("Peers: '%v'\n", s.replicator.Peers())
+	if s.readStateFromDisk() {
+		atomic.StoreInt32(&s.stateRestored, 1)
+	}
+	s.Communication.Start(context.Background())
+	s.JoinCluster()
+	s.replicator.Start(context.Background())
+	atomic.StoreInt32(&s.initialized, 1)
 }
 
 func logging(logger *log.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
-				requestID, ok := r.Context().Value(requestIDKey).(string)
-				if !ok {
-					requestID = "unknown"
+				requestID := requestIDFromContext(r.Context())
+				traceID := traceContextFromContext(r.Context()).traceID
+				if traceID == "" {
+					traceID = "unknown"
 				}
-				logger.Println(requestID, r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+				logger.Println(requestID, traceID, r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
 			}()
 			next.ServeHTTP(w, r)
 		})
 	}
 }
-
-func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestID := r.Header.Get("X-Request-Id")
-			if requestID == "" {
-				requestID = nextRequestID()
-			}
-			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
-			w.Header().Set("X-Request-Id", requestID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
-	}
-}
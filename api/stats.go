@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"movingwindow/persistence"
+	"net/http"
+	"time"
+)
+
+/* Keeps track of the status and the size of the body of a response as it is written.
+ */
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+/* Records the size and latency of the responses of a counting handler along with the request counts, see
+persistence::Aggregates. The time the request came in is put into the context, so that the handler counts the request
+at the same point in time the response is recorded for. A time put into the context by an outer middleware is kept.
+Only successful responses are recorded, as only their requests have been counted. Latencies are only sketched if
+percentiles are enabled, see Latency.
+*/
+func (s *server) recording(com communication) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := requestTimeFromContext(r.Context())
+			recorder := &responseRecorder{ResponseWriter: w}
+			next(recorder, r.WithContext(context.WithValue(r.Context(), requestTimeKey, start)))
+			if recorder.status != http.StatusOK {
+				return
+			}
+
+			aggregates := persistence.NewAggregates(recorder.bytes, time.Since(start))
+			if !s.latencyPercentiles {
+				aggregates.Latencies = nil
+			}
+			// the client has its response already, so it does not get to cancel the recording
+			if err := com.record(context.Background(), start.Truncate(s.precision), aggregates); err != nil {
+				s.Logger.Printf("Response could not be recorded: %v\n", err)
+			}
+		}
+	}
+}
+
+/* Time the request came in, as set by the recording middleware. Falls back to the current time.
+ */
+func requestTimeFromContext(ctx context.Context) time.Time {
+	if start, ok := ctx.Value(requestTimeKey).(time.Time); ok {
+		return start
+	}
+	return time.Now()
+}
+
+/* Figures of the requests counted by this instance within the persistence time frame, as of the time of the request:
+- RequestCount: sum of the weights of the requests. Unlike for the index, requests counted by peers are not included.
+- Responses: number of responses recorded
+- Bytes: sum of the sizes of the response bodies
+- LatencyMs, AverageLatencyMs, MaxLatencyMs: total, average and longest time taken to serve a request, in milliseconds
+*/
+type StatsResponse struct {
+	RequestCount     int     `json:"requestCount"`
+	Responses        int     `json:"responses"`
+	Bytes            int64   `json:"bytes"`
+	LatencyMs        float64 `json:"latencyMs"`
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+	MaxLatencyMs     float64 `json:"maxLatencyMs"`
+}
+
+func NewStatsResponse(requestCounts []persistence.RequestCount, reference time.Time, timeFrame time.Duration, precision time.Duration) StatsResponse {
+	count, aggregates := aggregatesWithin(requestCounts, reference, timeFrame, precision)
+	response := StatsResponse{
+		RequestCount: count,
+		Responses:    aggregates.Requests,
+		Bytes:        aggregates.Bytes,
+		LatencyMs:    milliseconds(aggregates.Latency),
+		MaxLatencyMs: milliseconds(aggregates.MaxLatency),
+	}
+	if aggregates.Requests > 0 {
+		response.AverageLatencyMs = milliseconds(aggregates.Latency / time.Duration(aggregates.Requests))
+	}
+	return response
+}
+
+/* Sum of the counts and the aggregates of the request counts within the time frame before the reference.
+ */
+func aggregatesWithin(requestCounts []persistence.RequestCount, reference time.Time, timeFrame time.Duration, precision time.Duration) (int, persistence.Aggregates) {
+	var count int
+	var aggregates persistence.Aggregates
+	for _, requestCount := range requestCounts {
+		if within, _ := requestCount.WithinDurationBefore(timeFrame, precision, persistence.RequestCount{Timestamp: reference}); within {
+			count += requestCount.Count
+			aggregates = aggregates.Merge(requestCount.Aggregates)
+		}
+	}
+	return count, aggregates
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+/* Serves the figures of the requests within the persistence time frame. Requests to it are not counted.
+ */
+func (s *server) Stats(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		requestCounts, err := com.snapshot(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, NewStatsResponse(requestCounts, time.Now(), s.persistenceTimeFrame, s.precision))
+	})
+}
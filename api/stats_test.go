@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"movingwindow/persistence"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewStatsResponse(t *testing.T) {
+	reference := time.Date(2000, 1, 1, 0, 0, 10, 0, time.UTC)
+	requestCounts := []persistence.RequestCount{
+		{Timestamp: reference.Add(-20 * time.Second), Count: 7, Aggregates: persistence.NewAggregates(700, time.Second)},
+		{Timestamp: reference.Add(-5 * time.Second), Count: 2, Aggregates: persistence.NewAggregates(10, 2*time.Millisecond).Merge(persistence.NewAggregates(30, 6*time.Millisecond))},
+		{Timestamp: reference, Count: 3, Aggregates: persistence.NewAggregates(5, time.Millisecond)},
+	}
+
+	result := NewStatsResponse(requestCounts, reference, 10*time.Second, time.Second)
+	expected := StatsResponse{RequestCount: 5, Responses: 3, Bytes: 45, LatencyMs: 9, AverageLatencyMs: 3, MaxLatencyMs: 6}
+	if result != expected {
+		t.Fatalf("Expected stats '%+v', got '%+v'\n", expected, result)
+	}
+
+	if empty := NewStatsResponse(nil, reference, 10*time.Second, time.Second); empty != (StatsResponse{}) {
+		t.Fatalf("Expected empty stats without request counts, got '%+v'\n", empty)
+	}
+}
+
+func TestStats(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Weight:               HeaderWeight("X-Cost"),
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	var bytes int64
+	for _, cost := range []string{"", "4", "invalid"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Cost", cost)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		if w.Code == http.StatusOK {
+			bytes += int64(w.Body.Len())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status '%v', got '%v'\n", http.StatusOK, w.Code)
+	}
+	var stats StatsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Error decoding stats '%v': %v\n", w.Body.String(), err)
+	}
+	// the request with an invalid weight is neither counted nor recorded
+	if stats.RequestCount != 5 || stats.Responses != 2 || stats.Bytes != bytes {
+		t.Fatalf("Expected 5 requests, 2 responses and %v bytes, got '%+v'\n", bytes, stats)
+	}
+	if stats.MaxLatencyMs <= 0 || stats.MaxLatencyMs > stats.LatencyMs || stats.AverageLatencyMs > stats.MaxLatencyMs {
+		t.Fatalf("Expected consistent latencies, got '%+v'\n", stats)
+	}
+}
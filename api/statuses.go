@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/* Counts the responses of a counting handler by the class of their status code, such as "2xx" or "5xx", including
+those the request was rejected with. Like the index, the first call initializes the server, see server::Initialize.
+The time the request came in is put into the context, so that the request, its response and its status are all counted
+at the same point in time. See recording.
+The IP address and the path of the request are kept track of along with the status, to rank them. See Top.
+*/
+func (s *server) classifying(com communication) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			s.Initialize()
+
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			next(recorder, r.WithContext(context.WithValue(r.Context(), requestTimeKey, start)))
+
+			// the client has its response already, so it does not get to cancel the recording
+			hit := statusHit{timestamp: start.Truncate(s.precision), class: statusClass(recorder.status), ip: IPClient(r), path: r.URL.Path}
+			if err := com.recordStatus(context.Background(), hit); err != nil {
+				s.Logger.Printf("Response status could not be recorded: %v\n", err)
+			}
+		}
+	}
+}
+
+/* Class of a status code, as in "4xx". A handler that did not write anything responded with a 200.
+ */
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+/* Responses of this instance within the persistence time frame, as of the time of the request:
+- Responses: number of responses
+- Classes: number of responses by the class of their status code. Classes without responses are left out.
+- ErrorRatio: share of server errors - 5xx - among the responses. Zero without responses.
+*/
+type StatusesResponse struct {
+	Responses  int            `json:"responses"`
+	Classes    map[string]int `json:"classes"`
+	ErrorRatio float64        `json:"errorRatio"`
+}
+
+func NewStatusesResponse(classes map[string]int) StatusesResponse {
+	response := StatusesResponse{Classes: make(map[string]int, len(classes))}
+	for class, count := range classes {
+		if count <= 0 {
+			continue
+		}
+		response.Classes[class] = count
+		response.Responses += count
+	}
+	if response.Responses > 0 {
+		response.ErrorRatio = float64(response.Classes["5xx"]) / float64(response.Responses)
+	}
+	return response
+}
+
+/* Serves the responses within the persistence time frame by status class, along with the error ratio. Requests to it
+are not counted.
+*/
+func (s *server) Statuses(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		classes, err := com.statusCounts(r.Context(), time.Now())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, NewStatusesResponse(classes))
+	})
+}
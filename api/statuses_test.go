@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusClass(t *testing.T) {
+	for status, expected := range map[int]string{0: "2xx", 200: "2xx", 304: "3xx", 404: "4xx", 503: "5xx"} {
+		if class := statusClass(status); class != expected {
+			t.Fatalf("Expected class '%v' for status '%v', got '%v'\n", expected, status, class)
+		}
+	}
+}
+
+var statusesResponseTests = []struct {
+	classes  map[string]int
+	expected StatusesResponse
+}{
+	{ // no responses
+		classes:  nil,
+		expected: StatusesResponse{Classes: map[string]int{}},
+	},
+	{ // no errors
+		classes:  map[string]int{"2xx": 3, "4xx": 1},
+		expected: StatusesResponse{Responses: 4, Classes: map[string]int{"2xx": 3, "4xx": 1}},
+	},
+	{ // server errors
+		classes:  map[string]int{"2xx": 6, "4xx": 1, "5xx": 3, "3xx": 0},
+		expected: StatusesResponse{Responses: 10, Classes: map[string]int{"2xx": 6, "4xx": 1, "5xx": 3}, ErrorRatio: 0.3},
+	},
+}
+
+func TestNewStatusesResponse(t *testing.T) {
+	for i, test := range statusesResponseTests {
+		if result := NewStatusesResponse(test.classes); !reflect.DeepEqual(result, test.expected) {
+			t.Fatalf("Test '%v': expected '%+v', got '%+v'\n", i, test.expected, result)
+		}
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Weight:               HeaderWeight("X-Cost"),
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/", nil),
+		httptest.NewRequest("HEAD", "/", nil),
+		httptest.NewRequest("DELETE", "/", nil),
+		httptest.NewRequest("GET", "/unknown", nil),
+		httptest.NewRequest("GET", "/", nil),
+	}
+	requests[4].Header.Set("X-Cost", "invalid")
+	for _, r := range requests {
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	// requests to other endpoints are not counted
+	srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/queue", nil))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/statuses", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status '%v', got '%v'\n", http.StatusOK, w.Code)
+	}
+	var statuses StatusesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("Error decoding statuses '%v': %v\n", w.Body.String(), err)
+	}
+	expected := StatusesResponse{Responses: 5, Classes: map[string]int{"2xx": 3, "4xx": 2}}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("Expected statuses '%+v', got '%+v'\n", expected, statuses)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+/* Keeps track of a TCP listener and of the connections it accepted, so that they can be closed when the server stops.
+Used by the listeners of the protocols that are served next to HTTP, see resp.go and rpc.go.
+*/
+type streamServer struct {
+	mu          sync.Mutex
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	goroutines  sync.WaitGroup
+	closed      bool
+}
+
+/* Serves every accepted connection in its own goroutine until close() is called, in which case nil is returned. The
+connection is closed by serve() once the handler returns.
+*/
+func (l *streamServer) serve(listener net.Listener, handle func(net.Conn)) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	l.listener = listener
+	l.connections = make(map[net.Conn]struct{})
+	l.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		l.connections[conn] = struct{}{}
+		l.goroutines.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer l.goroutines.Done()
+			defer conn.Close()
+			handle(conn)
+			l.mu.Lock()
+			delete(l.connections, conn)
+			l.mu.Unlock()
+		}()
+	}
+}
+
+/* Stops accepting connections and closes the open ones. Commands that are being served are answered before their
+connection goes away. Harmless if no listener was ever started.
+*/
+func (l *streamServer) close() {
+	l.mu.Lock()
+	l.closed = true
+	if l.listener != nil {
+		l.listener.Close()
+	}
+	for conn := range l.connections {
+		// wakes up readers, while letting commands being served write their reply
+		conn.SetReadDeadline(time.Now())
+	}
+	l.mu.Unlock()
+	l.goroutines.Wait()
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
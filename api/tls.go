@@ -0,0 +1,158 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+/* TLS configuration of the server, loaded from files so that it can be reloaded without a restart:
+- certFile, keyFile: PEM encoded certificate chain and private key the server presents.
+- clientCAFile: PEM encoded certificate authorities client certificates are verified against. Empty accepts no client
+  certificates.
+- clientCertOptional: clients without a certificate are let through. Those with one still need it to be valid.
+- peerCAFile: PEM encoded certificate authorities the certificates of peers are verified against when replicating.
+  Empty falls back to clientCAFile, or else to the authorities of the system.
+- config: the *tls.Config handshakes currently use. Swapped as a whole on reload, so that a handshake never sees a new
+  certificate along with an old authority.
+- peerConfig: the *tls.Config peers are pulled with. The certificate of the server doubles as the client certificate
+  presented to them, so that peers behind mutual TLS let replication through.
+*/
+type tlsFiles struct {
+	certFile           string
+	keyFile            string
+	clientCAFile       string
+	clientCertOptional bool
+	peerCAFile         string
+	config             atomic.Value
+	peerConfig         atomic.Value
+}
+
+func readAuthorities(file string) (*x509.CertPool, error) {
+	encoded, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	authorities := x509.NewCertPool()
+	if !authorities.AppendCertsFromPEM(encoded) {
+		return nil, fmt.Errorf("no certificate could be found in '%v'", file)
+	}
+	return authorities, nil
+}
+
+/* Loads the files and swaps the configuration. On error, the current configuration stays in use.
+ */
+func (t *tlsFiles) load() error {
+	certificate, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	peerConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.clientCAFile != "" {
+		authorities, err := readAuthorities(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read the client certificate authorities: %v", err)
+		}
+		config.ClientCAs = authorities
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if t.clientCertOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		peerConfig.RootCAs = authorities
+	}
+	if t.peerCAFile != "" {
+		authorities, err := readAuthorities(t.peerCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read the peer certificate authorities: %v", err)
+		}
+		peerConfig.RootCAs = authorities
+	}
+
+	t.config.Store(config)
+	t.peerConfig.Store(peerConfig)
+	return nil
+}
+
+func (t *tlsFiles) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return t.config.Load().(*tls.Config), nil
+}
+
+func (t *tlsFiles) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &t.config.Load().(*tls.Config).Certificates[0], nil
+}
+
+/* Serves HTTPS with the given certificate and key rather than plain HTTP, see ListenAndServeTLS. If a client
+certificate authority is given, clients must present a certificate signed by it, unless it is optional: mutual TLS.
+Peers are pulled over TLS as well, see tlsFiles.
+Must be called before the server starts listening. Fails if the files cannot be loaded.
+*/
+func (s *server) EnableTLS(certFile string, keyFile string, clientCAFile string, clientCertOptional bool, peerCAFile string) error {
+	if certFile == "" || keyFile == "" {
+		return errors.New("both a certificate and a private key are needed to serve TLS")
+	}
+	files := &tlsFiles{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, clientCertOptional: clientCertOptional, peerCAFile: peerCAFile}
+	if err := files.load(); err != nil {
+		return err
+	}
+	s.tls = files
+	s.replicator.SetTLSConfig(files.peerConfig.Load().(*tls.Config))
+	s.TLSConfig = &tls.Config{
+		GetCertificate:     files.certificate,
+		GetConfigForClient: files.configForClient,
+	}
+	return nil
+}
+
+func (s *server) TLSEnabled() bool {
+	return s.tls != nil
+}
+
+/* Reloads the certificate, the key and the certificate authorities from their files, e.g. once they have been renewed.
+New connections use them right away, established ones keep theirs. On error, the files in use stay in use.
+*/
+func (s *server) ReloadTLS() error {
+	if s.tls == nil {
+		return errors.New("TLS is not enabled")
+	}
+	if err := s.tls.load(); err != nil {
+		return err
+	}
+	s.replicator.SetTLSConfig(s.tls.peerConfig.Load().(*tls.Config))
+	s.Logger.Printf("Reloaded TLS certificate '%v'\n", s.tls.certFile)
+	return nil
+}
+
+/* Listens on the address of the server, with TLS if enabled. See EnableTLS.
+ */
+func (s *server) ListenAndServeHTTP() error {
+	if s.tls != nil {
+		// the certificate is provided by the configuration
+		return s.ListenAndServeTLS("", "")
+	}
+	return s.ListenAndServe()
+}
+
+/* Clients are told apart by the subject of the certificate they authenticated with over mutual TLS, such as
+'CN=billing,O=Example'. Requests without a verified certificate are not told apart.
+With '--key cert-subject', requests are counted in the keyed counter of the subject as well. See ParseKey.
+*/
+func CertificateClient(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/* Certificate along with its private key, signed by the parent or self-signed without one.
+ */
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+	keyPEM      []byte
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating a key: %v\n", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	encoded, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Error creating a certificate: %v\n", err)
+	}
+	certificate, _ := x509.ParseCertificate(encoded)
+	encodedKey, _ := x509.MarshalECPrivateKey(key)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: encoded}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey}),
+	}
+}
+
+func (c *testCertificate) keyPair() tls.Certificate {
+	keyPair, _ := tls.X509KeyPair(c.pem, c.keyPEM)
+	return keyPair
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Error writing '%v': %v\n", path, err)
+	}
+}
+
+/* Clients over mutual TLS are told apart by the subject of their certificate, and the certificate of the server is
+reloaded without a restart.
+*/
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Error creating a temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+
+	ca := newTestCertificate(t, "ca", nil)
+	server := newTestCertificate(t, "server", ca)
+	writeTestFile(t, certFile, server.pem)
+	writeTestFile(t, keyFile, server.keyPEM)
+	writeTestFile(t, caFile, ca.pem)
+
+	srv := NewServer(Environment{
+		ListenAddress:        "127.0.0.1:0",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Client:               CertificateClient,
+		Key:                  CertificateClient,
+		UniqueClients:        true,
+		Quotas:               map[string]Quota{"CN=alice,*": {Period: "daily", Limit: 10, Location: time.UTC}},
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+	if err := srv.EnableTLS(certFile, keyFile, caFile, false, ""); err != nil {
+		t.Fatalf("Error enabling TLS: %v\n", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v\n", err)
+	}
+	go srv.ServeTLS(listener, "", "")
+	defer srv.Close()
+	url := "https://" + listener.Addr().String() + "/"
+
+	authorities := x509.NewCertPool()
+	authorities.AddCert(ca.certificate)
+	newClient := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: authorities, Certificates: certificates}}}
+	}
+
+	// clients must present a certificate
+	if _, err := newClient().Get(url); err == nil {
+		t.Fatal("Expected a client without a certificate to be rejected\n")
+	}
+
+	var response Response
+	for _, name := range []string{"alice", "bob", "alice"} {
+		result, err := newClient(newTestCertificate(t, name, ca).keyPair()).Get(url)
+		if err != nil {
+			t.Fatalf("Error requesting as '%v': %v\n", name, err)
+		}
+		if err := json.NewDecoder(result.Body).Decode(&response); err != nil {
+			t.Fatalf("Error decoding the response: %v\n", err)
+		}
+		result.Body.Close()
+	}
+	if response.RequestCount != 3 || response.UniqueClients == nil || *response.UniqueClients != 2 {
+		t.Fatalf("Expected '3' requests from '2' clients, got '%+v'\n", response)
+	}
+
+	// requests are counted in the keyed counter of their subject as well, along with its quota
+	for subject, expected := range map[string]int{"CN=alice,O=Example": 2, "CN=bob,O=Example": 1} {
+		if count, err := srv.countKey(subject, 0); err != nil || count != expected {
+			t.Fatalf("Expected '%v' requests for key '%v', got '%v': %v\n", expected, subject, count, err)
+		}
+	}
+	usage, err := srv.Communication.quotaUsage(context.Background(), "CN=alice,O=Example")
+	if used, _ := usage.At(time.Now(), "daily", time.UTC); err != nil || used != 2 {
+		t.Fatalf("Expected '2' requests against the quota of alice, got '%v': %v\n", used, err)
+	}
+
+	// a renewed certificate is served once reloaded
+	renewed := newTestCertificate(t, "server", ca)
+	writeTestFile(t, certFile, renewed.pem)
+	writeTestFile(t, keyFile, renewed.keyPEM)
+	if err := srv.ReloadTLS(); err != nil {
+		t.Fatalf("Error reloading TLS: %v\n", err)
+	}
+	result, err := newClient(newTestCertificate(t, "alice", ca).keyPair()).Get(url)
+	if err != nil {
+		t.Fatalf("Error requesting after the reload: %v\n", err)
+	}
+	result.Body.Close()
+	if served := result.TLS.PeerCertificates[0].SerialNumber; served.Cmp(renewed.certificate.SerialNumber) != 0 {
+		t.Fatalf("Expected the renewed certificate to be served, got serial '%v'\n", served)
+	}
+
+	// files that cannot be loaded leave the current certificate in use
+	writeTestFile(t, keyFile, []byte("not a key"))
+	if err := srv.ReloadTLS(); err == nil {
+		t.Fatal("Expected reloading an invalid key to fail\n")
+	}
+	if _, err := newClient(newTestCertificate(t, "alice", ca).keyPair()).Get(url); err != nil {
+		t.Fatalf("Expected the current certificate to stay in use, got '%v'\n", err)
+	}
+}
+
+func TestTLS_ClientCertOptional(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Error creating a temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+
+	ca := newTestCertificate(t, "ca", nil)
+	server := newTestCertificate(t, "server", ca)
+	writeTestFile(t, certFile, server.pem)
+	writeTestFile(t, keyFile, server.keyPEM)
+	writeTestFile(t, caFile, ca.pem)
+
+	srv := NewServer(Environment{ListenAddress: "127.0.0.1:0", PersistenceFile: "NOT_SET", Precision: time.Second, PersistenceTimeFrame: time.Minute})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	if err := srv.EnableTLS(certFile, keyFile, caFile, true, ""); err != nil {
+		t.Fatalf("Error enabling TLS: %v\n", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v\n", err)
+	}
+	go srv.ServeTLS(listener, "", "")
+	defer srv.Close()
+
+	authorities := x509.NewCertPool()
+	authorities.AddCert(ca.certificate)
+	untrusted := newTestCertificate(t, "mallory", newTestCertificate(t, "ca", nil))
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		fails        bool
+	}{
+		{name: "without a certificate"},
+		{name: "with a valid certificate", certificates: []tls.Certificate{newTestCertificate(t, "alice", ca).keyPair()}},
+		{name: "with an untrusted certificate", certificates: []tls.Certificate{untrusted.keyPair()}, fails: true},
+	}
+	for _, test := range tests {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: authorities, Certificates: test.certificates}}}
+		result, err := client.Get("https://" + listener.Addr().String() + "/healthz")
+		if (err != nil) != test.fails {
+			t.Fatalf("Test '%v': expected failure to be '%v', got error '%v'\n", test.name, test.fails, err)
+		}
+		if err == nil {
+			result.Body.Close()
+		}
+	}
+
+	if err := srv.EnableTLS(certFile, "", "", false, ""); err == nil {
+		t.Fatal("Expected enabling TLS without a key to fail\n")
+	}
+}
+
+/* Replicas behind mutual TLS pull each other's request counts over HTTPS, presenting their own certificate.
+ */
+func TestTLSReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Error creating a temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := newTestCertificate(t, "ca", nil)
+	writeTestFile(t, caFile, ca.pem)
+
+	listeners := make([]net.Listener, 2)
+	urls := make([]string, 2)
+	for i := range listeners {
+		if listeners[i], err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatalf("Error listening: %v\n", err)
+		}
+		urls[i] = "https://" + listeners[i].Addr().String()
+	}
+
+	servers := make([]*server, 2)
+	for i, listener := range listeners {
+		name := fmt.Sprintf("node-%v", i)
+		certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+		node := newTestCertificate(t, name, ca)
+		writeTestFile(t, certFile, node.pem)
+		writeTestFile(t, keyFile, node.keyPEM)
+
+		srv := NewServer(Environment{
+			ListenAddress:        listener.Addr().String(),
+			PersistenceFile:      "NOT_SET",
+			Precision:            time.Second,
+			PersistenceTimeFrame: time.Minute,
+			NodeID:               name,
+			Peers:                []string{urls[1-i]},
+			ReplicationInterval:  20 * time.Millisecond,
+		})
+		srv.Logger.SetOutput(ioutil.Discard)
+		srv.ErrorLog.SetOutput(ioutil.Discard)
+		srv.Routes()
+		// client certificates are required, peers included
+		if err := srv.EnableTLS(certFile, keyFile, caFile, false, ""); err != nil {
+			t.Fatalf("Error enabling TLS: %v\n", err)
+		}
+		srv.Initialize()
+		go srv.ServeTLS(listener, "", "")
+		defer srv.Stop()
+		defer srv.Close()
+		servers[i] = srv
+	}
+
+	authorities := x509.NewCertPool()
+	authorities.AddCert(ca.certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      authorities,
+		Certificates: []tls.Certificate{newTestCertificate(t, "alice", ca).keyPair()},
+	}}}
+	for i := 0; i < 3; i++ {
+		result, err := client.Get(urls[0] + "/")
+		if err != nil {
+			t.Fatalf("Error requesting: %v\n", err)
+		}
+		result.Body.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		replicated := servers[1].replicator.Total()
+		if replicated == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected '3' requests to be replicated over TLS, got '%v'\n", replicated)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"movingwindow/persistence"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/* Dimensions values can be ranked by at /top.
+ */
+const (
+	topByIP   = "ip"
+	topByPath = "path"
+	topByKey  = "key"
+)
+
+/* Number of values ranked at /top unless asked otherwise.
+ */
+const defaultTopK = 10
+
+/* Value along with the number of requests it came with within the persistence time frame. The value came with at most
+Count requests, and with at least Count - Error. The error of keyed counters is always zero.
+*/
+type TopEntry struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	Error int    `json:"error"`
+}
+
+/* Values requests came with most often, as of the time of the request, highest count first:
+- By: the dimension of the values: "ip", "path" or "key"
+- Status: status class the ranking is restricted to. Empty for all of them.
+- Top: the ranked values
+*/
+type TopResponse struct {
+	By     string     `json:"by"`
+	Status string     `json:"status,omitempty"`
+	Top    []TopEntry `json:"top"`
+}
+
+func NewTopResponse(by string, status string, entries []persistence.TopEntry) TopResponse {
+	response := TopResponse{By: by, Status: status, Top: make([]TopEntry, 0, len(entries))}
+	for _, entry := range entries {
+		response.Top = append(response.Top, TopEntry{Value: entry.Value, Count: entry.Count, Error: entry.Error})
+	}
+	return response
+}
+
+/* Serves the heavy hitters within the persistence time frame, to tell who caused a spike of traffic. Query parameters:
+- by: "ip" or "path" rank the requests to the index by their IP address or path, errors included. "key" ranks the keyed
+  counters by their request count. Defaults to "ip".
+- k: number of values to rank, between 1 and persistence::TopCapacity. Defaults to 10.
+- status: restricts the ranking of requests to the index to a status class, such as "5xx".
+IP addresses and paths are estimated by every instance on its own, see persistence::SpaceSaving. Requests to it are not
+counted.
+*/
+func (s *server) Top(com communication) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Initialize()
+
+		query := r.URL.Query()
+		request := topRequest{reference: time.Now(), by: query.Get("by"), class: query.Get("status"), k: defaultTopK}
+		switch request.by {
+		case "":
+			request.by = topByIP
+		case topByIP, topByPath, topByKey:
+		default:
+			writeError(w, r, errBadRequest.withMessage("Unknown dimension '%v': expected '%v', '%v' or '%v'", request.by, topByIP, topByPath, topByKey))
+			return
+		}
+		if k := query.Get("k"); k != "" {
+			var err error
+			request.k, err = strconv.Atoi(k)
+			if err != nil || request.k < 1 || request.k > persistence.TopCapacity {
+				writeError(w, r, errBadRequest.withMessage("k must be an integer between 1 and %v, got '%v'", persistence.TopCapacity, k))
+				return
+			}
+		}
+		if request.class != "" && (request.by == topByKey || !validStatusClass(request.class)) {
+			writeError(w, r, errBadRequest.withMessage("Status '%v' must be a class such as '5xx', and cannot restrict keys", request.class))
+			return
+		}
+
+		entries, err := com.top(r.Context(), request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, NewTopResponse(request.by, request.class, entries))
+	})
+}
+
+func validStatusClass(class string) bool {
+	return len(class) == 3 && class[0] >= '1' && class[0] <= '5' && class[1:] == "xx"
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTop(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	requests := []struct {
+		address string
+		path    string
+		times   int
+	}{
+		{address: "10.0.0.1:1000", path: "/", times: 3},
+		{address: "10.0.0.2:1000", path: "/wp-admin", times: 5},
+		{address: "10.0.0.3:1000", path: "/", times: 1},
+	}
+	for _, request := range requests {
+		for i := 0; i < request.times; i++ {
+			r := httptest.NewRequest("GET", request.path, nil)
+			r.RemoteAddr = request.address
+			srv.Handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	}
+	if _, err := srv.Communication.exchangeKeyed(context.Background(), keyHit{key: "tenant:1", timestamp: time.Now(), n: 7}, keyHit{key: "tenant:2", timestamp: time.Now(), n: 2}); err != nil {
+		t.Fatalf("Error hitting keys: %v\n", err)
+	}
+
+	tests := []struct {
+		query          string
+		expectedStatus int
+		expected       TopResponse
+	}{
+		{query: "", expectedStatus: http.StatusOK, expected: TopResponse{By: "ip", Top: []TopEntry{{Value: "10.0.0.2", Count: 5}, {Value: "10.0.0.1", Count: 3}, {Value: "10.0.0.3", Count: 1}}}},
+		{query: "?by=path&k=1", expectedStatus: http.StatusOK, expected: TopResponse{By: "path", Top: []TopEntry{{Value: "/wp-admin", Count: 5}}}},
+		{query: "?by=path&status=2xx", expectedStatus: http.StatusOK, expected: TopResponse{By: "path", Status: "2xx", Top: []TopEntry{{Value: "/", Count: 4}}}},
+		{query: "?by=ip&status=5xx", expectedStatus: http.StatusOK, expected: TopResponse{By: "ip", Status: "5xx", Top: []TopEntry{}}},
+		{query: "?by=key", expectedStatus: http.StatusOK, expected: TopResponse{By: "key", Top: []TopEntry{{Value: "tenant:1", Count: 7}, {Value: "tenant:2", Count: 2}}}},
+		{query: "?by=user", expectedStatus: http.StatusBadRequest},
+		{query: "?k=0", expectedStatus: http.StatusBadRequest},
+		{query: "?k=1000", expectedStatus: http.StatusBadRequest},
+		{query: "?status=500", expectedStatus: http.StatusBadRequest},
+		{query: "?by=key&status=2xx", expectedStatus: http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/top"+test.query, nil))
+		if w.Code != test.expectedStatus {
+			t.Fatalf("Test '%v': expected status '%v', got '%v': %v\n", i, test.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var response TopResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Test '%v': error decoding '%v': %v\n", i, w.Body.String(), err)
+		}
+		if !reflect.DeepEqual(response, test.expected) {
+			t.Fatalf("Test '%v': expected '%+v', got '%+v'\n", i, test.expected, response)
+		}
+	}
+}
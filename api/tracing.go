@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	requestIDHeader   = "X-Request-Id"
+	traceParentHeader = "traceparent"
+	traceStateHeader  = "tracestate"
+	traceVersion      = "00"
+	// longest request ID accepted from clients
+	maxRequestIDLength = 128
+)
+
+/* Fallback sequence for ID generation, only used if the system's random source is not available.
+ */
+var fallbackIDSequence uint64
+
+/* Produces a random hexadecimal identifier of the given amount of bytes. Unlike timestamps, random identifiers do not
+collide when many requests come in at the same time. Should the random source fail, the identifier is made up of the
+current time and a process-wide sequence number, which is still unique within the running process.
+*/
+func randomID(numBytes int) string {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%0*x", numBytes*2, uint64(time.Now().UnixNano())+atomic.AddUint64(&fallbackIDSequence, 1))
+	}
+	return hex.EncodeToString(b)
+}
+
+func nextRequestID() string {
+	return randomID(16)
+}
+
+/* W3C trace context (https://www.w3.org/TR/trace-context/) of a request:
+- traceID: identifier of the whole trace, shared by all participants. 32 hex characters.
+- parentID: identifier of the span of the caller. Empty if the request did not carry a valid 'traceparent' header.
+- spanID: identifier of the span of this server, handed over to the client as the new parent. 16 hex characters.
+- flags: trace flags as received from the caller, '00' if none were received.
+- state: vendor specific 'tracestate' value. It is propagated untouched.
+*/
+type traceContext struct {
+	traceID  string
+	parentID string
+	spanID   string
+	flags    string
+	state    string
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+/* Whether a request ID provided by a client may be echoed and logged: at most maxRequestIDLength letters, digits, '.',
+'_' or '-'. Anything else could forge log lines or bloat responses.
+*/
+func isValidRequestID(s string) bool {
+	if s == "" || len(s) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroID(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+/* Parses a 'traceparent' header value of the form 'version-traceid-parentid-flags'.
+Values with an unknown format, uppercase characters or all-zero identifiers are rejected as required by the spec.
+Future versions are accepted as long as the first four fields match the format of version '00'.
+*/
+func parseTraceParent(value string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return traceContext{}, false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return traceContext{}, false
+	}
+	if version == traceVersion && len(parts) != 4 {
+		return traceContext{}, false
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || isZeroID(traceID) {
+		return traceContext{}, false
+	}
+	if len(parentID) != 16 || !isLowerHex(parentID) || isZeroID(parentID) {
+		return traceContext{}, false
+	}
+	if len(flags) != 2 || !isLowerHex(flags) {
+		return traceContext{}, false
+	}
+
+	return traceContext{traceID: traceID, parentID: parentID, flags: flags}, true
+}
+
+/* Builds the trace context of the server for the given request. Incoming trace identifiers are continued, otherwise a
+new trace is started. Either way, the server gets a fresh span identifier.
+*/
+func newTraceContext(r *http.Request) traceContext {
+	tc, ok := parseTraceParent(r.Header.Get(traceParentHeader))
+	if !ok {
+		tc = traceContext{traceID: randomID(16), flags: "00"}
+	} else {
+		tc.state = strings.Join(r.Header.Values(traceStateHeader), ",")
+	}
+	tc.spanID = randomID(8)
+	return tc
+}
+
+/* 'traceparent' value to be propagated: the span of the server becomes the parent of whoever comes next.
+ */
+func (tc traceContext) traceParent() string {
+	return strings.Join([]string{traceVersion, tc.traceID, tc.spanID, tc.flags}, "-")
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, ok := ctx.Value(requestIDKey).(string)
+	if !ok {
+		return "unknown"
+	}
+	return requestID
+}
+
+func traceContextFromContext(ctx context.Context) traceContext {
+	tc, _ := ctx.Value(traceContextKey).(traceContext)
+	return tc
+}
+
+/* Attaches a request ID and a trace context to every request. The request ID is taken from the 'X-Request-Id' header
+if provided by the client and valid, and generated otherwise. See isValidRequestID. Both are echoed back to the client in the response headers.
+*/
+func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(requestIDHeader)
+			if !isValidRequestID(requestID) {
+				requestID = nextRequestID()
+			}
+			tc := newTraceContext(r)
+
+			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+			ctx = context.WithValue(ctx, traceContextKey, tc)
+			w.Header().Set(requestIDHeader, requestID)
+			w.Header().Set(traceParentHeader, tc.traceParent())
+			if tc.state != "" {
+				w.Header().Set(traceStateHeader, tc.state)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type parseTraceParentTest struct {
+	value    string
+	expected traceContext
+	valid    bool
+}
+
+var parseTraceParentTestList = []parseTraceParentTest{
+	{ // example from the spec
+		value:    "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		expected: traceContext{traceID: "0af7651916cd43dd8448eb211c80319c", parentID: "b7ad6b7169203331", flags: "01"},
+		valid:    true,
+	},
+	{ // future version with additional fields
+		value:    "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00-extra",
+		expected: traceContext{traceID: "0af7651916cd43dd8448eb211c80319c", parentID: "b7ad6b7169203331", flags: "00"},
+		valid:    true,
+	},
+	{value: "", valid: false},
+	{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", valid: false}, // version 00 has exactly 4 fields
+	{value: "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", valid: false},       // forbidden version
+	{value: "00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", valid: false},       // uppercase
+	{value: "00-00000000000000000000000000000000-b7ad6b7169203331-01", valid: false},       // zero trace id
+	{value: "00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", valid: false},       // zero parent id
+	{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b716920333-01", valid: false},        // short parent id
+	{value: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1", valid: false},        // short flags
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for i, test := range parseTraceParentTestList {
+		result, valid := parseTraceParent(test.value)
+		if valid != test.valid {
+			t.Fatalf("Expected validity '%v' but got '%v' for test '%v' with value '%v'\n", test.valid, valid, i, test.value)
+		}
+		if result != test.expected {
+			t.Fatalf("Expected '%+v' but got '%+v' for test '%v' with value '%v'\n", test.expected, result, i, test.value)
+		}
+	}
+}
+
+var requestIDTests = []struct {
+	requestID string
+	valid     bool
+}{
+	{requestID: "client-provided", valid: true},
+	{requestID: "0af76519.16cd_43dd-8448", valid: true},
+	{requestID: strings.Repeat("a", maxRequestIDLength), valid: true},
+	{requestID: strings.Repeat("a", maxRequestIDLength+1), valid: false},
+	{requestID: "", valid: false},
+	{requestID: "id\nGET / 10.0.0.1", valid: false},
+	{requestID: "id with spaces", valid: false},
+	{requestID: "ïd", valid: false},
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	handler := tracing(func() string { return "generated" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, test := range requestIDTests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(requestIDHeader, test.requestID)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		expected := "generated"
+		if test.valid {
+			expected = test.requestID
+		}
+		if w.Header().Get(requestIDHeader) != expected {
+			t.Fatalf("Test '%v': expected request ID '%v', got '%v'\n", i, expected, w.Header().Get(requestIDHeader))
+		}
+	}
+}
+
+func TestNextRequestIDUniqueness(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := nextRequestID()
+		if seen[id] {
+			t.Fatalf("Request ID '%v' was generated twice after '%v' iterations\n", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	var received traceContext
+	handler := tracing(nextRequestID)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = traceContextFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(traceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r.Header.Set(traceStateHeader, "congo=t61rcWkgMzE")
+	r.Header.Set(requestIDHeader, "client-provided")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if received.traceID != "0af7651916cd43dd8448eb211c80319c" || received.parentID != "b7ad6b7169203331" {
+		t.Fatalf("Expected incoming trace to be continued, got '%+v'\n", received)
+	}
+	if w.Header().Get(requestIDHeader) != "client-provided" {
+		t.Fatalf("Expected client request ID to be echoed, got '%v'\n", w.Header().Get(requestIDHeader))
+	}
+	expectedParent := "00-0af7651916cd43dd8448eb211c80319c-" + received.spanID + "-01"
+	if w.Header().Get(traceParentHeader) != expectedParent {
+		t.Fatalf("Expected traceparent '%v', got '%v'\n", expectedParent, w.Header().Get(traceParentHeader))
+	}
+	if w.Header().Get(traceStateHeader) != "congo=t61rcWkgMzE" {
+		t.Fatalf("Expected tracestate to be propagated, got '%v'\n", w.Header().Get(traceStateHeader))
+	}
+
+	// without incoming headers, a new trace is started
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if _, ok := parseTraceParent(w.Header().Get(traceParentHeader)); !ok {
+		t.Fatalf("Expected a valid traceparent for a new trace, got '%v'\n", w.Header().Get(traceParentHeader))
+	}
+	if strings.TrimSpace(w.Header().Get(requestIDHeader)) == "" {
+		t.Fatal("Expected a request ID to be generated")
+	}
+}
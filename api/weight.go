@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* Number of units a request counts for in the moving window. Errors are reported to the client, and the request is not
+counted. Weights must be positive.
+Weights let the window track units such as cost or bytes instead of requests, e.g. for traffic that costs more than
+other traffic, such as bulk exports.
+*/
+type WeightFunc func(r *http.Request) (int, error)
+
+/* Every request counts for one unit. This is the default.
+ */
+func UnitWeight(r *http.Request) (int, error) {
+	return 1, nil
+}
+
+/* Weight taken from the given request header. Requests without the header count for one unit.
+ */
+func HeaderWeight(name string) WeightFunc {
+	return func(r *http.Request) (int, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return 1, nil
+		}
+		return parseWeight(value, "header '"+name+"'")
+	}
+}
+
+/* Weight taken from the given query parameter. Requests without the parameter count for one unit.
+ */
+func QueryWeight(name string) WeightFunc {
+	return func(r *http.Request) (int, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return 1, nil
+		}
+		return parseWeight(value, "query parameter '"+name+"'")
+	}
+}
+
+/* Weight given by the size of the request, as announced by its Content-Length. Requests without a body count for one
+unit. The size of the response is not available: the response is only written once the request has been counted.
+*/
+func RequestBytesWeight(r *http.Request) (int, error) {
+	if r.ContentLength <= 0 {
+		return 1, nil
+	}
+	return int(r.ContentLength), nil
+}
+
+func parseWeight(value string, source string) (int, error) {
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight <= 0 {
+		return 0, errBadRequest.withMessage("The weight of %v must be a positive integer, got '%v'", source, value)
+	}
+	return weight, nil
+}
+
+/* Parses the specification of a weight function as given on the command line:
+- 'unit': every request counts for one unit
+- 'header:<name>': the weight is taken from the request header
+- 'query:<name>': the weight is taken from the query parameter
+- 'request-bytes': the weight is the size of the request body
+*/
+func ParseWeight(spec string) (WeightFunc, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "" || spec == "unit":
+		return UnitWeight, nil
+	case spec == "request-bytes":
+		return RequestBytesWeight, nil
+	case kind == "header" && name != "":
+		return HeaderWeight(name), nil
+	case kind == "query" && name != "":
+		return QueryWeight(name), nil
+	}
+	return nil, fmt.Errorf("unknown weight '%v': expected 'unit', 'header:<name>', 'query:<name>' or 'request-bytes'", spec)
+}
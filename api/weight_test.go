@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type weightTest struct {
+	spec           string
+	target         string
+	header         string // value of the 'X-Cost' header, if any
+	body           string
+	expectedWeight int
+	expectedErr    bool
+}
+
+var weightTestList = []weightTest{
+	{spec: "", target: "/", expectedWeight: 1},
+	{spec: "unit", target: "/?cost=10", header: "10", expectedWeight: 1},
+	{spec: "header:X-Cost", target: "/", header: "25", expectedWeight: 25},
+	{spec: "header:X-Cost", target: "/", expectedWeight: 1},
+	{spec: "header:X-Cost", target: "/", header: "0", expectedErr: true},
+	{spec: "header:X-Cost", target: "/", header: "many", expectedErr: true},
+	{spec: "query:cost", target: "/?cost=7", expectedWeight: 7},
+	{spec: "query:cost", target: "/?cost=-7", expectedErr: true},
+	{spec: "request-bytes", target: "/", body: "12345", expectedWeight: 5},
+	{spec: "request-bytes", target: "/", expectedWeight: 1},
+}
+
+func TestWeight(t *testing.T) {
+	for i, test := range weightTestList {
+		weight, err := ParseWeight(test.spec)
+		if err != nil {
+			t.Fatalf("Could not parse weight '%v' for test '%v': %v\n", test.spec, i, err)
+		}
+		r := httptest.NewRequest("GET", test.target, strings.NewReader(test.body))
+		if test.header != "" {
+			r.Header.Set("X-Cost", test.header)
+		}
+		result, err := weight(r)
+		if (err != nil) != test.expectedErr || result != test.expectedWeight {
+			t.Fatalf("Expected weight '%v' (error: %v) but got '%v' (error: %v) for test '%v'\n", test.expectedWeight, test.expectedErr, result, err, i)
+		}
+	}
+
+	for _, spec := range []string{"header", "header:", "bytes", "query"} {
+		if _, err := ParseWeight(spec); err == nil {
+			t.Fatalf("Expected weight '%v' not to be parsable\n", spec)
+		}
+	}
+}
+
+func TestIndexWeighted(t *testing.T) {
+	srv := NewServer(Environment{
+		ListenAddress:        ":5000",
+		PersistenceFile:      "NOT_SET",
+		Precision:            time.Second,
+		PersistenceTimeFrame: time.Minute,
+		Weight:               HeaderWeight("X-Cost"),
+	})
+	srv.Logger.SetOutput(ioutil.Discard)
+	srv.Routes()
+	defer srv.Stop()
+
+	expected := 0
+	for i, cost := range []string{"10", "", "5", "invalid"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if cost != "" {
+			r.Header.Set("X-Cost", cost)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+
+		if cost == "invalid" {
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status '%v' for an invalid weight, got '%v'\n", http.StatusBadRequest, w.Code)
+			}
+			continue
+		}
+		weight, _ := HeaderWeight("X-Cost")(r)
+		expected += weight
+		var response Response
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.RequestCount != expected {
+			t.Fatalf("Expected count '%v' for request '%v', got '%v' (error: %v)\n", expected, i, w.Body.String(), err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"movingwindow/persistence"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* Number of requests a single node received within one unit of precision, identified by the truncated timestamp.
+ */
+type Bucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+}
+
+/* Buckets of every known node, by node ID. This is what replicas exchange with each other.
+ */
+type NodeBuckets map[string][]Bucket
+
+/* Grow-only counter per node and bucket. A node's count for a bucket can only increase, so merging the views of two
+replicas is taking the maximum of each (node, bucket) pair. The merge is commutative, associative and idempotent:
+replicas may exchange their state in any order, any number of times, and still converge to the same totals.
+
+Buckets outside the persistence time frame of the newest reference seen are discarded on every Total() call, the same
+way UpdateTotals() does for the local RequestCounter.
+
+The counter is safe for concurrent usage.
+*/
+type GCounter struct {
+	mu    sync.RWMutex
+	nodes map[string]map[int64]int
+}
+
+func NewGCounter() *GCounter {
+	return &GCounter{nodes: make(map[string]map[int64]int)}
+}
+
+/* Merges the provided buckets into the counter. Buckets of the excluded node are ignored: a replica is the only source
+of truth for its own counts.
+*/
+func (g *GCounter) Merge(state NodeBuckets, exclude string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for node, buckets := range state {
+		if node == exclude {
+			continue
+		}
+		known, ok := g.nodes[node]
+		if !ok {
+			known = make(map[int64]int)
+			g.nodes[node] = known
+		}
+		for _, bucket := range buckets {
+			key := bucket.Timestamp.UnixNano()
+			if bucket.Count > known[key] {
+				known[key] = bucket.Count
+			}
+		}
+	}
+}
+
+/* Copy of the buckets of all nodes, oldest first.
+ */
+func (g *GCounter) State() NodeBuckets {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	state := make(NodeBuckets, len(g.nodes))
+	for node, known := range g.nodes {
+		buckets := make([]Bucket, 0, len(known))
+		for key, count := range known {
+			buckets = append(buckets, Bucket{Timestamp: time.Unix(0, key).UTC(), Count: count})
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].Timestamp.Before(buckets[j].Timestamp) })
+		state[node] = buckets
+	}
+	return state
+}
+
+/* Total of requests per node within the time frame before the reference. Buckets that are too old are discarded, and
+so are nodes that have no buckets left.
+*/
+func (g *GCounter) Totals(reference time.Time, timeFrame time.Duration, precision time.Duration) map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ref := persistence.RequestCount{Timestamp: reference}
+	totals := make(map[string]int, len(g.nodes))
+	for node, known := range g.nodes {
+		for key, count := range known {
+			bucket := persistence.RequestCount{Timestamp: time.Unix(0, key)}
+			if within, _ := bucket.WithinDurationBefore(timeFrame, precision, ref); within {
+				totals[node] += count
+			} else {
+				delete(known, key)
+			}
+		}
+		if len(known) == 0 {
+			delete(g.nodes, node)
+		}
+	}
+	return totals
+}
+
+/* Sum of the requests of all nodes within the time frame before the reference.
+ */
+func (g *GCounter) Total(reference time.Time, timeFrame time.Duration, precision time.Duration) int {
+	total := 0
+	for _, count := range g.Totals(reference, timeFrame, precision) {
+		total += count
+	}
+	return total
+}
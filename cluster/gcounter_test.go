@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2006, 01, 02, 15, 04, 05, 0, time.UTC)
+
+func TestGCounterMerge(t *testing.T) {
+	a := NodeBuckets{
+		"a": {{Timestamp: t0, Count: 3}, {Timestamp: t0.Add(time.Second), Count: 1}},
+		"b": {{Timestamp: t0, Count: 2}},
+	}
+	b := NodeBuckets{
+		"a":    {{Timestamp: t0, Count: 1}},                                             // stale view of a
+		"b":    {{Timestamp: t0, Count: 5}, {Timestamp: t0.Add(time.Second), Count: 4}}, // newer view of b
+		"self": {{Timestamp: t0, Count: 100}},
+	}
+	expected := NodeBuckets{
+		"a": {{Timestamp: t0, Count: 3}, {Timestamp: t0.Add(time.Second), Count: 1}},
+		"b": {{Timestamp: t0, Count: 5}, {Timestamp: t0.Add(time.Second), Count: 4}},
+	}
+
+	// merge order and repetitions must not matter
+	for i, order := range [][]NodeBuckets{{a, b}, {b, a}, {a, b, a, b}} {
+		counter := NewGCounter()
+		for _, state := range order {
+			counter.Merge(state, "self")
+		}
+		if !reflect.DeepEqual(counter.State(), expected) {
+			t.Fatalf("Expected state '%v' but got '%v' for merge order '%v'\n", expected, counter.State(), i)
+		}
+	}
+}
+
+type gCounterTotalsTest struct {
+	reference time.Time
+	timeFrame time.Duration
+	expected  map[string]int
+}
+
+var gCounterTotalsTestList = []gCounterTotalsTest{
+	{reference: t0.Add(2 * time.Second), timeFrame: time.Minute, expected: map[string]int{"a": 6, "b": 1}},
+	{reference: t0.Add(2 * time.Second), timeFrame: time.Second, expected: map[string]int{"a": 5, "b": 1}},
+	{reference: t0.Add(3 * time.Second), timeFrame: time.Second, expected: map[string]int{"a": 3}},
+	{reference: t0.Add(time.Minute), timeFrame: time.Second, expected: map[string]int{}},
+}
+
+func TestGCounterTotals(t *testing.T) {
+	for i, test := range gCounterTotalsTestList {
+		counter := NewGCounter()
+		counter.Merge(NodeBuckets{
+			"a": {{Timestamp: t0, Count: 1}, {Timestamp: t0.Add(time.Second), Count: 2}, {Timestamp: t0.Add(2 * time.Second), Count: 3}},
+			"b": {{Timestamp: t0.Add(time.Second), Count: 1}},
+		}, "")
+
+		totals := counter.Totals(test.reference, test.timeFrame, time.Second)
+		if !reflect.DeepEqual(totals, test.expected) {
+			t.Fatalf("Expected totals '%v' but got '%v' for test '%v'\n", test.expected, totals, i)
+		}
+		// buckets outside of the time frame are gone for good
+		if len(counter.State()) != len(test.expected) {
+			t.Fatalf("Expected '%v' nodes to be left after computing totals, got '%v' for test '%v'\n", len(test.expected), len(counter.State()), i)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* Liveness of a member as seen by the local node.
+ */
+type MemberState string
+
+const (
+	StateAlive   MemberState = "alive"
+	StateSuspect MemberState = "suspect"
+	StateDead    MemberState = "dead"
+)
+
+/* A node of the cluster:
+- ID: unique identifier, the same that is used for replication
+- Address: UDP address on which the node gossips
+- URL: base URL of the HTTP server of the node, from which its request counts are replicated
+- Incarnation: only ever increased by the node itself, to refute suspicions about it
+*/
+type Member struct {
+	ID          string      `json:"id"`
+	Address     string      `json:"address"`
+	URL         string      `json:"url"`
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"incarnation"`
+}
+
+type messageType string
+
+const (
+	messagePing    messageType = "ping"
+	messagePingReq messageType = "ping-req"
+	messageAck     messageType = "ack"
+	messageLeave   messageType = "leave"
+)
+
+/* Every message carries the sender and the member list of the sender, so that membership changes spread with the
+failure detection traffic itself - there are no dedicated gossip messages. For the small clusters this is meant for,
+sending the whole list is cheaper than keeping track of what has been gossiped already.
+*/
+type message struct {
+	Type    messageType `json:"type"`
+	Seq     uint64      `json:"seq"`
+	Target  string      `json:"target,omitempty"`
+	From    Member      `json:"from"`
+	Members []Member    `json:"members"`
+}
+
+type memberEntry struct {
+	Member
+	changed time.Time
+}
+
+/* Forwarding information for ping-req messages: the ack of the target must reach the requester under its sequence.
+ */
+type forward struct {
+	requester *net.UDPAddr
+	seq       uint64
+	expires   time.Time
+}
+
+/* SWIM-style membership (Das, Gupta, Motivala - 2002) on top of UDP.
+Every interval, a random member is pinged. Without an ack within half the interval, up to 'indirectChecks' other
+members are asked to ping it on our behalf. Without any ack by the end of the interval, the member becomes suspect.
+Suspects that do not refute the suspicion - by gossiping a higher incarnation - within 'suspicionTimeout' are declared
+dead. Dead members are forgotten after 'reapTimeout', by which time the news should have reached everyone.
+
+Seeds are UDP addresses of members to join through. They are pinged until they have been heard from.
+With a key, every message is signed with HMAC-SHA256, and messages that are not signed with the same key are dropped:
+only members sharing the key can join, or tell about the URLs of others. Without one, anyone able to send a UDP packet
+to the member can.
+*/
+type Membership struct {
+	mu               sync.Mutex
+	self             Member
+	members          map[string]*memberEntry
+	seeds            []string
+	interval         time.Duration
+	suspicionTimeout time.Duration
+	reapTimeout      time.Duration
+	indirectChecks   int
+	key              []byte
+	conn             *net.UDPConn
+	seq              uint64
+	pending          map[uint64]chan struct{}
+	forwards         map[uint64]forward
+	logger           *log.Logger
+	stop             chan struct{}
+	goroutines       sync.WaitGroup
+}
+
+func NewMembership(id string, address string, url string, seeds []string, interval time.Duration, key []byte, logger *log.Logger) *Membership {
+	return &Membership{
+		self:             Member{ID: id, Address: address, URL: url, State: StateAlive},
+		members:          make(map[string]*memberEntry),
+		seeds:            seeds,
+		interval:         interval,
+		suspicionTimeout: 3 * interval,
+		reapTimeout:      10 * interval,
+		indirectChecks:   3,
+		key:              key,
+		pending:          make(map[uint64]chan struct{}),
+		forwards:         make(map[uint64]forward),
+		logger:           logger,
+		stop:             make(chan struct{}),
+	}
+}
+
+/* Binds the UDP socket and starts gossiping. The address of the local member is updated to the bound one, which
+allows binding to port 0.
+*/
+func (m *Membership) Start() error {
+	address, err := net.ResolveUDPAddr("udp", m.self.Address)
+	if err != nil {
+		return err
+	}
+	m.conn, err = net.ListenUDP("udp", address)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.self.Address = m.conn.LocalAddr().String()
+	m.mu.Unlock()
+	m.logger.Printf("Gossiping on '%v', joining through '%v'...\n", m.Address(), m.seeds)
+
+	m.goroutines.Add(2)
+	go m.receive()
+	go m.probe()
+	return nil
+}
+
+/* Announces the departure of the local member to all others and stops gossiping. Harmless if never started.
+ */
+func (m *Membership) Stop() {
+	if m.conn == nil {
+		return
+	}
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+
+	m.mu.Lock()
+	m.self.State = StateDead
+	leave := m.newMessage(messageLeave, 0, "")
+	targets := m.aliveAddresses()
+	m.mu.Unlock()
+	for _, target := range targets {
+		m.send(target, leave)
+	}
+
+	close(m.stop)
+	m.conn.Close()
+	m.goroutines.Wait()
+}
+
+func (m *Membership) Address() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self.Address
+}
+
+/* Copy of the member list, including the local member, sorted by ID.
+ */
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := []Member{m.self}
+	for _, entry := range m.members {
+		members = append(members, entry.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+/* Whether messages are signed, i.e. whether members can be trusted to be part of the cluster.
+ */
+func (m *Membership) Authenticated() bool {
+	return len(m.key) > 0
+}
+
+/* Base URLs of the members that are not known to be dead. Suspects are included: they may well be alive.
+ */
+func (m *Membership) LiveURLs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	urls := make([]string, 0, len(m.members))
+	for _, entry := range m.members {
+		if entry.State != StateDead && entry.URL != "" {
+			urls = append(urls, entry.URL)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+/* Applies what is known about a member to the local view, following the SWIM precedence rules:
+- alive overrides alive and suspect with a lower incarnation
+- suspect overrides alive with the same or a lower incarnation, and suspect with a lower one
+- dead overrides anything with the same or a lower incarnation
+Suspicions and death notices about the local member are refuted by increasing its incarnation. Must be called with the
+lock held.
+*/
+func (m *Membership) apply(member Member, now time.Time) {
+	if member.ID == m.self.ID {
+		if member.State != StateAlive && member.Incarnation >= m.self.Incarnation && m.self.State == StateAlive {
+			m.self.Incarnation = member.Incarnation + 1
+		}
+		return
+	}
+
+	entry, known := m.members[member.ID]
+	if !known {
+		if member.State == StateDead {
+			return
+		}
+		m.members[member.ID] = &memberEntry{Member: member, changed: now}
+		m.logger.Printf("Member '%v' joined at '%v' as '%v'\n", member.ID, member.Address, member.State)
+		return
+	}
+
+	override := false
+	switch member.State {
+	case StateAlive:
+		override = member.Incarnation > entry.Incarnation
+	case StateSuspect:
+		override = member.Incarnation > entry.Incarnation ||
+			member.Incarnation == entry.Incarnation && entry.State == StateAlive
+	case StateDead:
+		override = member.Incarnation >= entry.Incarnation && entry.State != StateDead
+	}
+	if !override {
+		return
+	}
+	if member.State != entry.State {
+		m.logger.Printf("Member '%v' is now '%v'\n", member.ID, member.State)
+	}
+	entry.Member = member
+	entry.changed = now
+}
+
+/* Must be called with the lock held.
+ */
+func (m *Membership) newMessage(messageType messageType, seq uint64, target string) message {
+	members := make([]Member, 0, len(m.members))
+	for _, entry := range m.members {
+		members = append(members, entry.Member)
+	}
+	return message{Type: messageType, Seq: seq, Target: target, From: m.self, Members: members}
+}
+
+/* Must be called with the lock held.
+ */
+func (m *Membership) aliveAddresses() []string {
+	addresses := make([]string, 0, len(m.members))
+	for _, entry := range m.members {
+		if entry.State == StateAlive {
+			addresses = append(addresses, entry.Address)
+		}
+	}
+	return addresses
+}
+
+func (m *Membership) send(address string, msg message) {
+	target, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		m.logger.Printf("Could not resolve member address '%v': %v\n", address, err)
+		return
+	}
+	m.sendTo(target, msg)
+}
+
+/* Signature of an encoded message, prepended to it on the wire.
+ */
+func (m *Membership) sign(encoded []byte) []byte {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write(encoded)
+	return mac.Sum(nil)
+}
+
+/* Encoded message of a packet, if it is signed with the key. Packets are taken as they come without a key.
+ */
+func (m *Membership) verify(packet []byte) ([]byte, bool) {
+	if !m.Authenticated() {
+		return packet, true
+	}
+	if len(packet) < sha256.Size {
+		return nil, false
+	}
+	signature, encoded := packet[:sha256.Size], packet[sha256.Size:]
+	return encoded, hmac.Equal(signature, m.sign(encoded))
+}
+
+func (m *Membership) sendTo(target *net.UDPAddr, msg message) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		m.logger.Printf("Could not encode gossip message: %v\n", err)
+		return
+	}
+	if m.Authenticated() {
+		encoded = append(m.sign(encoded), encoded...)
+	}
+	if _, err := m.conn.WriteToUDP(encoded, target); err != nil {
+		select {
+		case <-m.stop:
+		default:
+			m.logger.Printf("Could not send gossip message to '%v': %v\n", target, err)
+		}
+	}
+}
+
+func (m *Membership) receive() {
+	defer m.goroutines.Done()
+	buffer := make([]byte, 65536)
+	for {
+		n, sender, err := m.conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-m.stop:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			m.logger.Printf("Could not read gossip message: %v\n", err)
+			continue
+		}
+
+		encoded, valid := m.verify(buffer[:n])
+		if !valid {
+			m.logger.Printf("Discarding gossip message from '%v': not signed with the key of the cluster\n", sender)
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(encoded, &msg); err != nil {
+			m.logger.Printf("Discarding malformed gossip message from '%v': %v\n", sender, err)
+			continue
+		}
+		m.handle(msg, sender)
+	}
+}
+
+/* Members bound to an unspecified address, e.g. ':7946', do not know which of their addresses others can reach. The
+address the message came from is used instead.
+*/
+func resolveSender(member Member, sender *net.UDPAddr) Member {
+	host, port, err := net.SplitHostPort(member.Address)
+	if err != nil {
+		return member
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		member.Address = net.JoinHostPort(sender.IP.String(), port)
+	}
+	return member
+}
+
+func (m *Membership) handle(msg message, sender *net.UDPAddr) {
+	now := time.Now()
+	msg.From = resolveSender(msg.From, sender)
+	m.mu.Lock()
+	m.apply(msg.From, now)
+	for _, member := range msg.Members {
+		m.apply(member, now)
+	}
+
+	var reply *message
+	var replyTo *net.UDPAddr
+	var relay string
+	switch msg.Type {
+	case messagePing:
+		ack := m.newMessage(messageAck, msg.Seq, "")
+		reply, replyTo = &ack, sender
+	case messagePingReq:
+		m.seq++
+		m.forwards[m.seq] = forward{requester: sender, seq: msg.Seq, expires: now.Add(m.interval)}
+		ping := m.newMessage(messagePing, m.seq, "")
+		reply, relay = &ping, msg.Target
+	case messageAck:
+		if acked, ok := m.pending[msg.Seq]; ok {
+			select {
+			case acked <- struct{}{}:
+			default:
+			}
+			delete(m.pending, msg.Seq)
+		}
+		if fwd, ok := m.forwards[msg.Seq]; ok {
+			delete(m.forwards, msg.Seq)
+			ack := m.newMessage(messageAck, fwd.seq, "")
+			reply, replyTo = &ack, fwd.requester
+		}
+	}
+	m.mu.Unlock()
+
+	if reply != nil && replyTo != nil {
+		m.sendTo(replyTo, *reply)
+	} else if reply != nil && relay != "" {
+		m.send(relay, *reply)
+	}
+}
+
+/* Sends a ping - or a ping-req for the target - to the address. The ack will be signalled on the provided channel,
+which must be buffered. Several pings may share the same channel.
+*/
+func (m *Membership) ping(address string, messageType messageType, target string, acked chan struct{}) {
+	m.mu.Lock()
+	m.seq++
+	m.pending[m.seq] = acked
+	msg := m.newMessage(messageType, m.seq, target)
+	m.mu.Unlock()
+
+	m.send(address, msg)
+}
+
+/* Stops waiting for acks on the channel.
+ */
+func (m *Membership) forget(acked chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for seq, pending := range m.pending {
+		if pending == acked {
+			delete(m.pending, seq)
+		}
+	}
+}
+
+func (m *Membership) probe() {
+	defer m.goroutines.Done()
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.probeRound()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+/* One protocol period: join through seeds that have not been heard from, check a random member and age the states of
+suspect and dead members.
+*/
+func (m *Membership) probeRound() {
+	m.mu.Lock()
+	known := make(map[string]bool, len(m.members))
+	candidates := make([]Member, 0, len(m.members))
+	for _, entry := range m.members {
+		known[entry.Address] = true
+		if entry.State != StateDead {
+			candidates = append(candidates, entry.Member)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, seed := range m.seeds {
+		if !known[seed] && seed != m.Address() {
+			// the ack is not waited for: it is the member list that comes with it that matters
+			m.mu.Lock()
+			join := m.newMessage(messagePing, 0, "")
+			m.mu.Unlock()
+			m.send(seed, join)
+		}
+	}
+
+	if len(candidates) > 0 {
+		target := candidates[rand.Intn(len(candidates))]
+		if !m.check(target, candidates) {
+			m.mu.Lock()
+			m.apply(Member{ID: target.ID, Address: target.Address, URL: target.URL, State: StateSuspect, Incarnation: target.Incarnation}, time.Now())
+			m.mu.Unlock()
+		}
+	}
+
+	m.age(time.Now())
+}
+
+/* Pings the target directly and, failing that, through other members. Reports whether an ack came in time.
+ */
+func (m *Membership) check(target Member, candidates []Member) bool {
+	acked := make(chan struct{}, 1)
+	defer m.forget(acked)
+
+	m.ping(target.Address, messagePing, "", acked)
+	select {
+	case <-acked:
+		return true
+	case <-time.After(m.interval / 2):
+	case <-m.stop:
+		return true
+	}
+
+	helpers := 0
+	for _, i := range rand.Perm(len(candidates)) {
+		if helpers == m.indirectChecks {
+			break
+		}
+		if candidates[i].ID == target.ID || candidates[i].State != StateAlive {
+			continue
+		}
+		m.ping(candidates[i].Address, messagePingReq, target.Address, acked)
+		helpers++
+	}
+
+	select {
+	case <-acked:
+		return true
+	case <-time.After(m.interval / 2):
+		return false
+	case <-m.stop:
+		return true
+	}
+}
+
+/* Declares suspects dead once their suspicion timed out, forgets dead members once the news had time to spread and
+drops stale ping-req forwards.
+*/
+func (m *Membership) age(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, entry := range m.members {
+		switch {
+		case entry.State == StateSuspect && now.Sub(entry.changed) > m.suspicionTimeout:
+			m.logger.Printf("Member '%v' is now '%v'\n", id, StateDead)
+			entry.State = StateDead
+			entry.changed = now
+		case entry.State == StateDead && now.Sub(entry.changed) > m.reapTimeout:
+			delete(m.members, id)
+		}
+	}
+	for seq, fwd := range m.forwards {
+		if now.After(fwd.expires) {
+			delete(m.forwards, seq)
+		}
+	}
+}
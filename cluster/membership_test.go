@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+var discard = log.New(ioutil.Discard, "", 0)
+
+type applyTest struct {
+	known    *Member // nil if the member is not known yet
+	received Member
+	expected *Member // nil if the member should not be known afterwards
+}
+
+var applyTestList = []applyTest{
+	{ // new members join
+		received: Member{ID: "a", State: StateAlive},
+		expected: &Member{ID: "a", State: StateAlive},
+	},
+	{ // dead members are not added
+		received: Member{ID: "a", State: StateDead},
+	},
+	{ // suspicion with the same incarnation
+		known:    &Member{ID: "a", State: StateAlive, Incarnation: 1},
+		received: Member{ID: "a", State: StateSuspect, Incarnation: 1},
+		expected: &Member{ID: "a", State: StateSuspect, Incarnation: 1},
+	},
+	{ // stale suspicion
+		known:    &Member{ID: "a", State: StateAlive, Incarnation: 2},
+		received: Member{ID: "a", State: StateSuspect, Incarnation: 1},
+		expected: &Member{ID: "a", State: StateAlive, Incarnation: 2},
+	},
+	{ // alive with the same incarnation does not clear a suspicion
+		known:    &Member{ID: "a", State: StateSuspect, Incarnation: 1},
+		received: Member{ID: "a", State: StateAlive, Incarnation: 1},
+		expected: &Member{ID: "a", State: StateSuspect, Incarnation: 1},
+	},
+	{ // refutation
+		known:    &Member{ID: "a", State: StateSuspect, Incarnation: 1},
+		received: Member{ID: "a", State: StateAlive, Incarnation: 2},
+		expected: &Member{ID: "a", State: StateAlive, Incarnation: 2},
+	},
+	{ // death
+		known:    &Member{ID: "a", State: StateSuspect, Incarnation: 1},
+		received: Member{ID: "a", State: StateDead, Incarnation: 1},
+		expected: &Member{ID: "a", State: StateDead, Incarnation: 1},
+	},
+	{ // the dead do not come back with the same incarnation
+		known:    &Member{ID: "a", State: StateDead, Incarnation: 1},
+		received: Member{ID: "a", State: StateAlive, Incarnation: 1},
+		expected: &Member{ID: "a", State: StateDead, Incarnation: 1},
+	},
+}
+
+func TestMembershipApply(t *testing.T) {
+	for i, test := range applyTestList {
+		m := NewMembership("self", "127.0.0.1:0", "", nil, time.Second, nil, discard)
+		if test.known != nil {
+			m.members[test.known.ID] = &memberEntry{Member: *test.known}
+		}
+		m.apply(test.received, time.Now())
+
+		entry, known := m.members[test.received.ID]
+		if test.expected == nil && known {
+			t.Fatalf("Expected member not to be known for test '%v', got '%+v'\n", i, entry.Member)
+		}
+		if test.expected != nil && (!known || entry.Member != *test.expected) {
+			t.Fatalf("Expected member '%+v' for test '%v', got '%+v'\n", *test.expected, i, entry)
+		}
+	}
+}
+
+func TestMembershipRefutesSuspicion(t *testing.T) {
+	m := NewMembership("self", "127.0.0.1:0", "", nil, time.Second, nil, discard)
+	m.apply(Member{ID: "self", State: StateSuspect, Incarnation: 0}, time.Now())
+	if m.self.Incarnation != 1 || m.self.State != StateAlive {
+		t.Fatalf("Expected suspicion to be refuted with a higher incarnation, got '%+v'\n", m.self)
+	}
+}
+
+/* Waits until every membership sees the expected state for the given member.
+ */
+func waitForState(t *testing.T, memberships []*Membership, id string, expected MemberState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, m := range memberships {
+		for {
+			state := MemberState("")
+			for _, member := range m.Members() {
+				if member.ID == id {
+					state = member.State
+				}
+			}
+			if state == expected {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Member '%v' is seen as '%v' by '%v', expected '%v'\n", id, state, m.self.ID, expected)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestMembershipLoopback(t *testing.T) {
+	interval := 20 * time.Millisecond
+	seed := NewMembership("a", "127.0.0.1:0", "http://a", nil, interval, nil, discard)
+	if err := seed.Start(); err != nil {
+		t.Fatalf("Could not start seed member: %v\n", err)
+	}
+	defer seed.Stop()
+
+	memberships := []*Membership{seed}
+	for _, id := range []string{"b", "c", "d"} {
+		m := NewMembership(id, "127.0.0.1:0", "http://"+id, []string{seed.Address()}, interval, nil, discard)
+		if err := m.Start(); err != nil {
+			t.Fatalf("Could not start member '%v': %v\n", id, err)
+		}
+		memberships = append(memberships, m)
+	}
+
+	// discovery: everyone gets to know everyone through the seed
+	for _, id := range []string{"a", "b", "c", "d"} {
+		waitForState(t, memberships, id, StateAlive)
+	}
+	if urls := seed.LiveURLs(); len(urls) != 3 {
+		t.Fatalf("Expected the seed to know the URLs of '3' live members, got '%v'\n", urls)
+	}
+
+	// graceful departure
+	memberships[3].Stop()
+	waitForState(t, memberships[:3], "d", StateDead)
+
+	// failure detection: the member disappears without notice
+	crashed := memberships[2]
+	close(crashed.stop)
+	crashed.conn.Close()
+	crashed.goroutines.Wait()
+	waitForState(t, memberships[:2], "c", StateDead)
+
+	memberships[1].Stop()
+}
+
+/* Members signing with another key, or not at all, are not heard from.
+ */
+func TestMembershipKey(t *testing.T) {
+	interval := 20 * time.Millisecond
+	seed := NewMembership("a", "127.0.0.1:0", "http://a", nil, interval, []byte("secret"), discard)
+	if err := seed.Start(); err != nil {
+		t.Fatalf("Could not start seed member: %v\n", err)
+	}
+	defer seed.Stop()
+
+	keys := map[string][]byte{"b": []byte("secret"), "c": []byte("other"), "d": nil}
+	for _, id := range []string{"b", "c", "d"} {
+		m := NewMembership(id, "127.0.0.1:0", "http://"+id, []string{seed.Address()}, interval, keys[id], discard)
+		if err := m.Start(); err != nil {
+			t.Fatalf("Could not start member '%v': %v\n", id, err)
+		}
+		defer m.Stop()
+	}
+
+	waitForState(t, []*Membership{seed}, "b", StateAlive)
+	// give the others as many protocol periods to join
+	time.Sleep(10 * interval)
+	if urls := seed.LiveURLs(); len(urls) != 1 || urls[0] != "http://b" {
+		t.Fatalf("Expected only the member sharing the key to join, got '%v'\n", urls)
+	}
+}
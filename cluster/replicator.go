@@ -0,0 +1,221 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Path under which every replica serves its view of the cluster.
+ */
+const ReplicationPath = "/replication"
+
+/* View of the cluster as served by a replica: its own buckets, along with those it learnt from other replicas.
+ */
+type ReplicationState struct {
+	Node  string      `json:"node"`
+	Nodes NodeBuckets `json:"nodes"`
+}
+
+/* Periodically pulls the view of every peer and merges it into the counter. Pulling full views - instead of only the
+peers' own buckets - lets counts travel across replicas that do not know each other directly.
+Peers are base URLs, e.g. 'http://10.0.0.2:5000'. They are made up of the statically configured ones and, if a
+membership is provided, of the live members it knows about at the time of each round. Unreachable peers are logged and
+retried on the next round; their counts age out of the window like any other, and so do those of departed members.
+Peers that require authentication are presented the token as a bearer token. Empty presents none. The token is only
+presented to static peers and, if the membership is authenticated, to its members: members of an unauthenticated
+membership may have been made up by anyone able to send it a packet, see Membership.
+The sum of the requests replicated within the time frame is computed once per round, after its pulls, so that reading
+it with Total() neither waits for the counter nor depends on its size.
+Peers serving HTTPS are verified and presented a client certificate as set by SetTLSConfig, or else verified against
+the certificate authorities of the system.
+*/
+type Replicator struct {
+	NodeID     string
+	peers      []string
+	membership *Membership
+	interval   time.Duration
+	timeFrame  time.Duration
+	precision  time.Duration
+	total      int64
+	client     *http.Client
+	clientLock sync.Mutex
+	token      string
+	counter    *GCounter
+	logger     *log.Logger
+	cancel     context.CancelFunc
+	done       chan struct{}
+	once       sync.Once
+}
+
+func NewReplicator(nodeID string, peers []string, membership *Membership, interval time.Duration, timeFrame time.Duration, precision time.Duration, token string, logger *log.Logger) *Replicator {
+	trimmed := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+			trimmed = append(trimmed, peer)
+		}
+	}
+	return &Replicator{
+		NodeID:     nodeID,
+		peers:      trimmed,
+		membership: membership,
+		interval:   interval,
+		timeFrame:  timeFrame,
+		precision:  precision,
+		client:     &http.Client{Timeout: interval},
+		token:      token,
+		counter:    NewGCounter(),
+		logger:     logger,
+		done:       make(chan struct{}),
+	}
+}
+
+func (r *Replicator) Counter() *GCounter {
+	return r.counter
+}
+
+/* Sum of the requests of all peers within the time frame, as of the end of the latest round. Buckets that left the time
+frame since then are still part of it, for at most one interval.
+*/
+func (r *Replicator) Total() int {
+	return int(atomic.LoadInt64(&r.total))
+}
+
+/* Static peers, followed by the live members of the membership that are not static peers already.
+ */
+func (r *Replicator) Peers() []string {
+	if r.membership == nil {
+		return r.peers
+	}
+	peers := append([]string{}, r.peers...)
+	for _, url := range r.membership.LiveURLs() {
+		url = strings.TrimRight(url, "/")
+		duplicate := false
+		for _, peer := range r.peers {
+			duplicate = duplicate || peer == url
+		}
+		if !duplicate {
+			peers = append(peers, url)
+		}
+	}
+	return peers
+}
+
+/* Sets the TLS configuration peers serving HTTPS are pulled with, such as the certificate authorities their certificate
+is verified against and the client certificate presented to them over mutual TLS. Rounds that already started keep the
+previous one.
+*/
+func (r *Replicator) SetTLSConfig(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	r.clientLock.Lock()
+	previous := r.client
+	r.client = &http.Client{Timeout: r.interval, Transport: transport}
+	r.clientLock.Unlock()
+	previous.CloseIdleConnections()
+}
+
+func (r *Replicator) httpClient() *http.Client {
+	r.clientLock.Lock()
+	defer r.clientLock.Unlock()
+	return r.client
+}
+
+func (r *Replicator) Membership() *Membership {
+	return r.membership
+}
+
+/* Starts pulling from peers every interval until the context is cancelled or Stop() is called. Without static peers or
+a membership, nothing is started. Only the first call has an effect.
+*/
+func (r *Replicator) Start(ctx context.Context) {
+	r.once.Do(func() {
+		if len(r.peers) == 0 && r.membership == nil {
+			close(r.done)
+			return
+		}
+		ctx, r.cancel = context.WithCancel(ctx)
+		r.logger.Printf("Starting replication with peers '%v' every '%v'...\n", r.Peers(), r.interval)
+		go func() {
+			defer close(r.done)
+			ticker := time.NewTicker(r.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					r.pullAll(ctx)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	})
+}
+
+/* Stops pulling and waits for the current round to complete. Harmless if the replicator was never started.
+ */
+func (r *Replicator) Stop() {
+	r.once.Do(func() { close(r.done) })
+	if r.cancel != nil {
+		r.cancel()
+	}
+	<-r.done
+}
+
+/* Whether the token may be presented to the peer. See Replicator.
+ */
+func (r *Replicator) trusts(peer string) bool {
+	if r.membership != nil && r.membership.Authenticated() {
+		return true
+	}
+	for _, static := range r.peers {
+		if static == peer {
+			return true
+		}
+	}
+	return false
+}
+
+/* Pulls from every peer, then sums up the requests within the time frame, whether pulls succeeded or not, so that
+buckets of unreachable peers age out of the total.
+*/
+func (r *Replicator) pullAll(ctx context.Context) {
+	for _, peer := range r.Peers() {
+		if err := r.pull(ctx, peer, r.trusts(peer)); err != nil && ctx.Err() == nil {
+			r.logger.Printf("Could not replicate from peer '%v': %v\n", peer, err)
+		}
+	}
+	atomic.StoreInt64(&r.total, int64(r.counter.Total(time.Now(), r.timeFrame, r.precision)))
+}
+
+func (r *Replicator) pull(ctx context.Context, peer string, trusted bool) error {
+	request, err := http.NewRequest(http.MethodGet, peer+ReplicationPath, nil)
+	if err != nil {
+		return err
+	}
+	if r.token != "" && trusted {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	response, err := r.httpClient().Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status '%v'", response.Status)
+	}
+
+	var state ReplicationState
+	if err := json.NewDecoder(response.Body).Decode(&state); err != nil {
+		return err
+	}
+	r.counter.Merge(state.Nodes, r.NodeID)
+	return nil
+}
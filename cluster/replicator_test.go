@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* The token is presented to static peers, and to members only if the membership is authenticated.
+ */
+func TestReplicatorToken(t *testing.T) {
+	var mu sync.Mutex
+	presented := make(map[string]string)
+	newPeer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			presented[name] = r.Header.Get("Authorization")
+			mu.Unlock()
+			w.Write([]byte(`{"node":"` + name + `","nodes":{}}`))
+		}))
+	}
+	static, member := newPeer("static"), newPeer("member")
+	defer static.Close()
+	defer member.Close()
+
+	tests := []struct {
+		key      []byte
+		expected map[string]string
+	}{
+		{key: nil, expected: map[string]string{"static": "Bearer token", "member": ""}},
+		{key: []byte("secret"), expected: map[string]string{"static": "Bearer token", "member": "Bearer token"}},
+	}
+	for i, test := range tests {
+		membership := NewMembership("self", "127.0.0.1:0", "", nil, time.Second, test.key, discard)
+		membership.apply(Member{ID: "member", URL: member.URL, State: StateAlive}, time.Now())
+		replicator := NewReplicator("self", []string{static.URL}, membership, time.Second, time.Minute, time.Second, "token", discard)
+		replicator.pullAll(context.Background())
+
+		mu.Lock()
+		for name, expected := range test.expected {
+			if presented[name] != expected {
+				t.Fatalf("Test '%v': expected '%v' to be presented '%v', got '%v'\n", i, name, expected, presented[name])
+			}
+		}
+		mu.Unlock()
+	}
+}
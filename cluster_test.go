@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"movingwindow/api"
+	"movingwindow/cluster"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+/* Reserves a free UDP port on loopback. The port is released right away, so that a server can bind it.
+ */
+func freeUDPAddress(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen on loopback: %v\n", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+/* Starts one server per listener on loopback. Without gossip, each of them has all other servers as static peers.
+With gossip, servers have no static peers and discover each other by joining through the first one. Lazy servers are
+not initialized, they only join the cluster.
+*/
+func startCluster(t *testing.T, size int, precision time.Duration, gossip bool, lazy bool) ([]string, func()) {
+	listeners := make([]net.Listener, size)
+	urls := make([]string, size)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Could not listen on loopback: %v\n", err)
+		}
+		listeners[i] = listener
+		urls[i] = "http://" + listener.Addr().String()
+	}
+
+	seed := freeUDPAddress(t)
+	servers := make([]interface {
+		Stop()
+		Close() error
+	}, size)
+	for i, listener := range listeners {
+		peers := make([]string, 0, size-1)
+		for j, url := range urls {
+			if j != i {
+				peers = append(peers, url)
+			}
+		}
+		gossipAddress, join := "", []string(nil)
+		if gossip {
+			peers = nil
+			gossipAddress, join = freeUDPAddress(t), []string{seed}
+			if i == 0 {
+				gossipAddress, join = seed, nil
+			}
+		}
+		srv := api.NewServer(api.Environment{
+			ListenAddress:        listener.Addr().String(),
+			PersistenceFile:      "NOT_SET",
+			Precision:            precision,
+			PersistenceTimeFrame: 2 * precision,
+			NodeID:               fmt.Sprintf("node-%v", i),
+			Peers:                peers,
+			ReplicationInterval:  20 * time.Millisecond,
+			GossipAddress:        gossipAddress,
+			Join:                 join,
+			GossipInterval:       20 * time.Millisecond,
+			AdvertiseURL:         urls[i],
+		})
+		srv.Logger.SetOutput(ioutil.Discard)
+		srv.ErrorLog.SetOutput(ioutil.Discard)
+		srv.Routes()
+		if !lazy {
+			srv.Initialize()
+		}
+		srv.JoinCluster()
+		go srv.Serve(listener)
+		servers[i] = srv
+	}
+
+	return urls, func() {
+		for _, srv := range servers {
+			srv.Close()
+			srv.Stop()
+		}
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request to '%v' failed: %v\n", url, err)
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		t.Fatalf("Could not decode response of '%v': %v\n", url, err)
+	}
+}
+
+/* Polls every replica until it knows about the given amount of requests, taking into account all nodes.
+ */
+func waitForConvergence(t *testing.T, urls []string, total int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for i, url := range urls {
+		for {
+			var state cluster.ReplicationState
+			getJSON(t, url+cluster.ReplicationPath, &state)
+			known := 0
+			for _, buckets := range state.Nodes {
+				for _, bucket := range buckets {
+					known += bucket.Count
+				}
+			}
+			if known == total {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Replica '%v' did not converge: knows about '%v' of '%v' requests\n", i, known, total)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+/* Requests are spread over the replicas. Once replication has caught up, every replica must answer with the total of
+requests received by the whole cluster.
+*/
+func TestClusterReplication(t *testing.T) {
+	urls, stop := startCluster(t, 3, time.Hour, false, false)
+	defer stop()
+
+	total := 0
+	for i, numRequests := range []int{3, 2, 1} {
+		for j := 0; j < numRequests; j++ {
+			var response api.Response
+			getJSON(t, urls[i]+"/", &response)
+		}
+		total += numRequests
+	}
+
+	for i, url := range urls {
+		waitForConvergence(t, urls, total)
+		var response api.Response
+		getJSON(t, url+"/", &response)
+		total++
+		if response.RequestCount != total {
+			t.Fatalf("Expected replica '%v' to answer with the cluster-wide count '%v', got '%v'\n", i, total, response.RequestCount)
+		}
+	}
+}
+
+/* Same as TestClusterReplication, with replicas discovering each other instead of being configured as peers. The
+contribution of every member must show up in the '/cluster' endpoint of all replicas.
+*/
+func TestClusterGossip(t *testing.T) {
+	urls, stop := startCluster(t, 3, time.Hour, true, false)
+	defer stop()
+
+	hits := []int{3, 2, 1}
+	for i, numRequests := range hits {
+		for j := 0; j < numRequests; j++ {
+			var response api.Response
+			getJSON(t, urls[i]+"/", &response)
+		}
+	}
+	waitForConvergence(t, urls, 6)
+
+	for i, url := range urls {
+		var response api.ClusterResponse
+		getJSON(t, url+"/cluster", &response)
+		if response.RequestCount != 6 || len(response.Members) != len(urls) {
+			t.Fatalf("Expected replica '%v' to report '6' requests from '%v' members, got '%+v'\n", i, len(urls), response)
+		}
+		for j, member := range response.Members {
+			if member.ID != fmt.Sprintf("node-%v", j) || member.State != cluster.StateAlive || member.RequestCount != hits[j] {
+				t.Fatalf("Unexpected contribution '%+v' of member '%v' reported by replica '%v'\n", member, j, i)
+			}
+		}
+	}
+}
+
+/* Lazy replicas join the cluster at startup, before any request initializes them, so that they are discovered right
+away.
+*/
+func TestClusterGossipLazy(t *testing.T) {
+	urls, stop := startCluster(t, 3, time.Hour, true, true)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var response api.ClusterResponse
+		getJSON(t, urls[0]+"/cluster", &response)
+		alive := 0
+		for _, member := range response.Members {
+			if member.State == cluster.StateAlive {
+				alive++
+			}
+		}
+		if alive == len(urls) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all '%v' replicas to be discovered, got '%+v'\n", len(urls), response)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -6,13 +6,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
-	server := api.NewServer(api.ParseEnvironment())
+	env := api.ParseEnvironment()
+	server := api.NewServer(env)
 	server.Logger.Println("Server is starting...")
 	server.Routes()
+	if env.TLSCert != "" || env.TLSKey != "" {
+		if err := server.EnableTLS(env.TLSCert, env.TLSKey, env.ClientCA, env.ClientCertOptional, env.PeerCA); err != nil {
+			server.Logger.Fatalf("Could not enable TLS: %v\n", err)
+		}
+	}
+	if env.EagerInit {
+		server.Initialize()
+	}
+	server.JoinCluster()
 
 	done := make(chan bool)
 	quit := make(chan os.Signal, 1)
@@ -23,24 +34,56 @@ func main() {
 		signal := <-quit
 		server.Logger.Printf("Server received signal '%v'.", signal)
 
-		if err := server.PersistState(); err != nil {
-			server.Logger.Fatalf("Could not save state to disk: %v\n", err)
-		}
-
 		server.Logger.Println("Shutting down...")
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		server.SetKeepAlivesEnabled(false)
 		if err := server.Shutdown(ctx); err != nil {
-			server.Logger.Fatalf("Could not gracefully shutdown the server: %v\n", err)
+			server.Logger.Printf("Could not gracefully shutdown the server: %v\n", err)
+		}
+
+		// requests still in flight are drained before the final snapshot is taken
+		server.Stop()
+		if err := server.PersistState(); err != nil {
+			server.Logger.Fatalf("Could not save state to disk: %v\n", err)
 		}
-		server.CloseChannels()
 		close(done)
 	}()
 
+	// renewed certificates are picked up without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if !server.TLSEnabled() {
+				server.Logger.Println("Received SIGHUP without TLS enabled. Nothing to reload.")
+				continue
+			}
+			if err := server.ReloadTLS(); err != nil {
+				server.Logger.Printf("Could not reload TLS: %v. Keeping the current certificate.\n", err)
+			}
+		}
+	}()
+
+	if env.RESPAddress != "" {
+		go func() {
+			if err := server.ListenAndServeRESP(env.RESPAddress); err != nil {
+				server.Logger.Fatalf("Could not serve RESP on %s: %v\n", env.RESPAddress, err)
+			}
+		}()
+	}
+
+	if env.RPCAddress != "" {
+		go func() {
+			if err := server.ListenAndServeRPC(env.RPCAddress); err != nil {
+				server.Logger.Fatalf("Could not serve RPC on %s: %v\n", env.RPCAddress, err)
+			}
+		}()
+	}
+
 	server.Logger.Println("Server is ready to handle requests at", server.Addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := server.ListenAndServeHTTP(); err != nil && err != http.ErrServerClosed {
 		server.Logger.Fatalf("Could not listen on %s: %v\n", server.Addr, err)
 	}
 
@@ -9,10 +9,15 @@ import (
   one second. This is done with the fields of the RequestCount structure.
 - the total accumulated requests within the persistence timeframe - configurable via the environment variable.
   This is done with the additional 'TotalRequestsWithinTimeframe' field.
+- the distinct clients of the past within the persistence timeframe, with 'PastClientsWithinTimeframe'. Unlike counts,
+  distinct clients cannot be added up, so those of the present are kept apart. See TotalClients().
+- the decayed rate of requests per second as of the latest request, with 'RatePerSecond', if enabled. See DecayedRate.
 */
 type Cache struct {
 	RequestCount
 	TotalRequestsWithinTimeframe int
+	PastClientsWithinTimeframe   *HyperLogLog
+	RatePerSecond                float64
 }
 
 /* A new cache will be created when a new request comes in with a timestamp that differs by at least one unit of the
@@ -20,17 +25,36 @@ current precision of the algorithm. When that occurs, a new cache shall hold the
 requests within the persistence timeframe, using the new timestamp as a reference. Refer to 'UpdateTotals()' for details.
 */
 func NewCache(timestamp time.Time, totalAccumulated int) Cache {
+	return NewWeightedCache(timestamp, totalAccumulated, 1)
+}
+
+/* Same as NewCache, for a request that counts for the given weight rather than for one. Weights let the window track
+units such as cost or bytes instead of requests.
+*/
+func NewWeightedCache(timestamp time.Time, totalAccumulated int, weight int) Cache {
 	requestCount := RequestCount{Timestamp: timestamp}
-	requestCount.Increment()
+	requestCount.Add(weight)
 	return Cache{
 		RequestCount:                 requestCount,
-		TotalRequestsWithinTimeframe: totalAccumulated + 1,
+		TotalRequestsWithinTimeframe: totalAccumulated + weight,
 	}
 }
 
+/* Estimate of the distinct clients within the persistence timeframe, those of the present included.
+ */
+func (c Cache) TotalClients() int {
+	return c.PastClientsWithinTimeframe.Merge(c.Clients).Estimate()
+}
+
 /* Counters for the current timestamp and the global amount of requests are handled independently of each other
  */
 func (c *Cache) Increment() {
-	c.RequestCount.Increment()
-	c.TotalRequestsWithinTimeframe++
+	c.Add(1)
+}
+
+/* Counts several requests at once, as if Increment() was called n times.
+ */
+func (c *Cache) Add(n int) {
+	c.RequestCount.Add(n)
+	c.TotalRequestsWithinTimeframe += n
 }
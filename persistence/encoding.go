@@ -9,10 +9,33 @@ import (
 /* The total amount of requests of the system can only be obtained together with the counter - which keeps past data
 within the persistence time frame, and the current cached data - which keeps accumulated, request counts for the present
 point in time according to the precision of the algorithm.
+Keys holds the state of every counter that is addressed by a key, rather than being the counter of the server itself.
+Statuses holds the state of the counters of responses by the class of their status code, such as "2xx" or "5xx".
+The states of keys and statuses do not have keys or statuses on their own.
+Rate holds the decayed count of requests, if enabled. See DecayedRate.
+Windows holds the approximate count of requests, if enabled instead of the past. See FixedWindows.
+Limiters holds the state of the rate limiters of routes, by the key they limit. See Limiter.
+Quotas holds the usage of the quotas of keys within their calendar window. See QuotaUsage.
 */
 type State struct {
-	Past    RequestCounter
-	Present Cache
+	Past     RequestCounter
+	Present  Cache
+	Keys     map[string]State
+	Statuses map[string]State
+	Rate     DecayedRate
+	Windows  FixedWindows
+	Limiters map[string]Limiter
+	Quotas   map[string]QuotaUsage
+}
+
+/* Request counts of all points in time known to the state, oldest first: those of the past, followed by the present.
+ */
+func (s State) RequestCounts() []RequestCount {
+	requestCounts := s.Past.RequestCounts()
+	if !s.Present.Empty() {
+		requestCounts = append(requestCounts, s.Present.RequestCount)
+	}
+	return requestCounts
 }
 
 /*A request counter is, in terms of data, just two pointers. However, they represent a list of nodes. When serialising
@@ -21,6 +44,19 @@ acts as an intermediate step during serialization of state to ensure that all da
 Fields need be exported for encoding purposes
 */
 type internalState struct {
+	Past     requestCountList
+	Present  Cache
+	Keys     map[string]internalKeyState
+	Statuses map[string]internalKeyState
+	Rate     DecayedRate
+	Windows  FixedWindows
+	Limiters map[string]Limiter
+	Quotas   map[string]QuotaUsage
+}
+
+/* internalState representation of the state of a key or a status class.
+ */
+type internalKeyState struct {
 	Past    requestCountList
 	Present Cache
 }
@@ -29,9 +65,15 @@ type internalState struct {
  */
 func (s State) encode() ([]byte, error) {
 	internalState := internalState{
-		Past:    s.Past.getNodes(),
-		Present: s.Present,
+		Past:     s.Past.getNodes(),
+		Present:  s.Present,
+		Rate:     s.Rate,
+		Windows:  s.Windows,
+		Limiters: s.Limiters,
+		Quotas:   s.Quotas,
 	}
+	internalState.Keys = encodeKeyStates(s.Keys)
+	internalState.Statuses = encodeKeyStates(s.Statuses)
 	b := new(bytes.Buffer)
 	e := gob.NewEncoder(b)
 	err := e.Encode(internalState)
@@ -54,13 +96,43 @@ func decodeState(buffer []byte) (State, error) {
 	}
 
 	decodedState := State{
-		Past:    decodedInternalState.Past.ToRequestCounter(),
-		Present: decodedInternalState.Present,
+		Past:     decodedInternalState.Past.ToRequestCounter(),
+		Present:  decodedInternalState.Present,
+		Rate:     decodedInternalState.Rate,
+		Windows:  decodedInternalState.Windows,
+		Limiters: decodedInternalState.Limiters,
+		Quotas:   decodedInternalState.Quotas,
 	}
+	decodedState.Keys = decodeKeyStates(decodedInternalState.Keys)
+	decodedState.Statuses = decodeKeyStates(decodedInternalState.Statuses)
 
 	return decodedState, nil
 }
 
+/* Converts the states of keys to their internalState representation. Empty maps are left out.
+ */
+func encodeKeyStates(states map[string]State) map[string]internalKeyState {
+	if len(states) == 0 {
+		return nil
+	}
+	internalStates := make(map[string]internalKeyState, len(states))
+	for key, keyState := range states {
+		internalStates[key] = internalKeyState{Past: keyState.Past.getNodes(), Present: keyState.Present}
+	}
+	return internalStates
+}
+
+func decodeKeyStates(internalStates map[string]internalKeyState) map[string]State {
+	if len(internalStates) == 0 {
+		return nil
+	}
+	states := make(map[string]State, len(internalStates))
+	for key, keyState := range internalStates {
+		states[key] = State{Past: keyState.Past.ToRequestCounter(), Present: keyState.Present}
+	}
+	return states
+}
+
 /* Resulting file will only be readable and writable by the current user
  */
 func (s State) WriteToFile(path string) error {
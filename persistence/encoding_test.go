@@ -10,25 +10,65 @@ import (
 type encodeStateTest struct {
 	statePastData requestCountList //a linked list will be constructed with these values in the same order of the slice
 	statePresent  Cache
+	stateKeys     map[string]State
+	stateStatuses map[string]State
+	stateRate     DecayedRate
+	stateWindows  FixedWindows
+	stateLimiters map[string]Limiter
+	stateQuotas   map[string]QuotaUsage
 }
 
 var encodeStateTestList = []encodeStateTest{
 	{ // varied values
 		statePastData: requestCountList{
 			{Timestamp: time.Date(1111, 11, 11, 11, 11, 11, 111111111, time.UTC), Count: 1, Accumulated: 11},
-			{Timestamp: time.Date(2222, 22, 22, 22, 22, 22, 222222222, time.UTC), Count: 2, Accumulated: 22},
-			{Timestamp: time.Date(3333, 33, 33, 33, 33, 33, 333333333, time.UTC), Count: 3, Accumulated: 33},
+			{Timestamp: time.Date(2222, 22, 22, 22, 22, 22, 222222222, time.UTC), Count: 2, Accumulated: 22, Aggregates: Aggregates{Requests: 2, Bytes: 222, Latency: 22 * time.Millisecond, MaxLatency: 12 * time.Millisecond, Latencies: &Sketch{Bins: map[int]int{497: 1, 469: 1}}}},
+			{Timestamp: time.Date(3333, 33, 33, 33, 33, 33, 333333333, time.UTC), Count: 3, Accumulated: 33, Clients: NewHyperLogLog("a", "b")},
 			{Timestamp: time.Date(4444, 44, 44, 44, 44, 44, 444444444, time.UTC), Count: 4, Accumulated: 44},
 		},
 		statePresent: Cache{
 			RequestCount:                 RequestCount{Timestamp: time.Date(5555, 55, 55, 55, 55, 55, 555555555, time.UTC), Count: 5, Accumulated: 55},
 			TotalRequestsWithinTimeframe: 6666,
+			PastClientsWithinTimeframe:   NewHyperLogLog("a", "b", "c"),
+			RatePerSecond:                7.5,
 		},
+		stateRate:    DecayedRate{Value: 77.7, Timestamp: time.Date(5555, 55, 55, 55, 55, 55, 555555555, time.UTC)},
+		stateWindows: FixedWindows{Start: time.Date(5555, 55, 55, 55, 55, 0, 0, time.UTC), Current: 8, Previous: 88},
+		stateLimiters: map[string]Limiter{
+			"/ 10.0.0.1":     {Tokens: 2.5, Timestamp: time.Date(5555, 55, 55, 55, 55, 55, 555555555, time.UTC)},
+			"/hits 10.0.0.2": {TAT: time.Date(6666, 66, 66, 66, 66, 66, 666666666, time.UTC)},
+		},
+		stateQuotas: map[string]QuotaUsage{"tenant:1": {Start: time.Date(7777, 7, 1, 0, 0, 0, 0, time.UTC), Used: 77}},
 	},
 	{ // no values
 		statePastData: requestCountList{},
 		statePresent:  Cache{},
 	},
+	{ // keys
+		statePastData: requestCountList{},
+		statePresent:  Cache{},
+		stateKeys: map[string]State{
+			"a": {
+				Past: requestCountList{
+					{Timestamp: time.Date(1111, 11, 11, 11, 11, 11, 111111111, time.UTC), Count: 1, Accumulated: 3},
+					{Timestamp: time.Date(2222, 22, 22, 22, 22, 22, 222222222, time.UTC), Count: 2, Accumulated: 2},
+				}.ToRequestCounter(),
+				Present: NewCache(time.Date(3333, 33, 33, 33, 33, 33, 333333333, time.UTC), 3),
+			},
+			"b": {Present: NewCache(time.Date(4444, 44, 44, 44, 44, 44, 444444444, time.UTC), 0)},
+		},
+	},
+	{ // statuses
+		statePastData: requestCountList{},
+		statePresent:  Cache{},
+		stateStatuses: map[string]State{
+			"2xx": {
+				Past:    requestCountList{{Timestamp: time.Date(1111, 11, 11, 11, 11, 11, 111111111, time.UTC), Count: 4, Accumulated: 4, Top: (&HeavyHitters{}).Add("ip", "10.0.0.1", 3).Add("path", "/", 4)}}.ToRequestCounter(),
+				Present: NewCache(time.Date(2222, 22, 22, 22, 22, 22, 222222222, time.UTC), 4),
+			},
+			"5xx": {Present: NewCache(time.Date(3333, 33, 33, 33, 33, 33, 333333333, time.UTC), 0)},
+		},
+	},
 }
 
 func TestEncodeState(t *testing.T) {
@@ -41,7 +81,7 @@ func TestEncodeState(t *testing.T) {
 	filePath := testDir + "/encodedState.bin"
 
 	for testIndex, test := range encodeStateTestList {
-		providedState := State{Past: test.statePastData.ToRequestCounter(), Present: test.statePresent}
+		providedState := State{Past: test.statePastData.ToRequestCounter(), Present: test.statePresent, Keys: test.stateKeys, Statuses: test.stateStatuses, Rate: test.stateRate, Windows: test.stateWindows, Limiters: test.stateLimiters, Quotas: test.stateQuotas}
 		err := providedState.WriteToFile(filePath)
 		if err != nil {
 			t.Fatalf("Error writing state to path '%v'.\nTest: '%v'\n Data: '%v'\n \nError: '%v'\n", filePath, testIndex, test, err)
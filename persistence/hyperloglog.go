@@ -0,0 +1,121 @@
+package persistence
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+/* Number of bits of the hash of a value that select its register. A HyperLogLog has 2^HyperLogLogPrecision registers,
+which gives estimates with a standard error of 1.04 / sqrt(2^HyperLogLogPrecision): about 3%.
+*/
+const HyperLogLogPrecision = 10
+
+const hyperLogLogRegisters = 1 << HyperLogLogPrecision
+
+/* Estimator of the number of distinct values, such as clients, that have been added to it. Every value is hashed; the
+first bits of the hash select a register, which keeps the longest run of leading zeros seen in the remaining bits.
+HyperLogLogs of different points in time are merged by keeping the highest value of every register, which gives the
+same estimator as if all values had been added to one.
+As for Sketch, HyperLogLogs are never modified once built, and a nil HyperLogLog is an empty one.
+Fields need be exported for encoding purposes.
+*/
+type HyperLogLog struct {
+	Registers []byte
+}
+
+/* HyperLogLog of the given values. Nil if there are none.
+ */
+func NewHyperLogLog(values ...string) *HyperLogLog {
+	var h *HyperLogLog
+	for _, value := range values {
+		h = h.Add(value)
+	}
+	return h
+}
+
+/* HyperLogLog of the values of the receiver along with the given one. The receiver is not modified: it is returned as
+is if the value does not change any register, which is the case for most values once an estimator has seen some.
+Empty values are not counted.
+*/
+func (h *HyperLogLog) Add(value string) *HyperLogLog {
+	if value == "" {
+		return h
+	}
+	hash := hashValue(value)
+	register := hash >> (64 - HyperLogLogPrecision)
+	// the guard bit bounds the run of zeros for hashes whose remaining bits are all zero
+	rank := byte(bits.LeadingZeros64(hash<<HyperLogLogPrecision|1<<(HyperLogLogPrecision-1)) + 1)
+
+	if h != nil && h.Registers[register] >= rank {
+		return h
+	}
+	added := &HyperLogLog{Registers: make([]byte, hyperLogLogRegisters)}
+	if h != nil {
+		copy(added.Registers, h.Registers)
+	}
+	added.Registers[register] = rank
+	return added
+}
+
+/* 64 bit FNV-1a, followed by the finalizer of SplitMix64 to spread similar values, such as IP addresses, over all bits.
+Hashes must not depend on the process, as HyperLogLogs are persisted.
+*/
+func hashValue(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	hash := h.Sum64()
+	hash = (hash ^ (hash >> 30)) * 0xbf58476d1ce4e5b9
+	hash = (hash ^ (hash >> 27)) * 0x94d049bb133111eb
+	return hash ^ (hash >> 31)
+}
+
+/* HyperLogLog of the values of both the receiver and the provided one. Neither of them is modified; if either is
+empty, the other one is returned as is.
+*/
+func (h *HyperLogLog) Merge(other *HyperLogLog) *HyperLogLog {
+	if h == nil {
+		return other
+	}
+	if other == nil {
+		return h
+	}
+	merged := &HyperLogLog{Registers: make([]byte, hyperLogLogRegisters)}
+	copy(merged.Registers, h.Registers)
+	merged.mergeInto(other)
+	return merged
+}
+
+/* Merges the other HyperLogLog into the receiver, which is modified. Only meant for HyperLogLogs that have not been
+shared yet.
+*/
+func (h *HyperLogLog) mergeInto(other *HyperLogLog) {
+	for i, rank := range other.Registers {
+		if rank > h.Registers[i] {
+			h.Registers[i] = rank
+		}
+	}
+}
+
+/* Estimate of the number of distinct values added. Small numbers, for which many registers are still empty, are
+estimated by linear counting instead.
+*/
+func (h *HyperLogLog) Estimate() int {
+	if h == nil {
+		return 0
+	}
+	m := float64(hyperLogLogRegisters)
+	sum := 0.0
+	empty := 0
+	for _, rank := range h.Registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			empty++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && empty > 0 {
+		estimate = m * math.Log(m/float64(empty))
+	}
+	return int(math.Round(estimate))
+}
@@ -0,0 +1,102 @@
+package persistence
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func clientNames(from int, to int) []string {
+	names := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		names = append(names, fmt.Sprintf("10.0.%v.%v", i/256, i%256))
+	}
+	return names
+}
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	// three standard errors
+	tolerance := 3 * 1.04 / math.Sqrt(hyperLogLogRegisters)
+	for _, distinct := range []int{0, 1, 10, 100, 1000, 20000} {
+		names := clientNames(0, distinct)
+		// every client is added twice
+		h := NewHyperLogLog(append(names, names...)...)
+
+		estimate := h.Estimate()
+		if math.Abs(float64(estimate-distinct)) > tolerance*float64(distinct) {
+			t.Fatalf("Expected an estimate within %v of '%v' distinct clients, got '%v'\n", tolerance, distinct, estimate)
+		}
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	first := NewHyperLogLog(clientNames(0, 600)...)
+	second := NewHyperLogLog(clientNames(400, 1000)...)
+	firstRegisters := append([]byte(nil), first.Registers...)
+
+	merged := first.Merge(second)
+	if expected := NewHyperLogLog(clientNames(0, 1000)...); !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected the merged HyperLogLog to match the one of all clients, estimated '%v' and '%v'\n", merged.Estimate(), expected.Estimate())
+	}
+	if !reflect.DeepEqual(first.Registers, firstRegisters) {
+		t.Fatalf("Expected merged HyperLogLogs to be left as they were\n")
+	}
+
+	var empty *HyperLogLog
+	if empty.Merge(first) != first || first.Merge(empty) != first || empty.Merge(empty) != nil || empty.Add("") != nil {
+		t.Fatalf("Expected merging with an empty HyperLogLog to return the other one\n")
+	}
+	if first.Add(clientNames(0, 1)[0]) != first {
+		t.Fatalf("Expected adding a client that does not change any register to return the HyperLogLog as is\n")
+	}
+}
+
+func TestRequestCounter_TotalClients(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	shared := NewHyperLogLog(clientNames(0, 100)...)
+	sharedRegisters := append([]byte(nil), shared.Registers...)
+	list := requestCountList{
+		{Timestamp: t0, Count: 100, Clients: shared},
+		{Timestamp: t0.Add(time.Second), Count: 1},
+		{Timestamp: t0.Add(2 * time.Second), Count: 100, Clients: NewHyperLogLog(clientNames(50, 150)...)},
+		{Timestamp: t0.Add(3 * time.Second), Count: 100, Clients: NewHyperLogLog(clientNames(100, 200)...)},
+	}.ToRequestCounter()
+
+	if total, expected := list.TotalClients(), NewHyperLogLog(clientNames(0, 200)...); !reflect.DeepEqual(total, expected) {
+		t.Fatalf("Expected the clients of all nodes, estimated '%v' and '%v'\n", total.Estimate(), expected.Estimate())
+	}
+	if !reflect.DeepEqual(shared.Registers, sharedRegisters) {
+		t.Fatalf("Expected the clients of the nodes to be left as they were\n")
+	}
+	if total := (requestCountList{{Timestamp: t0, Clients: shared}}).ToRequestCounter().TotalClients(); total != shared {
+		t.Fatalf("Expected the clients of a single node to be returned as they are\n")
+	}
+}
+
+/* As the window moves, the clients of the nodes left within it are those of the list, whichever nodes were discarded
+or counted late.
+*/
+func TestRequestCounter_TotalClientsMovingWindow(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	var list RequestCounter
+	for i := 0; i < 40; i++ {
+		timestamp := t0.Add(time.Duration(i) * time.Second)
+		list = list.AppendToTail(RequestCount{Timestamp: timestamp, Count: 1, Clients: NewHyperLogLog(clientNames(10*i, 10*i+10)...)})
+		if i%3 == 0 {
+			// late clients, both of the tail and of a node before it
+			list = list.Insert(RequestCount{Timestamp: timestamp, Count: 1, Clients: NewHyperLogLog(clientNames(1000+i, 1001+i)...)}, time.Second)
+			list = list.Insert(RequestCount{Timestamp: timestamp.Add(-4 * time.Second), Count: 1, Clients: NewHyperLogLog(clientNames(2000+i, 2001+i)...)}, time.Second)
+		}
+		list = list.UpdateTotals(RequestCount{Timestamp: timestamp}, 9*time.Second, time.Second)
+
+		var expected *HyperLogLog
+		for _, node := range list.RequestCounts() {
+			expected = expected.Merge(node.Clients)
+		}
+		if total := list.TotalClients(); !reflect.DeepEqual(total, expected) {
+			t.Fatalf("Step '%v': expected the clients of the nodes within the window, estimated '%v' and '%v'\n", i, total.Estimate(), expected.Estimate())
+		}
+	}
+}
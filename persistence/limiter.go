@@ -0,0 +1,76 @@
+package persistence
+
+import (
+	"time"
+)
+
+/* State of a rate limiter of a key, for either of the algorithms it may follow. Requests are let through at one per
+interval on average, with bursts of up to a given number of requests:
+- Tokens, Timestamp: token bucket. The bucket holds up to the burst of tokens and is refilled with one token per
+  interval; every request takes a token. Tokens is the number of tokens left as of Timestamp.
+- TAT: generic cell rate algorithm (GCRA). The theoretical arrival time is when the next request would be due if
+  requests came exactly at one per interval; requests may come early by up to the burst less one interval.
+Both algorithms let the same requests through. GCRA only needs to keep a timestamp, while the token bucket tells how
+many requests are left.
+Fields need be exported for encoding purposes.
+*/
+type Limiter struct {
+	Tokens    float64
+	Timestamp time.Time
+	TAT       time.Time
+}
+
+/* Takes a token for a request at the given time. Returns whether the request is let through and, if it is not, how
+long it takes for the next token to be available. A limiter without a timestamp starts with a full bucket.
+*/
+func (l Limiter) TakeToken(now time.Time, interval time.Duration, burst int) (Limiter, bool, time.Duration) {
+	tokens := float64(burst)
+	if !l.Timestamp.IsZero() {
+		tokens = l.Tokens
+		if elapsed := now.Sub(l.Timestamp); elapsed > 0 {
+			tokens += float64(elapsed) / float64(interval)
+		}
+		if tokens > float64(burst) {
+			tokens = float64(burst)
+		}
+	}
+	if now.After(l.Timestamp) {
+		l.Timestamp = now
+	}
+
+	if tokens < 1 {
+		l.Tokens = tokens
+		return l, false, time.Duration((1 - tokens) * float64(interval))
+	}
+	l.Tokens = tokens - 1
+	return l, true, 0
+}
+
+/* Lets a request at the given time through if it is not earlier than the theoretical arrival time by more than the
+burst allows. Returns whether it is let through and, if it is not, how long it takes for it to be.
+*/
+func (l Limiter) TakeCell(now time.Time, interval time.Duration, burst int) (Limiter, bool, time.Duration) {
+	tat := l.TAT
+	if tat.Before(now) {
+		tat = now
+	}
+	tolerance := time.Duration(burst-1) * interval
+	if early := tat.Sub(now); early > tolerance {
+		return l, false, early - tolerance
+	}
+	l.TAT = tat.Add(interval)
+	return l, true, 0
+}
+
+/* A limiter that would let a full burst through at the given time is no different from a new one, and can be
+forgotten.
+*/
+func (l Limiter) Idle(now time.Time, interval time.Duration, burst int) bool {
+	if !l.Timestamp.IsZero() {
+		refilled := l.Timestamp.Add(time.Duration((float64(burst) - l.Tokens) * float64(interval)))
+		if refilled.After(now) {
+			return false
+		}
+	}
+	return !l.TAT.After(now)
+}
@@ -0,0 +1,102 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var limiterTests = []struct {
+	name     string
+	offsets  []time.Duration // time of every request since the first one
+	interval time.Duration
+	burst    int
+	allowed  []bool
+}{
+	{
+		name:     "burst",
+		offsets:  []time.Duration{0, 0, 0, 0},
+		interval: time.Second,
+		burst:    3,
+		allowed:  []bool{true, true, true, false},
+	},
+	{
+		name:     "refill",
+		offsets:  []time.Duration{0, 0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 3 * time.Second, 3 * time.Second},
+		interval: time.Second,
+		burst:    2,
+		allowed:  []bool{true, true, false, true, false, true, true},
+	},
+	{
+		name:     "steady",
+		offsets:  []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 350 * time.Millisecond},
+		interval: 100 * time.Millisecond,
+		burst:    1,
+		allowed:  []bool{true, true, true, true, false},
+	},
+	{
+		name:     "rejected requests do not count",
+		offsets:  []time.Duration{0, 0, 0, 0, time.Second},
+		interval: time.Second,
+		burst:    1,
+		allowed:  []bool{true, false, false, false, true},
+	},
+}
+
+/* Both algorithms let the same requests through.
+ */
+func TestLimiter(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+
+	for _, test := range limiterTests {
+		var bucket, cell Limiter
+		for i, offset := range test.offsets {
+			now := t0.Add(offset)
+			var bucketAllowed, cellAllowed bool
+			var bucketRetry, cellRetry time.Duration
+			bucket, bucketAllowed, bucketRetry = bucket.TakeToken(now, test.interval, test.burst)
+			cell, cellAllowed, cellRetry = cell.TakeCell(now, test.interval, test.burst)
+			if bucketAllowed != test.allowed[i] || cellAllowed != test.allowed[i] {
+				t.Fatalf("Test '%v', request '%v': expected allowed to be '%v', got '%v' for the token bucket and '%v' for GCRA\n", test.name, i, test.allowed[i], bucketAllowed, cellAllowed)
+			}
+			if bucketRetry != cellRetry {
+				t.Fatalf("Test '%v', request '%v': expected the same retry after, got '%v' for the token bucket and '%v' for GCRA\n", test.name, i, bucketRetry, cellRetry)
+			}
+			if test.allowed[i] && bucketRetry != 0 {
+				t.Fatalf("Test '%v', request '%v': expected no retry after for an allowed request, got '%v'\n", test.name, i, bucketRetry)
+			}
+		}
+	}
+}
+
+func TestLimiter_RetryAfter(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	limiter, _, _ := Limiter{}.TakeToken(t0, time.Second, 1)
+	limiter, allowed, retryAfter := limiter.TakeToken(t0.Add(300*time.Millisecond), time.Second, 1)
+	if allowed || retryAfter != 700*time.Millisecond {
+		t.Fatalf("Expected to retry after '700ms', got allowed '%v' and '%v'\n", allowed, retryAfter)
+	}
+	if _, allowed, _ := limiter.TakeToken(t0.Add(300*time.Millisecond+retryAfter), time.Second, 1); !allowed {
+		t.Fatal("Expected the request to be allowed after retrying\n")
+	}
+}
+
+func TestLimiter_Idle(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	tests := []struct {
+		name     string
+		limiter  Limiter
+		elapsed  time.Duration
+		expected bool
+	}{
+		{name: "new", limiter: Limiter{}, expected: true},
+		{name: "token bucket refilling", limiter: Limiter{Tokens: 1, Timestamp: t0}, elapsed: 1500 * time.Millisecond, expected: false},
+		{name: "token bucket refilled", limiter: Limiter{Tokens: 1, Timestamp: t0}, elapsed: 2 * time.Second, expected: true},
+		{name: "GCRA ahead", limiter: Limiter{TAT: t0.Add(time.Second)}, elapsed: 0, expected: false},
+		{name: "GCRA caught up", limiter: Limiter{TAT: t0.Add(time.Second)}, elapsed: time.Second, expected: true},
+	}
+	for _, test := range tests {
+		if idle := test.limiter.Idle(t0.Add(test.elapsed), time.Second, 3); idle != test.expected {
+			t.Fatalf("Test '%v': expected idle to be '%v', got '%v'\n", test.name, test.expected, idle)
+		}
+	}
+}
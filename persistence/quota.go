@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"time"
+)
+
+/* Calendar periods quotas reset with: at midnight, or at midnight of the 1st of the month, in the time zone of the
+quota.
+*/
+const (
+	CalendarDay   = "daily"
+	CalendarMonth = "monthly"
+)
+
+/* Calendar window of the given period the timestamp belongs to, in the given location: [start, end).
+Days are not always 24 hours long, e.g. when daylight saving time starts, so windows follow the calendar of the
+location rather than a fixed duration.
+*/
+func CalendarWindow(timestamp time.Time, period string, location *time.Location) (time.Time, time.Time) {
+	t := timestamp.In(location)
+	if period == CalendarMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	return start, start.AddDate(0, 0, 1)
+}
+
+/* Usage of a quota within its current calendar window, unlike the moving window of a counter:
+- Start: start of the calendar window the usage belongs to. See CalendarWindow.
+- Used: requests counted since.
+Fields need be exported for encoding purposes.
+*/
+type QuotaUsage struct {
+	Start time.Time
+	Used  int
+}
+
+/* Counts n requests at the given timestamp. The usage resets once a request of a later calendar window comes in.
+Requests that arrived late, for a calendar window that has already been reset, are not counted.
+*/
+func (u QuotaUsage) Add(timestamp time.Time, n int, period string, location *time.Location) QuotaUsage {
+	start, _ := CalendarWindow(timestamp, period, location)
+	switch {
+	case start.Equal(u.Start):
+		u.Used += n
+	case start.After(u.Start):
+		u = QuotaUsage{Start: start, Used: n}
+	}
+	return u
+}
+
+/* Usage as of the given time, along with the time it resets at. Usage of a calendar window that has ended is zero.
+ */
+func (u QuotaUsage) At(reference time.Time, period string, location *time.Location) (int, time.Time) {
+	start, end := CalendarWindow(reference, period, location)
+	if !start.Equal(u.Start) {
+		return 0, end
+	}
+	return u.Used, end
+}
@@ -0,0 +1,87 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarWindow(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	tests := []struct {
+		timestamp     time.Time
+		period        string
+		location      *time.Location
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{
+			timestamp:     time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC),
+			period:        CalendarDay,
+			location:      time.UTC,
+			expectedStart: time.Date(2006, 01, 02, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2006, 01, 03, 0, 0, 0, 0, time.UTC),
+		},
+		{ // already the next day in the time zone
+			timestamp:     time.Date(2006, 01, 02, 23, 00, 00, 0, time.UTC),
+			period:        CalendarDay,
+			location:      berlin,
+			expectedStart: time.Date(2006, 01, 03, 0, 0, 0, 0, berlin),
+			expectedEnd:   time.Date(2006, 01, 04, 0, 0, 0, 0, berlin),
+		},
+		{
+			timestamp:     time.Date(2006, 01, 31, 23, 59, 59, 0, time.UTC),
+			period:        CalendarMonth,
+			location:      time.UTC,
+			expectedStart: time.Date(2006, 01, 01, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2006, 02, 01, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			timestamp:     time.Date(2006, 12, 31, 22, 30, 00, 0, time.UTC),
+			period:        CalendarMonth,
+			location:      berlin,
+			expectedStart: time.Date(2007, 01, 01, 0, 0, 0, 0, berlin),
+			expectedEnd:   time.Date(2007, 02, 01, 0, 0, 0, 0, berlin),
+		},
+	}
+	for i, test := range tests {
+		start, end := CalendarWindow(test.timestamp, test.period, test.location)
+		if !start.Equal(test.expectedStart) || !end.Equal(test.expectedEnd) {
+			t.Fatalf("Test '%v': expected window ['%v', '%v'), got ['%v', '%v')\n", i, test.expectedStart, test.expectedEnd, start, end)
+		}
+	}
+}
+
+/* Days follow the calendar of the time zone, and are 23 hours long when daylight saving time starts.
+ */
+func TestCalendarWindow_DaylightSavingTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone data is not available: %v\n", err)
+	}
+	start, end := CalendarWindow(time.Date(2006, 04, 02, 12, 0, 0, 0, newYork), CalendarDay, newYork)
+	if length := end.Sub(start); length != 23*time.Hour {
+		t.Fatalf("Expected the day daylight saving time starts to be 23 hours long, got '%v'\n", length)
+	}
+}
+
+func TestQuotaUsage(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	var usage QuotaUsage
+	usage = usage.Add(t0, 3, CalendarDay, time.UTC)
+	usage = usage.Add(t0.Add(4*time.Hour), 2, CalendarDay, time.UTC)
+	if used, resetsAt := usage.At(t0.Add(4*time.Hour), CalendarDay, time.UTC); used != 5 || !resetsAt.Equal(time.Date(2006, 01, 03, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected '5' requests used until midnight, got '%v' until '%v'\n", used, resetsAt)
+	}
+
+	// late requests of a day that has been reset are not counted
+	usage = usage.Add(t0.Add(6*time.Hour), 1, CalendarDay, time.UTC)
+	usage = usage.Add(t0.Add(4*time.Hour), 7, CalendarDay, time.UTC)
+	if used, _ := usage.At(t0.Add(6*time.Hour), CalendarDay, time.UTC); used != 1 {
+		t.Fatalf("Expected the usage to reset at midnight, got '%v'\n", used)
+	}
+
+	// without requests, the usage resets all the same
+	if used, _ := usage.At(t0.Add(30*time.Hour), CalendarDay, time.UTC); used != 0 {
+		t.Fatalf("Expected no usage on a day without requests, got '%v'\n", used)
+	}
+}
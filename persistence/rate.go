@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"math"
+	"time"
+)
+
+/* Exponentially decayed count of requests: every request counts for less as time goes by, half as much after every
+half-life. Unlike the count within a time frame, it does not jump when requests leave the window, which makes it a
+smooth estimate of the rate of requests. See PerSecond.
+- Value: decayed count as of Timestamp
+- Timestamp: time of the latest request
+Fields need be exported for encoding purposes.
+*/
+type DecayedRate struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+/* Factor by which a count decays over the elapsed time.
+ */
+func decay(elapsed time.Duration, halfLife time.Duration) float64 {
+	return math.Exp(-math.Ln2 * float64(elapsed) / float64(halfLife))
+}
+
+/* Counts n requests at the given timestamp. Requests that arrived late, with a timestamp before the latest one, count
+as much as they would have if they had arrived in time.
+*/
+func (r DecayedRate) Add(timestamp time.Time, n int, halfLife time.Duration) DecayedRate {
+	if timestamp.Before(r.Timestamp) {
+		r.Value += float64(n) * decay(r.Timestamp.Sub(timestamp), halfLife)
+		return r
+	}
+	r.Value = r.Value*decay(timestamp.Sub(r.Timestamp), halfLife) + float64(n)
+	r.Timestamp = timestamp
+	return r
+}
+
+/* Estimate of the requests per second as of the given timestamp. For requests that come at a steady rate, the decayed
+count converges to that rate times halfLife / ln(2), which is thus what the estimate converges to.
+*/
+func (r DecayedRate) PerSecond(timestamp time.Time, halfLife time.Duration) float64 {
+	value := r.Value
+	if timestamp.After(r.Timestamp) {
+		value *= decay(timestamp.Sub(r.Timestamp), halfLife)
+	}
+	return value * math.Ln2 / halfLife.Seconds()
+}
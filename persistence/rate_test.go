@@ -0,0 +1,38 @@
+package persistence
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayedRate(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	halfLife := 10 * time.Second
+
+	// 5 requests per second, every 200ms, for ten half-lives
+	var rate DecayedRate
+	for i := 0; i < 500; i++ {
+		rate = rate.Add(t0.Add(time.Duration(i)*200*time.Millisecond), 1, halfLife)
+	}
+	last := rate.Timestamp
+	if perSecond := rate.PerSecond(last, halfLife); math.Abs(perSecond-5) > 0.25 {
+		t.Fatalf("Expected a steady rate to converge to 5 requests per second, got '%v'\n", perSecond)
+	}
+
+	// without requests, the rate halves with every half-life
+	before := rate.PerSecond(last, halfLife)
+	if after := rate.PerSecond(last.Add(halfLife), halfLife); math.Abs(after-before/2) > 1e-9 {
+		t.Fatalf("Expected the rate to halve after a half-life, from '%v' to '%v'\n", before, after)
+	}
+	if earlier := rate.PerSecond(last.Add(-time.Second), halfLife); earlier != before {
+		t.Fatalf("Expected the rate not to grow back before the latest request, got '%v' instead of '%v'\n", earlier, before)
+	}
+
+	// late requests count as much as they would have in time
+	inTime := DecayedRate{}.Add(t0, 4, halfLife).Add(t0.Add(halfLife), 1, halfLife)
+	late := DecayedRate{}.Add(t0.Add(halfLife), 1, halfLife).Add(t0, 4, halfLife)
+	if math.Abs(inTime.Value-late.Value) > 1e-9 || !late.Timestamp.Equal(inTime.Timestamp) || inTime.Value != 3 {
+		t.Fatalf("Expected late requests to count as if in time: '%+v' and '%+v'\n", inTime, late)
+	}
+}
@@ -10,12 +10,53 @@ import (
 'Count' accumulates the number of received requests that came at the same time, according to the precision of the
 algorithm. When calculating totals from a given reference, 'Accumulated' sums the number of requests received from the
 timestamp of the reference until 'Timestamp'
+'Clients' estimates the number of distinct clients those requests came from. See HyperLogLog.
+'Top' keeps track of the values those requests came with most often. See HeavyHitters.
+'Aggregates' sums up what is known about the responses to those requests. See Aggregates.
 Fields need be exported for encoding purposes.
 */
 type RequestCount struct {
 	Timestamp   time.Time
 	Count       int
 	Accumulated int
+	Clients     *HyperLogLog
+	Top         *HeavyHitters
+	Aggregates
+}
+
+/* Figures about the responses to requests that came at the same time, according to the precision of the algorithm:
+- Requests: number of responses the figures were recorded for. Unlike Count, it is not weighted.
+- Bytes: sum of the sizes of the response bodies
+- Latency: sum of the time taken to serve the requests
+- MaxLatency: longest time taken to serve any of the requests
+- Latencies: sketch of the time taken to serve each of the requests, to estimate percentiles. See Sketch.
+Responses are recorded once they have been written, which is after their requests have been counted.
+*/
+type Aggregates struct {
+	Requests   int
+	Bytes      int64
+	Latency    time.Duration
+	MaxLatency time.Duration
+	Latencies  *Sketch
+}
+
+/* Aggregates of both the receiver and the provided ones.
+ */
+func (a Aggregates) Merge(other Aggregates) Aggregates {
+	a.Requests += other.Requests
+	a.Bytes += other.Bytes
+	a.Latency += other.Latency
+	if other.MaxLatency > a.MaxLatency {
+		a.MaxLatency = other.MaxLatency
+	}
+	a.Latencies = a.Latencies.Merge(other.Latencies)
+	return a
+}
+
+/* Aggregates of a single response.
+ */
+func NewAggregates(bytes int64, latency time.Duration) Aggregates {
+	return Aggregates{Requests: 1, Bytes: bytes, Latency: latency, MaxLatency: latency, Latencies: NewSketch(latency)}
 }
 
 func (r RequestCount) Empty() bool {
@@ -27,8 +68,23 @@ func (r RequestCount) Dump() string {
 }
 
 func (r *RequestCount) Increment() {
-	r.Count++
-	r.Accumulated++
+	r.Add(1)
+}
+
+/* Counts several requests at once, as if Increment() was called n times.
+ */
+func (r *RequestCount) Add(n int) {
+	r.Count += n
+	r.Accumulated += n
+}
+
+/* Adds what is known about the requests of the data, other than their count, to the receiver: their clients, heavy
+hitters and aggregates.
+*/
+func (r *RequestCount) mergeDetails(data RequestCount) {
+	r.Clients = r.Clients.Merge(data.Clients)
+	r.Top = r.Top.merge(data.Top)
+	r.Aggregates = r.Aggregates.Merge(data.Aggregates)
 }
 
 /* Determines if the provided timestamp and that of the receiver are considered to be equal by truncating the time
@@ -38,10 +94,14 @@ func (r RequestCount) CompareTimestampWithPrecision(t time.Time, precision time.
 	return r.Timestamp.Truncate(precision) == t.Truncate(precision)
 }
 
+/* 'clients' is the merge of the clients of the node and of all nodes to its right up to the boundary of the list, for
+nodes up to the boundary. See RequestCounter.
+*/
 type requestCountNode struct {
-	data  RequestCount
-	left  *requestCountNode
-	right *requestCountNode
+	data    RequestCount
+	left    *requestCountNode
+	right   *requestCountNode
+	clients *HyperLogLog
 }
 
 /*
@@ -51,7 +111,13 @@ and as such will be treated as being within the provided duration from the refer
 Used to determine if the receiver node is within the persistence time frame of the reference
 */
 func (node requestCountNode) WithinDurationBefore(duration time.Duration, precision time.Duration, reference RequestCount) (bool, time.Duration) {
-	difference := reference.Timestamp.Sub(node.data.Timestamp)
+	return node.data.WithinDurationBefore(duration, precision, reference)
+}
+
+/* Same as requestCountNode::WithinDurationBefore, for request counts that are not part of a RequestCounter.
+ */
+func (r RequestCount) WithinDurationBefore(duration time.Duration, precision time.Duration, reference RequestCount) (bool, time.Duration) {
+	difference := reference.Timestamp.Sub(r.Timestamp)
 	return difference.Truncate(precision).Nanoseconds() <= duration.Truncate(precision).Nanoseconds(), difference
 }
 
@@ -69,11 +135,23 @@ The workflow is as follows:
 - Update totals will take care of deleting nodes outside of the persistence timeframe by means of FrontDiscardUntil()
 - The result of the UpdateTotals() operation will be available via TotalAccumulatedRequestCount
 
+Distinct clients cannot be subtracted once merged, so they are kept as a queue of two stacks instead of being merged
+over all nodes whenever one leaves the time frame:
+- nodes up to the 'boundary' each know the clients from themselves up to the boundary, so that those of the head are
+  those of the front of the list, whichever nodes were discarded before it
+- 'back' merges the clients of the nodes appended after the boundary
+Once the boundary is discarded, the clients of every node are merged again from the tail, which moves the boundary to
+it: each node is merged once more before it is discarded. Late data that lands before the boundary marks the list as
+'stale', so that the same happens on the next call to UpdateTotals().
+
 This structure is not safe for concurrent usage. The consumer is responsible for synchronizing all access to it.
 */
 type RequestCounter struct {
-	head *requestCountNode
-	tail *requestCountNode
+	head     *requestCountNode
+	tail     *requestCountNode
+	boundary *requestCountNode
+	back     *HyperLogLog
+	stale    bool
 }
 
 /*Used as a data container of a requestCounter, in particular for serialization purposes.
@@ -94,6 +172,12 @@ func (r RequestCounter) getNodes() requestCountList {
 	return nodes
 }
 
+/* Copy of the data of all nodes, from head to tail. Changes to the copy do not affect the list.
+ */
+func (r RequestCounter) RequestCounts() []RequestCount {
+	return r.getNodes()
+}
+
 /* Test instrumentation
  */
 func (r RequestCounter) dump() string {
@@ -132,10 +216,85 @@ func (list RequestCounter) AppendToTail(data RequestCount) RequestCounter {
 		// tail = next of tail
 		list.tail = &newNode
 	}
+	list.back = list.back.Merge(data.Clients)
 
 	return list
 }
 
+/* Counts the data into the list at the position given by its timestamp, for data that arrives late: after data with a
+later timestamp has been appended already. Data that is considered to be the same point in time as a node by the
+precision is added to it, along with its details - see mergeDetails; otherwise, a new node is linked in between its neighbours. Data
+later than the tail is appended. The list is traversed backwards from the tail, as late data is expected to be close to
+it.
+Accumulated values are only accurate again after the next call to UpdateTotals().
+*/
+func (list RequestCounter) Insert(data RequestCount, precision time.Duration) RequestCounter {
+	currentNode := list.tail
+	passedBoundary := false
+	for currentNode != nil && data.Timestamp.Truncate(precision).Before(currentNode.data.Timestamp.Truncate(precision)) {
+		passedBoundary = passedBoundary || currentNode == list.boundary
+		currentNode = currentNode.left
+	}
+
+	switch {
+	case currentNode == list.tail:
+		if currentNode != nil && currentNode.data.CompareTimestampWithPrecision(data.Timestamp, precision) {
+			currentNode.data.Count += data.Count
+			currentNode.data.mergeDetails(data)
+			list = list.mergeClients(data.Clients, currentNode != list.boundary)
+			return list
+		}
+		return list.AppendToTail(data)
+	case currentNode == nil:
+		// earlier than the head
+		newNode := requestCountNode{data: data, right: list.head}
+		list.head.left = &newNode
+		list.head = &newNode
+		list = list.mergeClients(data.Clients, list.boundary == nil && !list.stale)
+	case currentNode.data.CompareTimestampWithPrecision(data.Timestamp, precision):
+		currentNode.data.Count += data.Count
+		currentNode.data.mergeDetails(data)
+		list = list.mergeClients(data.Clients, !passedBoundary && currentNode != list.boundary)
+	default:
+		newNode := requestCountNode{data: data, left: currentNode, right: currentNode.right}
+		currentNode.right.left = &newNode
+		currentNode.right = &newNode
+		list = list.mergeClients(data.Clients, !passedBoundary)
+	}
+	return list
+}
+
+/* Accounts for clients that were counted into a node after it had been linked: merged into 'back' if the node comes
+after the boundary, otherwise the list is stale. See RequestCounter.
+*/
+func (list RequestCounter) mergeClients(clients *HyperLogLog, afterBoundary bool) RequestCounter {
+	if clients == nil {
+		return list
+	}
+	if afterBoundary {
+		list.back = list.back.Merge(clients)
+	} else {
+		list.stale = true
+	}
+	return list
+}
+
+/* Merges the clients of every node again from the tail, which becomes the boundary. See RequestCounter.
+ */
+func (list RequestCounter) mergeAllClients() RequestCounter {
+	for currentNode := list.tail; currentNode != nil; currentNode = currentNode.left {
+		if currentNode.right == nil {
+			currentNode.clients = currentNode.data.Clients
+		} else {
+			currentNode.clients = currentNode.data.Clients.Merge(currentNode.right.clients)
+		}
+	}
+	list.boundary = list.tail
+	list.back = nil
+	list.stale = false
+	return list
+}
+
 /*
 Discard all nodes between head and lastNodeToDiscard from the list. Assumes that lastNodeToDiscard is part of the list
 Specifying tail as lastNodeToDiscard discards all nodes from the list. The resulting list will have head = tail = nil.
@@ -146,26 +305,37 @@ func (list RequestCounter) frontDiscardUntil(lastNodeToDiscard *requestCountNode
 	if lastNodeToDiscard == list.tail {
 		list.head = nil
 		list.tail = nil
+		list.boundary = nil
+		list.back = nil
+		list.stale = false
 	} else {
 		list.head = lastNodeToDiscard.right
 		list.head.left = nil
 	}
+	// without a boundary, the clients of all nodes are only known as a whole
+	discardsBoundary := list.boundary == nil
 
 	for currentNode != nil {
 		atLastNode := false
 		if currentNode == lastNodeToDiscard {
 			atLastNode = true
 		}
+		discardsBoundary = discardsBoundary || currentNode == list.boundary
 
 		temp := currentNode.right
 		currentNode.left = nil
 		currentNode.right = nil
+		currentNode.clients = nil
 		currentNode = temp
 
 		if atLastNode {
 			break
 		}
 	}
+	if discardsBoundary {
+		list.boundary = nil
+		list.stale = list.head != nil
+	}
 
 	return list
 }
@@ -179,6 +349,7 @@ and the 'Accumulated' value of their right node, if available. As such, the tota
 the reference and the provided timeframe will be the 'Accumulated' value of the head of the list.
 Nodes outside of the time frame will be discarded from the list. That is: they will be disconnected from the doubly linked
 list and the memory used by then will be released.
+Once nodes have been discarded, the clients of those left are merged again if needed. See RequestCounter.
 */
 func (list RequestCounter) UpdateTotals(reference RequestCount, timeFrame time.Duration, precision time.Duration) RequestCounter {
 	currentNode := list.tail
@@ -196,6 +367,9 @@ func (list RequestCounter) UpdateTotals(reference RequestCount, timeFrame time.D
 
 		}
 	}
+	if list.stale {
+		list = list.mergeAllClients()
+	}
 
 	return list
 }
@@ -210,3 +384,33 @@ func (list RequestCounter) TotalAccumulatedRequestCount() int {
 		return 0
 	}
 }
+
+/* Distinct clients of all nodes. Like TotalAccumulatedRequestCount, it assumes that UpdateTotals was called before, so
+that only nodes within the time frame are left, and so that the clients need not be merged over all of them. See
+RequestCounter.
+The result may be shared with the list, and must not be modified.
+*/
+func (list RequestCounter) TotalClients() *HyperLogLog {
+	if !list.stale {
+		if list.boundary == nil {
+			return list.back
+		}
+		return list.head.clients.Merge(list.back)
+	}
+	var clients *HyperLogLog
+	owned := false
+	for currentNode := list.head; currentNode != nil; currentNode = currentNode.right {
+		switch {
+		case currentNode.data.Clients == nil:
+		case clients == nil:
+			clients = currentNode.data.Clients
+		case !owned:
+			// the clients of the nodes may be shared, so they are copied once before being merged into
+			clients = clients.Merge(currentNode.data.Clients)
+			owned = true
+		default:
+			clients.mergeInto(currentNode.data.Clients)
+		}
+	}
+	return clients
+}
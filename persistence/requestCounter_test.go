@@ -531,3 +531,76 @@ func TestRequestCount_CompareTimestampWithPrecision(t *testing.T) {
 		}
 	}
 }
+
+type insertTest struct {
+	listData     requestCountList //a linked list will be constructed with these values in the same order of the slice
+	data         RequestCount
+	expectedDump string //expected dumpList() output
+}
+
+var insertTestList = []insertTest{
+	{ // empty list
+		listData:     requestCountList{},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+		expectedDump: "1",
+	},
+	{ // after the tail
+		listData: requestCountList{
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+		},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 02, 0, time.UTC), Count: 2},
+		expectedDump: "12",
+	},
+	{ // same point in time as the tail
+		listData: requestCountList{
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 03, 0, time.UTC), Count: 3},
+		},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 03, 500, time.UTC), Count: 2},
+		expectedDump: "15",
+	},
+	{ // same point in time as a node in the middle
+		listData: requestCountList{
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 02, 0, time.UTC), Count: 2},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 03, 0, time.UTC), Count: 3},
+		},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 02, 999, time.UTC), Count: 4},
+		expectedDump: "163",
+	},
+	{ // in between two nodes
+		listData: requestCountList{
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 04, 0, time.UTC), Count: 4},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 05, 0, time.UTC), Count: 5},
+		},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 02, 0, time.UTC), Count: 2},
+		expectedDump: "1245",
+	},
+	{ // before the head
+		listData: requestCountList{
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 02, 0, time.UTC), Count: 2},
+			{Timestamp: time.Date(2006, 01, 02, 19, 00, 03, 0, time.UTC), Count: 3},
+		},
+		data:         RequestCount{Timestamp: time.Date(2006, 01, 02, 19, 00, 01, 0, time.UTC), Count: 1},
+		expectedDump: "123",
+	},
+}
+
+func TestRequestCounter_Insert(t *testing.T) {
+	for i, test := range insertTestList {
+		list := test.listData.ToRequestCounter()
+		list = list.Insert(test.data, time.Second)
+		if dump := dumpList(list); dump != test.expectedDump {
+			t.Fatalf("Expected '%v', got '%v' for test '%v' with values '%v'.\n", test.expectedDump, dump, i, test)
+		}
+		// links must be consistent in both directions
+		backwards := []byte(dumpListBackwards(list))
+		for left, right := 0, len(backwards)-1; left < right; left, right = left+1, right-1 {
+			backwards[left], backwards[right] = backwards[right], backwards[left]
+		}
+		if string(backwards) != test.expectedDump {
+			t.Fatalf("Expected backwards dump '%v' to mirror '%v' for test '%v'.\n", dumpListBackwards(list), test.expectedDump, i)
+		}
+	}
+}
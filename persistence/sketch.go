@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+/* Relative accuracy of the quantiles estimated by a Sketch: an estimate is within 1% of the actual value.
+ */
+const SketchAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + SketchAccuracy) / (1 - SketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+/* Mergeable quantile sketch of durations, following DDSketch: durations are counted in logarithmically sized bins, so
+that any duration within a bin can be estimated with the relative accuracy of the sketch. Sketches of different points
+in time are merged by adding up the counts of their bins, which gives the same sketch as if all durations had been
+counted into one.
+- Bins: number of durations per bin. The bin of a duration d is ceil(log(d) / log(gamma)), in nanoseconds.
+- Zeros: number of durations too short to be put into a bin
+Sketches are never modified once built, so copies of a RequestCount may share them. A nil sketch is an empty one,
+which keeps request counts without recorded responses comparable and cheap to encode.
+Fields need be exported for encoding purposes.
+*/
+type Sketch struct {
+	Bins  map[int]int
+	Zeros int
+}
+
+/* Sketch of a single duration.
+ */
+func NewSketch(d time.Duration) *Sketch {
+	if d < 1 {
+		return &Sketch{Zeros: 1}
+	}
+	return &Sketch{Bins: map[int]int{sketchBin(d): 1}}
+}
+
+func sketchBin(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / sketchLogGamma))
+}
+
+/* Estimate of the durations of a bin, within the relative accuracy of the sketch from any of them.
+ */
+func sketchValue(bin int) time.Duration {
+	return time.Duration(2 * math.Pow(sketchGamma, float64(bin)) / (sketchGamma + 1))
+}
+
+/* Number of durations counted by the sketch.
+ */
+func (s *Sketch) Count() int {
+	if s == nil {
+		return 0
+	}
+	count := s.Zeros
+	for _, n := range s.Bins {
+		count += n
+	}
+	return count
+}
+
+/* Sketch of the durations of both the receiver and the provided one. Neither of them is modified; if either is empty,
+the other one is returned as is.
+*/
+func (s *Sketch) Merge(other *Sketch) *Sketch {
+	if s.Count() == 0 {
+		return other
+	}
+	if other.Count() == 0 {
+		return s
+	}
+	merged := &Sketch{Zeros: s.Zeros + other.Zeros}
+	if len(s.Bins)+len(other.Bins) == 0 {
+		return merged
+	}
+	merged.Bins = make(map[int]int, len(s.Bins)+len(other.Bins))
+	for bin, n := range s.Bins {
+		merged.Bins[bin] += n
+	}
+	for bin, n := range other.Bins {
+		merged.Bins[bin] += n
+	}
+	return merged
+}
+
+/* Estimate of the q-quantile of the durations, with q between 0 and 1: the duration that a share q of the durations does
+not exceed. Zero for an empty sketch.
+*/
+func (s *Sketch) Quantile(q float64) time.Duration {
+	count := s.Count()
+	if count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(count))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank < s.Zeros {
+		return 0
+	}
+
+	bins := make([]int, 0, len(s.Bins))
+	for bin := range s.Bins {
+		bins = append(bins, bin)
+	}
+	sort.Ints(bins)
+
+	seen := s.Zeros
+	for _, bin := range bins {
+		seen += s.Bins[bin]
+		if seen > rank {
+			return sketchValue(bin)
+		}
+	}
+	return sketchValue(bins[len(bins)-1])
+}
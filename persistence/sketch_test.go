@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/* Sketch of the given durations, built one duration at a time as the communication processor does.
+ */
+func sketchOf(durations ...time.Duration) *Sketch {
+	var sketch *Sketch
+	for _, d := range durations {
+		sketch = sketch.Merge(NewSketch(d))
+	}
+	return sketch
+}
+
+func TestSketch_Quantile(t *testing.T) {
+	// 1ms, 2ms, ..., 1000ms in an order that is not sorted
+	durations := make([]time.Duration, 1000)
+	for i := range durations {
+		durations[i] = time.Duration((i*389)%1000+1) * time.Millisecond
+	}
+	sketch := sketchOf(durations...)
+
+	for _, q := range []float64{0, 0.01, 0.5, 0.95, 0.99, 1} {
+		expected := time.Duration(math.Max(math.Ceil(q*1000), 1)) * time.Millisecond
+		result := sketch.Quantile(q)
+		if relativeError := math.Abs(float64(result-expected)) / float64(expected); relativeError > SketchAccuracy {
+			t.Fatalf("Expected quantile '%v' to be within %v of '%v', got '%v'\n", q, SketchAccuracy, expected, result)
+		}
+	}
+}
+
+var sketchQuantileEdgeTests = []struct {
+	sketch   *Sketch
+	q        float64
+	expected time.Duration
+}{
+	{sketch: nil, q: 0.5, expected: 0},                         // empty
+	{sketch: sketchOf(0, 0, time.Second), q: 0.5, expected: 0}, // zeros are counted
+	{sketch: sketchOf(0, 0, time.Second), q: 1, expected: sketchValue(sketchBin(time.Second))},
+}
+
+func TestSketch_QuantileEdges(t *testing.T) {
+	for i, test := range sketchQuantileEdgeTests {
+		if result := test.sketch.Quantile(test.q); result != test.expected {
+			t.Fatalf("Test '%v': expected quantile '%v' to be '%v', got '%v'\n", i, test.q, test.expected, result)
+		}
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	first := sketchOf(time.Millisecond, 3*time.Millisecond, 0)
+	second := sketchOf(time.Millisecond, time.Second)
+	firstBins := len(first.Bins)
+
+	merged := first.Merge(second)
+	if expected := sketchOf(time.Millisecond, 3*time.Millisecond, 0, time.Millisecond, time.Second); !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected merged sketch '%+v', got '%+v'\n", expected, merged)
+	}
+	if len(first.Bins) != firstBins || first.Count() != 3 || second.Count() != 2 {
+		t.Fatalf("Expected merged sketches to be left as they were, got '%+v' and '%+v'\n", first, second)
+	}
+
+	var empty *Sketch
+	if empty.Merge(first) != first || first.Merge(empty) != first || empty.Merge(empty) != nil {
+		t.Fatalf("Expected merging with an empty sketch to return the other one\n")
+	}
+}
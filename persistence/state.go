@@ -0,0 +1,105 @@
+package persistence
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTooLate          = errors.New("timestamp arrived later than the lateness tolerance")
+	ErrOutsideTimeFrame = errors.New("timestamp is outside of the time frame")
+)
+
+/* Checks whether a request with the given timestamp may still be counted by a counter whose latest timestamp is the
+watermark. Timestamps at or after the watermark - within the precision - always may. Earlier ones, arriving late, may
+as long as they are within the lateness tolerance of the watermark and within the time frame before it.
+*/
+func CheckLateness(watermark time.Time, timestamp time.Time, timeFrame time.Duration, precision time.Duration, lateness time.Duration) error {
+	if watermark.IsZero() || !timestamp.Truncate(precision).Before(watermark.Truncate(precision)) {
+		return nil
+	}
+	reference := RequestCount{Timestamp: watermark}
+	if within, _ := (RequestCount{Timestamp: timestamp}).WithinDurationBefore(timeFrame, precision, reference); !within {
+		return ErrOutsideTimeFrame
+	}
+	if within, _ := (RequestCount{Timestamp: timestamp}).WithinDurationBefore(lateness, precision, reference); !within {
+		return ErrTooLate
+	}
+	return nil
+}
+
+/* Counts n requests at the given timestamp. This is the same workflow the communication processor follows for the
+counter of the server, in a single step - see communication::Start:
+- if the timestamp is considered to be the same point in time as the present by the precision, the present is increased
+- if it is later, the present becomes part of the past, the totals are updated with the timestamp as the reference and
+  a new present is started
+- if it is earlier, the requests arrived late and are inserted into the past. See RequestCounter::Insert.
+  Requests beyond the lateness tolerance, or outside of the time frame, are rejected. See CheckLateness.
+The total within the time frame is available as Present.TotalRequestsWithinTimeframe afterwards.
+Nothing is counted unless n is positive.
+*/
+func (s State) Hit(timestamp time.Time, n int, timeFrame time.Duration, precision time.Duration, lateness time.Duration) (State, error) {
+	return s.HitWith(RequestCount{Timestamp: timestamp, Count: n}, timeFrame, precision, lateness)
+}
+
+/* Same as Hit, for the requests of the data: along with their count, their details are added to the unit of precision
+they belong to. See RequestCount::mergeDetails.
+*/
+func (s State) HitWith(data RequestCount, timeFrame time.Duration, precision time.Duration, lateness time.Duration) (State, error) {
+	timestamp, n := data.Timestamp, data.Count
+	if n <= 0 {
+		return s, nil
+	}
+	if err := CheckLateness(s.Present.Timestamp, timestamp, timeFrame, precision, lateness); err != nil {
+		return s, err
+	}
+	if s.Present.Empty() {
+		s.Present.Timestamp = timestamp
+	}
+
+	if s.Present.CompareTimestampWithPrecision(timestamp, precision) {
+		s.Present.Add(n)
+		s.Present.mergeDetails(data)
+		return s, nil
+	}
+
+	if timestamp.Before(s.Present.Timestamp) {
+		s.Past = s.Past.Insert(data, precision)
+		s.Present.TotalRequestsWithinTimeframe += n
+		return s, nil
+	}
+
+	s.Past = s.Past.AppendToTail(s.Present.RequestCount)
+	s.Past = s.Past.UpdateTotals(RequestCount{Timestamp: timestamp}, timeFrame, precision)
+	s.Present = NewWeightedCache(timestamp, s.Past.TotalAccumulatedRequestCount(), n)
+	s.Present.mergeDetails(data)
+	return s, nil
+}
+
+/* Total of requests within the time frame before the reference, without counting a new one. Requests of the past that
+are outside of the time frame are discarded along the way.
+*/
+func (s State) Count(reference time.Time, timeFrame time.Duration, precision time.Duration) (State, int) {
+	ref := RequestCount{Timestamp: reference}
+	s.Past = s.Past.UpdateTotals(ref, timeFrame, precision)
+	total := s.Past.TotalAccumulatedRequestCount()
+	if !s.Present.Empty() {
+		if within, _ := s.Present.WithinDurationBefore(timeFrame, precision, ref); within {
+			total += s.Present.Count
+		}
+	}
+	return s, total
+}
+
+/* A state without any request left within the time frame can be forgotten. Only meaningful after Count().
+ */
+func (s State) Expired(reference time.Time, timeFrame time.Duration, precision time.Duration) bool {
+	if s.Past.head != nil {
+		return false
+	}
+	if s.Present.Empty() {
+		return true
+	}
+	within, _ := s.Present.WithinDurationBefore(timeFrame, precision, RequestCount{Timestamp: reference})
+	return !within
+}
@@ -0,0 +1,121 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var stateT0 = time.Date(2006, 01, 02, 15, 04, 05, 0, time.UTC)
+
+type stateHitTest struct {
+	hits          []time.Duration // offsets of the hits from stateT0, in order of arrival
+	n             int             // requests counted per hit
+	lateness      time.Duration
+	reference     time.Duration // offset of the reference for the count from stateT0
+	expectedCount int
+	rejected      []error // expected error of every hit, nil if none is expected
+	expired       bool
+}
+
+var stateHitTestList = []stateHitTest{
+	{ // no hits
+		reference:     0,
+		expectedCount: 0,
+		expired:       true,
+	},
+	{ // same point in time
+		hits:          []time.Duration{0, 100 * time.Millisecond, 900 * time.Millisecond},
+		n:             1,
+		reference:     time.Second,
+		expectedCount: 3,
+	},
+	{ // several requests per hit
+		hits:          []time.Duration{0, 100 * time.Millisecond, 2 * time.Second},
+		n:             5,
+		reference:     3 * time.Second,
+		expectedCount: 15,
+	},
+	{ // nothing counted
+		hits:          []time.Duration{0, time.Second},
+		n:             0,
+		reference:     time.Second,
+		expectedCount: 0,
+		expired:       true,
+	},
+	{ // late hits are inserted into the past, and leave the time frame when they should
+		hits:          []time.Duration{0, 4 * time.Second, 2 * time.Second, 3 * time.Second, 100 * time.Millisecond},
+		n:             1,
+		lateness:      4 * time.Second,
+		reference:     8 * time.Second,
+		expectedCount: 3,
+	},
+	{ // late hits beyond the lateness tolerance are rejected
+		hits:          []time.Duration{0, 4 * time.Second, 2 * time.Second, 1 * time.Second},
+		n:             1,
+		lateness:      2 * time.Second,
+		reference:     4 * time.Second,
+		expectedCount: 3,
+		rejected:      []error{nil, nil, nil, ErrTooLate},
+	},
+	{ // late hits outside of the time frame are rejected
+		hits:          []time.Duration{0, 10 * time.Second, 3 * time.Second},
+		n:             1,
+		lateness:      time.Minute,
+		reference:     10 * time.Second,
+		expectedCount: 1,
+		rejected:      []error{nil, nil, ErrOutsideTimeFrame},
+	},
+	{ // without lateness tolerance, only hits of the present are accepted
+		hits:          []time.Duration{2 * time.Second, 2500 * time.Millisecond, 500 * time.Millisecond},
+		n:             1,
+		reference:     3 * time.Second,
+		expectedCount: 2,
+		rejected:      []error{nil, nil, ErrTooLate},
+	},
+	{ // spread within the time frame
+		hits:          []time.Duration{0, time.Second, 2 * time.Second, 2 * time.Second},
+		n:             1,
+		reference:     3 * time.Second,
+		expectedCount: 4,
+	},
+	{ // partially outside of the time frame
+		hits:          []time.Duration{0, time.Second, 5 * time.Second, 6 * time.Second},
+		n:             1,
+		reference:     11 * time.Second,
+		expectedCount: 2,
+	},
+	{ // outside of the time frame
+		hits:          []time.Duration{0, time.Second, 2 * time.Second},
+		n:             1,
+		reference:     time.Minute,
+		expectedCount: 0,
+		expired:       true,
+	},
+}
+
+func TestStateHitAndCount(t *testing.T) {
+	timeFrame, precision := 6*time.Second, time.Second
+	for i, test := range stateHitTestList {
+		var state State
+		for j, hit := range test.hits {
+			var err error
+			state, err = state.Hit(stateT0.Add(hit), test.n, timeFrame, precision, test.lateness)
+			var expected error
+			if test.rejected != nil {
+				expected = test.rejected[j]
+			}
+			if err != expected {
+				t.Fatalf("Expected error '%v' but got '%v' for hit '%v' of test '%v'\n", expected, err, j, i)
+			}
+		}
+
+		reference := stateT0.Add(test.reference)
+		state, count := state.Count(reference, timeFrame, precision)
+		if count != test.expectedCount {
+			t.Fatalf("Expected count '%v' but got '%v' for test '%v'\n", test.expectedCount, count, i)
+		}
+		if expired := state.Expired(reference, timeFrame, precision); expired != test.expired {
+			t.Fatalf("Expected expired to be '%v' but got '%v' for test '%v'\n", test.expired, expired, i)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package persistence
+
+import (
+	"sort"
+)
+
+/* Number of values a SpaceSaving summary keeps track of. It bounds the memory used by every unit of precision, and the
+number of heavy hitters that can be asked for.
+*/
+const TopCapacity = 50
+
+/* Counts of a value as kept by a SpaceSaving summary: the value came at most Count times, and at least Count - Error
+times.
+*/
+type TopCounter struct {
+	Count int
+	Error int
+}
+
+/* Value along with its counts, see TopCounter.
+ */
+type TopEntry struct {
+	Value string
+	TopCounter
+}
+
+/* Summary of the most frequent values, following the Space-Saving algorithm: up to TopCapacity values are counted.
+Once the summary is full, a new value takes the place of the value with the lowest count, and inherits that count as
+its error. Values that come often enough are thus always kept, with a count that overestimates theirs by at most the
+error.
+Fields need be exported for encoding purposes.
+*/
+type SpaceSaving struct {
+	Counters map[string]TopCounter
+}
+
+/* Counts n occurrences of the value. The summary is modified.
+ */
+func (s *SpaceSaving) Add(value string, n int) {
+	if s.Counters == nil {
+		s.Counters = make(map[string]TopCounter)
+	}
+	if counter, ok := s.Counters[value]; ok || len(s.Counters) < TopCapacity {
+		counter.Count += n
+		s.Counters[value] = counter
+		return
+	}
+	minimum := s.entries()[len(s.Counters)-1]
+	delete(s.Counters, minimum.Value)
+	s.Counters[value] = TopCounter{Count: minimum.Count + n, Error: minimum.Count}
+}
+
+/* Counts the values of the other summary into the receiver, which is modified. A value missing from a full summary
+might have come as often as the lowest count of that summary, which is added to both its count and its error. The
+resulting summary keeps the values with the highest counts.
+*/
+func (s *SpaceSaving) merge(other *SpaceSaving) {
+	if s.Counters == nil {
+		s.Counters = make(map[string]TopCounter, len(other.Counters))
+	}
+	ownMissing, otherMissing := s.missing(), other.missing()
+	for value, counter := range s.Counters {
+		if _, ok := other.Counters[value]; !ok {
+			s.Counters[value] = TopCounter{Count: counter.Count + otherMissing, Error: counter.Error + otherMissing}
+		}
+	}
+	for value, counter := range other.Counters {
+		own, ok := s.Counters[value]
+		if !ok {
+			own = TopCounter{Count: ownMissing, Error: ownMissing}
+		}
+		s.Counters[value] = TopCounter{Count: own.Count + counter.Count, Error: own.Error + counter.Error}
+	}
+
+	if len(s.Counters) > TopCapacity {
+		for _, entry := range s.entries()[TopCapacity:] {
+			delete(s.Counters, entry.Value)
+		}
+	}
+}
+
+/* Upper bound of the count of a value the summary does not keep.
+ */
+func (s *SpaceSaving) missing() int {
+	if len(s.Counters) < TopCapacity {
+		return 0
+	}
+	return s.entries()[len(s.Counters)-1].Count
+}
+
+/* Values of the summary with the highest counts first. Values with the same count are sorted by value.
+ */
+func (s *SpaceSaving) entries() []TopEntry {
+	entries := make([]TopEntry, 0, len(s.Counters))
+	for value, counter := range s.Counters {
+		entries = append(entries, TopEntry{Value: value, TopCounter: counter})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	return entries
+}
+
+/* The k values with the highest counts, highest first.
+ */
+func (s *SpaceSaving) Top(k int) []TopEntry {
+	entries := s.entries()
+	if k < len(entries) {
+		entries = entries[:k]
+	}
+	return entries
+}
+
+/* Summaries of the most frequent values of a unit of precision along several dimensions, such as the IP address or
+the path of requests. See SpaceSaving.
+Unlike sketches, heavy hitters are modified in place as requests are counted. They must therefore only be accessed by
+the owner of the request counts, such as the communication processor.
+Fields need be exported for encoding purposes.
+*/
+type HeavyHitters struct {
+	Dimensions map[string]*SpaceSaving
+}
+
+/* Counts n occurrences of the value along the dimension. A nil receiver starts new heavy hitters, which are returned.
+Empty values are not counted.
+*/
+func (h *HeavyHitters) Add(dimension string, value string, n int) *HeavyHitters {
+	if value == "" {
+		return h
+	}
+	if h == nil {
+		h = &HeavyHitters{}
+	}
+	if h.Dimensions == nil {
+		h.Dimensions = make(map[string]*SpaceSaving)
+	}
+	summary, ok := h.Dimensions[dimension]
+	if !ok {
+		summary = &SpaceSaving{}
+		h.Dimensions[dimension] = summary
+	}
+	summary.Add(value, n)
+	return h
+}
+
+/* Counts the heavy hitters of the other unit of precision into the receiver, which is modified. A nil receiver takes
+over the other heavy hitters, which must not be used on their own afterwards.
+*/
+func (h *HeavyHitters) merge(other *HeavyHitters) *HeavyHitters {
+	if h == nil {
+		return other
+	}
+	if other == nil {
+		return h
+	}
+	if h.Dimensions == nil {
+		h.Dimensions = make(map[string]*SpaceSaving, len(other.Dimensions))
+	}
+	for dimension, summary := range other.Dimensions {
+		own, ok := h.Dimensions[dimension]
+		if !ok {
+			own = &SpaceSaving{}
+			h.Dimensions[dimension] = own
+		}
+		own.merge(summary)
+	}
+	return h
+}
+
+/* The k values with the highest of the given exact counts, highest first. Their error is zero.
+ */
+func ExactTop(counts map[string]int, k int) []TopEntry {
+	summary := &SpaceSaving{Counters: make(map[string]TopCounter, len(counts))}
+	for value, count := range counts {
+		summary.Counters[value] = TopCounter{Count: count}
+	}
+	return summary.Top(k)
+}
+
+/* The k most frequent values along the dimension within the request counts, highest first. The heavy hitters of the
+request counts are merged into a new summary and are not modified.
+*/
+func TopWithin(requestCounts []RequestCount, dimension string, k int) []TopEntry {
+	merged := &SpaceSaving{}
+	for _, requestCount := range requestCounts {
+		if requestCount.Top == nil {
+			continue
+		}
+		if summary, ok := requestCount.Top.Dimensions[dimension]; ok {
+			merged.merge(summary)
+		}
+	}
+	return merged.Top(k)
+}
@@ -0,0 +1,81 @@
+package persistence
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/* Stream of values in which value-i comes 1000 / (i + 1) times, interleaved with a long tail of values that come once.
+ */
+func zipfStream(tail int) ([]string, map[string]int) {
+	var stream []string
+	counts := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		value := fmt.Sprintf("value-%v", i)
+		for j := 0; j < 1000/(i+1); j++ {
+			stream = append(stream, value, fmt.Sprintf("tail-%v-%v", i, j%tail))
+			counts[value]++
+		}
+	}
+	return stream, counts
+}
+
+/* Checks that the k most frequent values are ranked, with bounds that hold the actual counts.
+ */
+func checkTop(t *testing.T, top []TopEntry, counts map[string]int, k int) {
+	for i, entry := range top {
+		if i < k && entry.Value != fmt.Sprintf("value-%v", i) {
+			t.Fatalf("Expected 'value-%v' at position '%v', got '%+v'\n", i, i, top)
+		}
+		if actual := counts[entry.Value]; entry.Count < actual || entry.Count-entry.Error > actual {
+			t.Fatalf("Expected the bounds of '%+v' to hold its actual count '%v'\n", entry, actual)
+		}
+	}
+}
+
+func TestSpaceSaving(t *testing.T) {
+	stream, counts := zipfStream(1000)
+	summary := &SpaceSaving{}
+	for _, value := range stream {
+		summary.Add(value, 1)
+	}
+
+	if len(summary.Counters) != TopCapacity {
+		t.Fatalf("Expected the summary to keep '%v' values, got '%v'\n", TopCapacity, len(summary.Counters))
+	}
+	checkTop(t, summary.Top(5), counts, 5)
+	if top := summary.Top(TopCapacity + 1); len(top) != TopCapacity {
+		t.Fatalf("Expected at most '%v' values, got '%v'\n", TopCapacity, len(top))
+	}
+}
+
+func TestTopWithin(t *testing.T) {
+	stream, counts := zipfStream(1000)
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+
+	// the stream is spread over units of precision, as the heavy hitters of a state would be
+	var state State
+	for i, value := range stream {
+		var top *HeavyHitters
+		top = top.Add("value", value, 1).Add("none", "", 1)
+		state, _ = state.HitWith(RequestCount{Timestamp: t0.Add(time.Duration(i%7) * time.Second), Count: 1, Top: top}, time.Minute, time.Second, time.Minute)
+	}
+
+	checkTop(t, TopWithin(state.RequestCounts(), "value", 5), counts, 5)
+	if top := TopWithin(state.RequestCounts(), "none", 5); len(top) != 0 {
+		t.Fatalf("Expected empty values not to be counted, got '%+v'\n", top)
+	}
+}
+
+func TestExactTop(t *testing.T) {
+	expected := []TopEntry{
+		{Value: "b", TopCounter: TopCounter{Count: 3}},
+		{Value: "a", TopCounter: TopCounter{Count: 2}},
+		{Value: "c", TopCounter: TopCounter{Count: 2}},
+	}
+	if top := ExactTop(map[string]int{"a": 2, "b": 3, "c": 2, "d": 1}, 3); !reflect.DeepEqual(top, expected) {
+		t.Fatalf("Expected '%+v', got '%+v'\n", expected, top)
+	}
+}
@@ -0,0 +1,68 @@
+package persistence
+
+import (
+	"math"
+	"time"
+)
+
+/* Approximate count of requests within a time frame in constant memory, following the interpolation of two fixed
+windows: time is split into windows as long as the time frame, and only the counts of the current and the previous
+window are kept. The count within the time frame before a reference is estimated as the count of the current window
+plus the count of the previous window, weighted by how much of it the time frame still overlaps.
+- Start: start of the current window
+- Current: requests counted in the current window
+- Previous: requests counted in the previous window
+The estimate assumes that the requests of the previous window were spread evenly over it, so its error is bounded by
+how unevenly they were: it never exceeds the count of the previous window, and for requests that come at a steady rate,
+it does not exceed the count of a unit of precision.
+Fields need be exported for encoding purposes.
+*/
+type FixedWindows struct {
+	Start    time.Time
+	Current  int
+	Previous int
+}
+
+/* Windows as of the window of the reference: windows that ended before it are moved to the previous one or forgotten.
+A reference before the current window leaves the windows as they are.
+*/
+func (w FixedWindows) advance(reference time.Time, timeFrame time.Duration) FixedWindows {
+	start := reference.Truncate(timeFrame)
+	if !start.After(w.Start) {
+		return w
+	}
+	if !w.Start.IsZero() && start.Sub(w.Start) == timeFrame {
+		w.Previous = w.Current
+	} else {
+		w.Previous = 0
+	}
+	w.Current = 0
+	w.Start = start
+	return w
+}
+
+/* Counts n requests at the given timestamp. Requests that arrived late are counted in the window they belong to, as
+long as it is one of the two kept.
+*/
+func (w FixedWindows) Add(timestamp time.Time, n int, timeFrame time.Duration) FixedWindows {
+	w = w.advance(timestamp, timeFrame)
+	switch {
+	case !timestamp.Before(w.Start):
+		w.Current += n
+	case !timestamp.Before(w.Start.Add(-timeFrame)):
+		w.Previous += n
+	}
+	return w
+}
+
+/* Estimate of the requests within the time frame before the reference.
+ */
+func (w FixedWindows) Count(reference time.Time, timeFrame time.Duration) int {
+	w = w.advance(reference, timeFrame)
+	elapsed := reference.Sub(w.Start)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	overlap := 1 - float64(elapsed)/float64(timeFrame)
+	return w.Current + int(math.Round(float64(w.Previous)*overlap))
+}
@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var fixedWindowsTests = []struct {
+	name     string
+	requests func(unit int) int // requests per unit of precision
+	bound    func(unit int, previous int) int
+}{
+	{
+		name:     "steady",
+		requests: func(unit int) int { return 3 },
+		// the count of a unit of precision
+		bound: func(unit int, previous int) int { return 3 },
+	},
+	{
+		name: "bursts",
+		requests: func(unit int) int {
+			random := rand.New(rand.NewSource(int64(unit)))
+			if random.Intn(4) == 0 {
+				return random.Intn(100)
+			}
+			return 0
+		},
+		// the count of the previous window
+		bound: func(unit int, previous int) int { return previous },
+	},
+	{
+		name: "idle windows",
+		requests: func(unit int) int {
+			if unit%150 < 40 {
+				return 5
+			}
+			return 0
+		},
+		bound: func(unit int, previous int) int { return previous },
+	},
+}
+
+/* Compares the approximate count against the exact count of a state, after every unit of precision.
+ */
+func TestFixedWindows(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 00, 00, 0, time.UTC)
+	timeFrame, precision := time.Minute, time.Second
+
+	for _, test := range fixedWindowsTests {
+		var exact State
+		var approximate FixedWindows
+		for unit := 0; unit < 600; unit++ {
+			timestamp := t0.Add(time.Duration(unit) * precision)
+			requests := test.requests(unit)
+			for i := 0; i < requests; i++ {
+				exact, _ = exact.Hit(timestamp, 1, timeFrame, precision, 0)
+				approximate = approximate.Add(timestamp, 1, timeFrame)
+			}
+
+			var exactCount int
+			exact, exactCount = exact.Count(timestamp, timeFrame, precision)
+			approximateCount := approximate.Count(timestamp, timeFrame)
+			difference := approximateCount - exactCount
+			if difference < 0 {
+				difference = -difference
+			}
+			if bound := test.bound(unit, approximate.advance(timestamp, timeFrame).Previous); difference > bound {
+				t.Fatalf("Test '%v', unit '%v': expected the approximate count '%v' to be within '%v' of the exact count '%v'\n", test.name, unit, approximateCount, bound, exactCount)
+			}
+		}
+	}
+}
+
+func TestFixedWindows_Late(t *testing.T) {
+	t0 := time.Date(2006, 01, 02, 19, 01, 00, 0, time.UTC)
+	windows := FixedWindows{}.Add(t0.Add(10*time.Second), 4, time.Minute)
+	windows = windows.Add(t0.Add(-10*time.Second), 2, time.Minute)
+	windows = windows.Add(t0.Add(-70*time.Second), 1, time.Minute)
+
+	if windows.Current != 4 || windows.Previous != 2 {
+		t.Fatalf("Expected late requests to be counted in the previous window, and older ones to be dropped, got '%+v'\n", windows)
+	}
+	// half of the previous window still overlaps the time frame
+	if count := windows.Count(t0.Add(30*time.Second), time.Minute); count != 5 {
+		t.Fatalf("Expected a count of '5', got '%v'\n", count)
+	}
+	if count := windows.Count(t0.Add(150*time.Second), time.Minute); count != 0 {
+		t.Fatalf("Expected windows that ended to be forgotten, got a count of '%v'\n", count)
+	}
+}
@@ -0,0 +1,155 @@
+/* Minimal implementation of the Redis serialization protocol (RESP), as far as needed to serve Redis clients:
+commands are read either as arrays of bulk strings - what clients send - or as inline commands - what a user typing into
+a telnet session sends. Replies are written as simple strings, errors, integers, bulk strings and arrays.
+See https://redis.io/docs/reference/protocol-spec/
+*/
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// commands with more arguments are rejected, so that a single client cannot exhaust memory
+	MaxArguments = 1024
+	// same for the length of a single argument
+	MaxBulkLength = 64 * 1024
+)
+
+/* Returned for input that does not follow the protocol. The connection cannot be recovered from it, as the reader does
+not know where the next command starts.
+*/
+var ErrProtocol = errors.New("protocol error")
+
+type Reader struct {
+	reader *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+/* Reads the next command and its arguments. Empty inline commands are skipped.
+ */
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "*") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				return fields, nil
+			}
+			continue
+		}
+
+		numArguments, err := strconv.Atoi(line[1:])
+		if err != nil || numArguments < 0 || numArguments > MaxArguments {
+			return nil, fmt.Errorf("%w: invalid multibulk length '%v'", ErrProtocol, line[1:])
+		}
+		if numArguments == 0 {
+			continue
+		}
+		arguments := make([]string, 0, numArguments)
+		for i := 0; i < numArguments; i++ {
+			argument, err := r.readBulkString()
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
+		}
+		return arguments, nil
+	}
+}
+
+func (r *Reader) readBulkString() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("%w: expected '$', got '%v'", ErrProtocol, line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 || length > MaxBulkLength {
+		return "", fmt.Errorf("%w: invalid bulk length '%v'", ErrProtocol, line[1:])
+	}
+
+	buffer := make([]byte, length+2)
+	if _, err := io.ReadFull(r.reader, buffer); err != nil {
+		return "", err
+	}
+	if string(buffer[length:]) != "\r\n" {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return string(buffer[:length]), nil
+}
+
+/* Lines are terminated by CRLF. A bare LF is accepted as well, for the sake of inline commands.
+ */
+func (r *Reader) readLine() (string, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: line too long", ErrProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+/* Replies are buffered until Flush() is called.
+ */
+type Writer struct {
+	writer *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: bufio.NewWriter(w)}
+}
+
+/* Line breaks would corrupt the stream, so they are replaced by spaces.
+ */
+func (w *Writer) WriteSimpleString(s string) error {
+	_, err := w.writer.WriteString("+" + strings.NewReplacer("\r", " ", "\n", " ").Replace(s) + "\r\n")
+	return err
+}
+
+/* By convention, the message starts with an upper case code, e.g. 'ERR unknown command'.
+ */
+func (w *Writer) WriteError(message string) error {
+	_, err := w.writer.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(message) + "\r\n")
+	return err
+}
+
+func (w *Writer) WriteInteger(i int64) error {
+	_, err := w.writer.WriteString(":" + strconv.FormatInt(i, 10) + "\r\n")
+	return err
+}
+
+func (w *Writer) WriteBulkString(s string) error {
+	_, err := w.writer.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+	return err
+}
+
+func (w *Writer) WriteNull() error {
+	_, err := w.writer.WriteString("$-1\r\n")
+	return err
+}
+
+/* Announces an array of the given length. Its elements are written afterwards, one by one.
+ */
+func (w *Writer) WriteArray(length int) error {
+	_, err := w.writer.WriteString("*" + strconv.Itoa(length) + "\r\n")
+	return err
+}
+
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
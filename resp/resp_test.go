@@ -0,0 +1,100 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type readCommandTest struct {
+	input    string
+	expected [][]string
+	err      error // error after the expected commands were read
+}
+
+var readCommandTestList = []readCommandTest{
+	{ // arrays of bulk strings
+		input:    "*2\r\n$4\r\nINCR\r\n$3\r\nkey\r\n*1\r\n$4\r\nPING\r\n",
+		expected: [][]string{{"INCR", "key"}, {"PING"}},
+		err:      io.EOF,
+	},
+	{ // binary safe arguments
+		input:    "*2\r\n$3\r\nGET\r\n$5\r\na\r\nb \r\n",
+		expected: [][]string{{"GET", "a\r\nb "}},
+		err:      io.EOF,
+	},
+	{ // inline commands, empty lines are skipped
+		input:    "PING\r\n\r\nMW.HIT  key\n",
+		expected: [][]string{{"PING"}, {"MW.HIT", "key"}},
+		err:      io.EOF,
+	},
+	{ // bulk string not terminated
+		input:    "*1\r\n$4\r\nPINGXX",
+		expected: [][]string{},
+		err:      ErrProtocol,
+	},
+	{ // invalid multibulk length
+		input:    "*x\r\n",
+		expected: [][]string{},
+		err:      ErrProtocol,
+	},
+	{ // too many arguments
+		input:    "*1025\r\n",
+		expected: [][]string{},
+		err:      ErrProtocol,
+	},
+	{ // missing bulk string
+		input:    "*1\r\n:1\r\n",
+		expected: [][]string{},
+		err:      ErrProtocol,
+	},
+	{ // truncated command
+		input:    "*2\r\n$3\r\nGET\r\n",
+		expected: [][]string{},
+		err:      io.EOF,
+	},
+}
+
+func TestReadCommand(t *testing.T) {
+	for i, test := range readCommandTestList {
+		reader := NewReader(strings.NewReader(test.input))
+		commands := [][]string{}
+		var err error
+		for {
+			var command []string
+			if command, err = reader.ReadCommand(); err != nil {
+				break
+			}
+			commands = append(commands, command)
+		}
+		if !reflect.DeepEqual(commands, test.expected) {
+			t.Fatalf("Expected commands '%q' but got '%q' for test '%v'\n", test.expected, commands, i)
+		}
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Expected error '%v' but got '%v' for test '%v'\n", test.err, err, i)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	writer.WriteSimpleString("OK")
+	writer.WriteError("ERR bad\r\nthing")
+	writer.WriteInteger(-42)
+	writer.WriteBulkString("a\r\nb")
+	writer.WriteNull()
+	writer.WriteArray(0)
+	if buffer.Len() != 0 {
+		t.Fatalf("Expected replies to be buffered until flushed, got '%q'\n", buffer.String())
+	}
+	writer.Flush()
+
+	expected := "+OK\r\n-ERR bad  thing\r\n:-42\r\n$4\r\na\r\nb\r\n$-1\r\n*0\r\n"
+	if buffer.String() != expected {
+		t.Fatalf("Expected '%q' but got '%q'\n", expected, buffer.String())
+	}
+}
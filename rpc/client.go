@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+/* Client for the binary protocol. Calls are serialized over a single connection and are safe for concurrent usage.
+Callers needing more throughput open several clients.
+*/
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID uint32
+}
+
+func Dial(address string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+/* Counts n requests for the key and returns the request count within the time frame, including them.
+ */
+func (c *Client) Hit(key string, n uint32) (uint64, error) {
+	counts, err := c.call(Request{Method: MethodHit, Hits: []Hit{{Key: key, N: n}}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+/* Request count within the time frame for the key, without counting a request.
+ */
+func (c *Client) Count(key string) (uint64, error) {
+	counts, err := c.call(Request{Method: MethodCount, Hits: []Hit{{Key: key}}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+/* Applies all hits as a single operation and returns the request count after each of them, in the same order.
+ */
+func (c *Client) HitMany(hits []Hit) ([]uint64, error) {
+	return c.call(Request{Method: MethodHitMany, Hits: hits})
+}
+
+/* Authenticates the connection with an API key or a signed token, for servers that require it. Must be called before
+any other method.
+*/
+func (c *Client) Auth(token string) error {
+	_, err := c.call(Request{Method: MethodAuth, Token: token})
+	return err
+}
+
+/* Errors reported by the server are returned as Error. Any other error leaves the connection in an unknown state: the
+client should be closed.
+*/
+func (c *Client) call(request Request) ([]uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	request.ID = c.nextID
+	if err := WriteRequest(c.conn, request); err != nil {
+		return nil, err
+	}
+	response, err := ReadResponse(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if response.ID != request.ID {
+		return nil, fmt.Errorf("%w: response '%v' to request '%v'", ErrProtocol, response.ID, request.ID)
+	}
+	if response.Code != "" {
+		return nil, Error{Code: response.Code, Message: response.Message}
+	}
+	if len(response.Counts) != len(request.Hits) {
+		return nil, fmt.Errorf("%w: '%v' counts for '%v' hits", ErrProtocol, len(response.Counts), len(request.Hits))
+	}
+	return response.Counts, nil
+}
@@ -0,0 +1,301 @@
+/* Compact binary protocol to count requests for keyed counters, for callers for which JSON over HTTP is too heavy.
+Messages are exchanged over a TCP connection as length-prefixed frames. All integers are big endian.
+
+	frame:    length uint32 | payload (length bytes)
+	request:  method uint8 | id uint32 | body
+	response: status uint8 | id uint32 | body
+
+Request bodies, by method:
+- Hit:     key | n uint32
+- Count:   key
+- HitMany: numHits uint16 | numHits x (key | n uint32)
+- Auth:    token
+where key and token are: length uint16 | bytes.
+
+Response bodies, by status:
+- StatusOK:    numCounts uint16 | numCounts x count uint64. One count per hit, a single one for Hit and Count, none
+               for Auth.
+- StatusError: code (length uint16 | bytes) | message (length uint16 | bytes)
+
+Responses are sent in the order of the requests. The id is chosen by the client and echoed back, so that responses
+can be matched to requests when these are pipelined. Servers that require authentication reject the other methods
+until the connection has authenticated with Auth.
+*/
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Method uint8
+
+const (
+	MethodHit     Method = 1
+	MethodCount   Method = 2
+	MethodHitMany Method = 3
+	MethodAuth    Method = 4
+)
+
+const (
+	StatusOK    uint8 = 0
+	StatusError uint8 = 1
+)
+
+const (
+	// larger frames are rejected, so that a single client cannot exhaust memory
+	MaxFrameLength = 1 << 20
+	// limited by the encoding of their length
+	MaxKeyLength = 1<<16 - 1
+	MaxHits      = 1<<16 - 1
+)
+
+/* Returned for input that does not follow the protocol. The connection cannot be recovered from it.
+ */
+var ErrProtocol = errors.New("protocol error")
+
+/* Hits n requests for the key. An n of zero only retrieves the request count.
+ */
+type Hit struct {
+	Key string
+	N   uint32
+}
+
+type Request struct {
+	Method Method
+	ID     uint32
+	Hits   []Hit  // a single one, unless the method is MethodHitMany or MethodAuth
+	Token  string // only for MethodAuth
+}
+
+/* Either counts or an error, identified by a code of the error taxonomy of the API, e.g. 'saturated'.
+ */
+type Response struct {
+	ID      uint32
+	Counts  []uint64
+	Code    string
+	Message string
+}
+
+/* Errors reported by the server.
+ */
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameLength {
+		return fmt.Errorf("%w: frame of '%v' bytes exceeds the maximum of '%v'", ErrProtocol, len(payload), MaxFrameLength)
+	}
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxFrameLength {
+		return nil, fmt.Errorf("%w: frame of '%v' bytes exceeds the maximum of '%v'", ErrProtocol, length, MaxFrameLength)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+func appendString(buffer []byte, s string) []byte {
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(s)))
+	return append(buffer, s...)
+}
+
+/* Consumes the payload as it is read. Once an error occurred, all reads return zero values.
+ */
+type decoder struct {
+	payload []byte
+	err     error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.payload) < n {
+		d.err = fmt.Errorf("%w: truncated frame", ErrProtocol)
+		return nil
+	}
+	taken := d.payload[:n]
+	d.payload = d.payload[n:]
+	return taken
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.take(int(d.uint16())))
+}
+
+/* Trailing bytes are a protocol error as well.
+ */
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.payload) > 0 {
+		d.err = fmt.Errorf("%w: '%v' trailing bytes", ErrProtocol, len(d.payload))
+	}
+	return d.err
+}
+
+func WriteRequest(w io.Writer, request Request) error {
+	if request.Method == MethodAuth {
+		if len(request.Hits) != 0 {
+			return fmt.Errorf("%w: method '%v' takes no hits, got '%v'", ErrProtocol, request.Method, len(request.Hits))
+		}
+		if len(request.Token) > MaxKeyLength {
+			return fmt.Errorf("%w: token of '%v' bytes exceeds the maximum of '%v'", ErrProtocol, len(request.Token), MaxKeyLength)
+		}
+		payload := []byte{byte(request.Method)}
+		payload = binary.BigEndian.AppendUint32(payload, request.ID)
+		return writeFrame(w, appendString(payload, request.Token))
+	}
+	if request.Method != MethodHitMany && len(request.Hits) != 1 {
+		return fmt.Errorf("%w: method '%v' takes exactly one hit, got '%v'", ErrProtocol, request.Method, len(request.Hits))
+	}
+	if len(request.Hits) > MaxHits {
+		return fmt.Errorf("%w: '%v' hits exceed the maximum of '%v'", ErrProtocol, len(request.Hits), MaxHits)
+	}
+	payload := []byte{byte(request.Method)}
+	payload = binary.BigEndian.AppendUint32(payload, request.ID)
+	if request.Method == MethodHitMany {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(request.Hits)))
+	}
+	for _, hit := range request.Hits {
+		if len(hit.Key) > MaxKeyLength {
+			return fmt.Errorf("%w: key of '%v' bytes exceeds the maximum of '%v'", ErrProtocol, len(hit.Key), MaxKeyLength)
+		}
+		payload = appendString(payload, hit.Key)
+		if request.Method != MethodCount {
+			payload = binary.BigEndian.AppendUint32(payload, hit.N)
+		}
+	}
+	return writeFrame(w, payload)
+}
+
+func ReadRequest(r io.Reader) (Request, error) {
+	payload, err := readFrame(r)
+	if err != nil {
+		return Request{}, err
+	}
+	d := decoder{payload: payload}
+	request := Request{Method: Method(d.uint8()), ID: d.uint32()}
+	switch request.Method {
+	case MethodHit:
+		request.Hits = []Hit{{Key: d.string(), N: d.uint32()}}
+	case MethodCount:
+		request.Hits = []Hit{{Key: d.string()}}
+	case MethodHitMany:
+		request.Hits = make([]Hit, d.uint16())
+		for i := range request.Hits {
+			request.Hits[i] = Hit{Key: d.string(), N: d.uint32()}
+		}
+	case MethodAuth:
+		request.Token = d.string()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: unknown method '%v'", ErrProtocol, request.Method)
+		}
+	}
+	return request, d.finish()
+}
+
+func WriteResponse(w io.Writer, response Response) error {
+	status := StatusOK
+	if response.Code != "" {
+		status = StatusError
+	}
+	payload := []byte{status}
+	payload = binary.BigEndian.AppendUint32(payload, response.ID)
+	if status == StatusError {
+		payload = appendString(payload, truncate(response.Code))
+		payload = appendString(payload, truncate(response.Message))
+		return writeFrame(w, payload)
+	}
+	if len(response.Counts) > MaxHits {
+		return fmt.Errorf("%w: '%v' counts exceed the maximum of '%v'", ErrProtocol, len(response.Counts), MaxHits)
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(response.Counts)))
+	for _, count := range response.Counts {
+		payload = binary.BigEndian.AppendUint64(payload, count)
+	}
+	return writeFrame(w, payload)
+}
+
+func ReadResponse(r io.Reader) (Response, error) {
+	payload, err := readFrame(r)
+	if err != nil {
+		return Response{}, err
+	}
+	d := decoder{payload: payload}
+	status := d.uint8()
+	response := Response{ID: d.uint32()}
+	switch status {
+	case StatusOK:
+		response.Counts = make([]uint64, d.uint16())
+		for i := range response.Counts {
+			response.Counts[i] = d.uint64()
+		}
+	case StatusError:
+		response.Code, response.Message = d.string(), d.string()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: unknown status '%v'", ErrProtocol, status)
+		}
+	}
+	return response, d.finish()
+}
+
+func truncate(s string) string {
+	if len(s) > MaxKeyLength {
+		return s[:MaxKeyLength]
+	}
+	return s
+}
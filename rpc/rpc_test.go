@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var requestTestList = []Request{
+	{Method: MethodHit, ID: 1, Hits: []Hit{{Key: "a", N: 3}}},
+	{Method: MethodCount, ID: 2, Hits: []Hit{{Key: "key"}}},
+	{Method: MethodHitMany, ID: 3, Hits: []Hit{{Key: "a", N: 1}, {Key: "b", N: 1 << 31}}},
+	{Method: MethodHitMany, ID: 4, Hits: []Hit{}},
+	{Method: MethodAuth, ID: 5, Token: "edge-key"},
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	for i, request := range requestTestList {
+		var buffer bytes.Buffer
+		if err := WriteRequest(&buffer, request); err != nil {
+			t.Fatalf("Could not write request '%v': %v\n", i, err)
+		}
+		read, err := ReadRequest(&buffer)
+		if err != nil || !reflect.DeepEqual(read, request) {
+			t.Fatalf("Expected request '%+v' but got '%+v' (error: %v)\n", request, read, err)
+		}
+	}
+}
+
+var responseTestList = []Response{
+	{ID: 1, Counts: []uint64{42}},
+	{ID: 2, Counts: []uint64{1, 1 << 40}},
+	{ID: 3, Code: "saturated", Message: "Too many requests are waiting to be processed"},
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	for i, response := range responseTestList {
+		var buffer bytes.Buffer
+		if err := WriteResponse(&buffer, response); err != nil {
+			t.Fatalf("Could not write response '%v': %v\n", i, err)
+		}
+		read, err := ReadResponse(&buffer)
+		if err != nil || !reflect.DeepEqual(read, response) {
+			t.Fatalf("Expected response '%+v' but got '%+v' (error: %v)\n", response, read, err)
+		}
+	}
+}
+
+var malformedRequestTestList = [][]byte{
+	{0, 0, 0, 5, 9, 0, 0, 0, 1},                                 // unknown method
+	{0, 0, 0, 6, 1, 0, 0, 0, 1, 0},                              // truncated key length
+	{0, 0, 0, 12, 2, 0, 0, 0, 1, 0, 1, 'a', 0, 0, 0, 0},         // trailing bytes
+	{0, 0x10, 0, 1},                                             // frame too large
+	{0, 0, 0, 14, 3, 0, 0, 0, 1, 0, 2, 0, 1, 'a', 0, 0, 0, 0x1}, // fewer hits than announced
+}
+
+func TestReadMalformedRequest(t *testing.T) {
+	for i, frame := range malformedRequestTestList {
+		if _, err := ReadRequest(bytes.NewReader(frame)); !errors.Is(err, ErrProtocol) {
+			t.Fatalf("Expected a protocol error for frame '%v', got '%v'\n", i, err)
+		}
+	}
+}